@@ -5,13 +5,18 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	_ "time/tzdata" // task timezones must resolve on systems without zoneinfo
 
 	"github.com/saintbyte/home-ctrl/internal/migrations"
 
 	"github.com/saintbyte/home-ctrl/internal/app"
+	"github.com/saintbyte/home-ctrl/internal/command"
 )
 
 func main() {
+	// Restricted task programs are started through the daemon binary
+	command.RunSandboxHelper()
+
 	// Parse command line flags
 	daemonMode := flag.Bool("daemon", true, "Run as a daemon with signal handling")
 	flag.Parse()
@@ -22,6 +27,15 @@ func main() {
 		return
 	}
 
+	// Run tasks for a server on this machine
+	if len(os.Args) > 1 && os.Args[1] == "agent" {
+		if err := app.RunAgent(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Agent failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	fmt.Println("Starting home-ctrl application...")
 
 	// Initialize application
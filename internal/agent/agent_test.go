@@ -0,0 +1,163 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/saintbyte/home-ctrl/internal/command"
+	"github.com/saintbyte/home-ctrl/internal/config"
+)
+
+// startAgent connects an agent offering the "echo" and "block" commands to hub
+func startAgent(t *testing.T, hub *Hub, server *httptest.Server, name, token string) {
+	t.Helper()
+
+	registry := command.NewRegistry()
+	registry.Register("echo", command.Func(func(ctx context.Context, task config.Task) (*command.Result, error) {
+		output := strings.Join(task.Args, " ") + "\n"
+		if sink := command.OutputSinkFrom(ctx); sink != nil {
+			sink(command.Stdout, strings.TrimSuffix(output, "\n"))
+		}
+		return &command.Result{Output: output, Data: "data"}, nil
+	}))
+	registry.Register("block", command.Func(func(ctx context.Context, task config.Task) (*command.Result, error) {
+		<-ctx.Done()
+		return &command.Result{ExitCode: -1, Output: "stopped\n"}, ctx.Err()
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &Client{Server: server.URL, Name: name, Token: token, Commands: registry, Offer: []string{"echo", "block"}}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+// waitConnected waits until the named agent is connected
+func waitConnected(t *testing.T, hub *Hub, name string) Status {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, status := range hub.Agents() {
+			if status.Name == name && status.Connected {
+				return status
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Agent %s did not connect", name)
+	return Status{}
+}
+
+func TestAgentRunsTasks(t *testing.T) {
+	hub := NewHub(map[string]string{"nas": "secret"})
+	server := httptest.NewServer(hub.Handler())
+	defer server.Close()
+
+	if _, err := hub.Execute(context.Background(), config.Task{Name: "early", Agent: "nas", Command: "echo"}); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Expected ErrNotConnected before the agent connected, got %v", err)
+	}
+
+	startAgent(t, hub, server, "nas", "secret")
+	status := waitConnected(t, hub, "nas")
+	if strings.Join(status.Commands, ",") != "block,echo" {
+		t.Errorf("Expected the offered commands, got %v", status.Commands)
+	}
+
+	var lines []string
+	var mu sync.Mutex
+	ctx := command.WithOutputSink(context.Background(), func(stream, line string) {
+		mu.Lock()
+		defer mu.Unlock()
+		lines = append(lines, stream+": "+line)
+	})
+	result, err := hub.Execute(ctx, config.Task{Name: "hello", Agent: "nas", Command: "echo", Args: []string{"hello", "world"}})
+	if err != nil {
+		t.Fatalf("Execute() failed: %v", err)
+	}
+	if result.Output != "hello world\n" || result.Data != "data" || result.ExitCode != 0 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	mu.Lock()
+	if len(lines) != 1 || lines[0] != "stdout: hello world" {
+		t.Errorf("Expected streamed output, got %v", lines)
+	}
+	mu.Unlock()
+
+	if _, err := hub.Execute(context.Background(), config.Task{Name: "ls", Agent: "nas", Command: "shell"}); err == nil || !strings.Contains(err.Error(), "does not offer") {
+		t.Errorf("Expected an error for a command the agent does not offer, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	result, err = hub.Execute(ctx, config.Task{Name: "wait", Agent: "nas", Command: "block"})
+	if !errors.Is(err, context.Canceled) || result == nil || result.Output != "stopped\n" {
+		t.Errorf("Expected the cancelled run to report the agent's output, got %+v, %v", result, err)
+	}
+}
+
+func TestAgentAuthentication(t *testing.T) {
+	hub := NewHub(map[string]string{"nas": "secret"})
+	server := httptest.NewServer(hub.Handler())
+	defer server.Close()
+
+	location, err := connectURL(server.URL)
+	if err != nil {
+		t.Fatalf("connectURL() failed: %v", err)
+	}
+	for _, client := range []*Client{
+		{Name: "nas", Token: "wrong"},
+		{Name: "router", Token: "secret"},
+	} {
+		if ws, err := client.dial(context.Background(), location); err == nil {
+			ws.Close()
+			t.Errorf("Expected agent %s with token %s to be rejected", client.Name, client.Token)
+		}
+	}
+
+	// Removing an agent from the configuration disconnects it
+	startAgent(t, hub, server, "nas", "secret")
+	waitConnected(t, hub, "nas")
+	hub.SetTokens(map[string]string{})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		hub.mu.Lock()
+		connected := len(hub.agents)
+		hub.mu.Unlock()
+		if connected == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the removed agent to be disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConnectURL(t *testing.T) {
+	tests := map[string]string{
+		"http://home:8080":              "ws://home:8080" + ConnectPath,
+		"https://home.example.com/":     "wss://home.example.com" + ConnectPath,
+		"ws://home:8080/custom/agents":  "ws://home:8080/custom/agents",
+		"wss://home:8443" + ConnectPath: "wss://home:8443" + ConnectPath,
+	}
+	for server, expected := range tests {
+		if location, err := connectURL(server); err != nil || location != expected {
+			t.Errorf("connectURL(%q) = %q, %v; expected %q", server, location, err, expected)
+		}
+	}
+	if _, err := connectURL("ftp://home"); err == nil {
+		t.Errorf("Expected an error for an unsupported scheme")
+	}
+}
@@ -0,0 +1,207 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saintbyte/home-ctrl/internal/command"
+	"github.com/saintbyte/home-ctrl/internal/config"
+	"github.com/saintbyte/home-ctrl/internal/version"
+	"golang.org/x/net/websocket"
+)
+
+// Reconnect delays of an agent that lost its connection
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+)
+
+// outputBuffer is the number of output lines an agent buffers while sending
+const outputBuffer = 256
+
+// Client is the agent side: it connects to the server and runs the tasks it
+// is sent with its registry
+type Client struct {
+	Server   string            // server URL, e.g. "http://home:8080", or the full WebSocket URL
+	Name     string            // agent name configured on the server
+	Token    string            // agent token configured on the server
+	Commands *command.Registry // commands the agent runs
+	Offer    []string          // commands offered to the server, all registered ones if empty
+}
+
+// commands returns the commands offered to the server
+func (c *Client) commands() []string {
+	if len(c.Offer) == 0 {
+		return c.Commands.Names()
+	}
+	var offered []string
+	for _, name := range c.Offer {
+		if _, ok := c.Commands.Get(name); ok {
+			offered = append(offered, name)
+		}
+	}
+	return offered
+}
+
+// connectURL returns the WebSocket URL of the server's agent endpoint
+func connectURL(server string) (string, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", fmt.Errorf("invalid server url: %w", err)
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	case "ws", "wss":
+	default:
+		return "", fmt.Errorf("server url must use http, https, ws or wss, got %q", server)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = ConnectPath
+	}
+	return u.String(), nil
+}
+
+// Run connects to the server and serves it until ctx is cancelled,
+// reconnecting with increasing delays when the connection is lost
+func (c *Client) Run(ctx context.Context) error {
+	location, err := connectURL(c.Server)
+	if err != nil {
+		return err
+	}
+	if c.Name == "" || c.Token == "" {
+		return fmt.Errorf("agent name and token are required")
+	}
+
+	delay := minReconnectDelay
+	for {
+		ws, err := c.dial(ctx, location)
+		if err != nil {
+			fmt.Printf("Failed to connect to %s: %v\n", location, err)
+		} else {
+			fmt.Printf("Connected to %s as agent %s\n", location, c.Name)
+			connected := time.Now()
+			err = c.serve(ctx, ws)
+			if ctx.Err() != nil {
+				return nil
+			}
+			fmt.Printf("Connection to %s lost: %v\n", location, err)
+			if time.Since(connected) > maxReconnectDelay {
+				delay = minReconnectDelay
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+func (c *Client) dial(ctx context.Context, location string) (*websocket.Conn, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	origin := url.URL{Scheme: strings.Replace(u.Scheme, "ws", "http", 1), Host: u.Host}
+	cfg, err := websocket.NewConfig(location, origin.String())
+	if err != nil {
+		return nil, err
+	}
+	cfg.Header.Set("Authorization", "Bearer "+c.Token)
+	cfg.Header.Set(NameHeader, c.Name)
+	cfg.Dialer = &net.Dialer{Timeout: 10 * time.Second}
+	return cfg.DialContext(ctx)
+}
+
+// serve introduces the agent and runs the tasks sent by the server until the
+// connection breaks. Runs still in progress are cancelled then.
+func (c *Client) serve(ctx context.Context, ws *websocket.Conn) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		ws.Close()
+	}()
+
+	out := make(chan message, outputBuffer)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg := <-out:
+				ws.SetWriteDeadline(time.Now().Add(readTimeout))
+				if err := websocket.JSON.Send(ws, msg); err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	send := func(msg message) {
+		select {
+		case out <- msg:
+		case <-ctx.Done():
+		}
+	}
+
+	send(message{Type: typeHello, Name: c.Name, Version: version.Version, Commands: c.commands()})
+
+	var runs sync.Map // run ID: context.CancelFunc
+	for {
+		var msg message
+		ws.SetReadDeadline(time.Now().Add(readTimeout))
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			return err
+		}
+		switch msg.Type {
+		case typePing:
+			send(message{Type: typePong})
+		case typeCancel:
+			if stop, ok := runs.Load(msg.ID); ok {
+				stop.(context.CancelFunc)()
+			}
+		case typeRun:
+			if msg.Task == nil {
+				continue
+			}
+			runCtx, stop := context.WithCancel(ctx)
+			runs.Store(msg.ID, stop)
+			go func(id int64) {
+				defer runs.Delete(id)
+				defer stop()
+				send(c.execute(runCtx, id, *msg.Task, out))
+			}(msg.ID)
+		}
+	}
+}
+
+// execute runs a task sent by the server and returns the result message.
+// Output lines are streamed as long as the send buffer has room; the result
+// always carries the complete output.
+func (c *Client) execute(ctx context.Context, id int64, task config.Task, out chan message) message {
+	if !slices.Contains(c.commands(), task.Command) {
+		return resultMessage(id, nil, fmt.Errorf("task %s: command %s is not offered by agent %s", task.Name, task.Command, c.Name))
+	}
+	fmt.Printf("Running task %s for the server\n", task.Name)
+
+	ctx = command.WithOutputSink(ctx, func(stream, line string) {
+		select {
+		case out <- message{Type: typeOutput, ID: id, Stream: stream, Line: line}:
+		default:
+		}
+	})
+	result, err := c.Commands.Execute(ctx, task)
+	return resultMessage(id, result, err)
+}
@@ -0,0 +1,315 @@
+package agent
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saintbyte/home-ctrl/internal/command"
+	"github.com/saintbyte/home-ctrl/internal/config"
+	"golang.org/x/net/websocket"
+)
+
+// Status describes a configured agent
+type Status struct {
+	Name        string     `json:"name"`
+	Connected   bool       `json:"connected"`
+	Version     string     `json:"version,omitempty"`
+	Commands    []string   `json:"commands"`
+	Address     string     `json:"address,omitempty"`
+	ConnectedAt *time.Time `json:"connected_at,omitempty"`
+	Running     int        `json:"running"`
+}
+
+// Hub accepts the connections of remote agents and runs tasks on them
+type Hub struct {
+	tokens map[string]string // agent name: token
+	agents map[string]*conn  // connected agents by name
+	nextID int64
+	mu     sync.Mutex
+}
+
+// conn is the connection of an agent
+type conn struct {
+	ws          *websocket.Conn
+	name        string
+	version     string
+	commands    []string
+	address     string
+	connectedAt time.Time
+	pending     map[int64]*pendingRun
+	closed      bool
+	writeMu     sync.Mutex
+	mu          sync.Mutex
+}
+
+// pendingRun is a run sent to an agent that has not reported its result yet
+type pendingRun struct {
+	sink command.OutputSink
+	done chan message
+}
+
+// NewHub creates a hub accepting the agents of the configuration
+func NewHub(tokens map[string]string) *Hub {
+	h := &Hub{agents: make(map[string]*conn)}
+	h.SetTokens(tokens)
+	return h
+}
+
+// SetTokens replaces the agents allowed to connect. Agents that are no
+// longer configured or whose token changed are disconnected.
+func (h *Hub) SetTokens(tokens map[string]string) {
+	h.mu.Lock()
+	previous := h.tokens
+	h.tokens = make(map[string]string, len(tokens))
+	for name, token := range tokens {
+		h.tokens[name] = token
+	}
+	var stale []*conn
+	for name, c := range h.agents {
+		if token, ok := tokens[name]; !ok || token != previous[name] {
+			stale = append(stale, c)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, c := range stale {
+		fmt.Printf("Disconnecting agent %s: its token changed or it was removed\n", c.name)
+		c.ws.Close()
+	}
+}
+
+// Handler returns the WebSocket endpoint agents connect to
+func (h *Hub) Handler() http.Handler {
+	return websocket.Server{Handshake: h.handshake, Handler: h.serve}
+}
+
+// handshake rejects agents without a valid name and token with 403 Forbidden
+func (h *Hub) handshake(_ *websocket.Config, req *http.Request) error {
+	name := req.Header.Get(NameHeader)
+	token, _ := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+
+	h.mu.Lock()
+	expected, ok := h.tokens[name]
+	h.mu.Unlock()
+	if !ok || token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+		fmt.Printf("Rejected agent connection from %s\n", req.RemoteAddr)
+		return fmt.Errorf("invalid agent credentials")
+	}
+	return nil
+}
+
+// serve handles the connection of an authenticated agent until it closes
+func (h *Hub) serve(ws *websocket.Conn) {
+	defer ws.Close()
+	name := ws.Request().Header.Get(NameHeader)
+
+	var hello message
+	ws.SetReadDeadline(time.Now().Add(helloTimeout))
+	if err := websocket.JSON.Receive(ws, &hello); err != nil || hello.Type != typeHello || hello.Name != name {
+		fmt.Printf("Agent %s did not introduce itself\n", name)
+		return
+	}
+
+	c := &conn{
+		ws:          ws,
+		name:        name,
+		version:     hello.Version,
+		commands:    hello.Commands,
+		address:     ws.Request().RemoteAddr,
+		connectedAt: time.Now(),
+		pending:     make(map[int64]*pendingRun),
+	}
+	if c.commands == nil {
+		c.commands = []string{}
+	}
+	sort.Strings(c.commands)
+	h.register(c)
+	defer h.unregister(c)
+	fmt.Printf("Agent %s connected from %s with commands: %s\n", name, c.address, strings.Join(c.commands, ", "))
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.keepAlive(stop)
+
+	for {
+		var msg message
+		ws.SetReadDeadline(time.Now().Add(readTimeout))
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			fmt.Printf("Agent %s disconnected: %v\n", name, err)
+			return
+		}
+		switch msg.Type {
+		case typeOutput:
+			if run := c.run(msg.ID); run != nil && run.sink != nil {
+				run.sink(msg.Stream, msg.Line)
+			}
+		case typeResult:
+			if run := c.run(msg.ID); run != nil {
+				select {
+				case run.done <- msg:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// register makes c the connection of its agent, closing an older one
+func (h *Hub) register(c *conn) {
+	h.mu.Lock()
+	old := h.agents[c.name]
+	h.agents[c.name] = c
+	h.mu.Unlock()
+
+	if old != nil {
+		fmt.Printf("Agent %s reconnected, closing its previous connection\n", c.name)
+		old.ws.Close()
+	}
+}
+
+// unregister removes c and fails the runs it did not finish
+func (h *Hub) unregister(c *conn) {
+	h.mu.Lock()
+	if h.agents[c.name] == c {
+		delete(h.agents, c.name)
+	}
+	h.mu.Unlock()
+
+	c.mu.Lock()
+	c.closed = true
+	for id, run := range c.pending {
+		select {
+		case run.done <- message{Type: typeResult, ID: id, Error: fmt.Sprintf("agent %s disconnected", c.name)}:
+		default:
+		}
+	}
+	c.mu.Unlock()
+}
+
+// keepAlive pings the agent until stop is closed, so that both sides notice
+// dead connections
+func (c *conn) keepAlive(stop chan struct{}) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := c.send(message{Type: typePing}); err != nil {
+				c.ws.Close()
+				return
+			}
+		}
+	}
+}
+
+func (c *conn) send(msg message) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.ws.SetWriteDeadline(time.Now().Add(readTimeout))
+	return websocket.JSON.Send(c.ws, msg)
+}
+
+func (c *conn) run(id int64) *pendingRun {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pending[id]
+}
+
+// start registers a run, unless the connection is already closed
+func (c *conn) start(id int64, sink command.OutputSink) (*pendingRun, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, false
+	}
+	run := &pendingRun{sink: sink, done: make(chan message, 1)}
+	c.pending[id] = run
+	return run, true
+}
+
+func (c *conn) finish(id int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, id)
+}
+
+// Execute runs a task on the agent named by task.Agent and waits for its
+// result. The output of the run is passed on to the output sink of ctx.
+// Cancelling ctx cancels the run on the agent.
+func (h *Hub) Execute(ctx context.Context, task config.Task) (*command.Result, error) {
+	h.mu.Lock()
+	c := h.agents[task.Agent]
+	h.nextID++
+	id := h.nextID
+	h.mu.Unlock()
+
+	if c == nil {
+		return nil, fmt.Errorf("task %s: %w: %s", task.Name, ErrNotConnected, task.Agent)
+	}
+	if !slices.Contains(c.commands, task.Command) {
+		return nil, fmt.Errorf("task %s: agent %s does not offer command %s", task.Name, task.Agent, task.Command)
+	}
+	run, ok := c.start(id, command.OutputSinkFrom(ctx))
+	if !ok {
+		return nil, fmt.Errorf("task %s: %w: %s", task.Name, ErrNotConnected, task.Agent)
+	}
+	defer c.finish(id)
+
+	if err := c.send(message{Type: typeRun, ID: id, Task: &task}); err != nil {
+		return nil, fmt.Errorf("task %s: failed to send to agent %s: %w", task.Name, task.Agent, err)
+	}
+
+	select {
+	case msg := <-run.done:
+		return msg.result()
+	case <-ctx.Done():
+	}
+
+	// Give the agent a moment to stop the program and report its output
+	c.send(message{Type: typeCancel, ID: id})
+	timer := time.NewTimer(cancelGrace)
+	defer timer.Stop()
+	select {
+	case msg := <-run.done:
+		result, _ := msg.result()
+		return result, fmt.Errorf("task %s: %w", task.Name, ctx.Err())
+	case <-timer.C:
+		return &command.Result{ExitCode: -1}, fmt.Errorf("task %s: %w", task.Name, ctx.Err())
+	}
+}
+
+// Agents returns the status of the configured agents, sorted by name
+func (h *Hub) Agents() []Status {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	agents := make([]Status, 0, len(h.tokens))
+	for name := range h.tokens {
+		status := Status{Name: name, Commands: []string{}}
+		if c := h.agents[name]; c != nil {
+			connectedAt := c.connectedAt
+			c.mu.Lock()
+			status.Running = len(c.pending)
+			c.mu.Unlock()
+			status.Connected = true
+			status.Version = c.version
+			status.Commands = c.commands
+			status.Address = c.address
+			status.ConnectedAt = &connectedAt
+		}
+		agents = append(agents, status)
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].Name < agents[j].Name })
+	return agents
+}
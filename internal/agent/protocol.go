@@ -0,0 +1,95 @@
+// Package agent runs tasks on remote machines. An agent is the home-ctrl
+// binary started as "home-ctrl agent"; it connects to the server over a
+// WebSocket, advertises the commands it offers and runs the tasks the
+// server sends it.
+package agent
+
+import (
+	"errors"
+	"time"
+
+	"github.com/saintbyte/home-ctrl/internal/command"
+	"github.com/saintbyte/home-ctrl/internal/config"
+)
+
+// ConnectPath is the path of the WebSocket endpoint agents connect to
+const ConnectPath = "/api/v1/agents/connect"
+
+// NameHeader carries the agent name in the WebSocket handshake. The token
+// is sent as "Authorization: Bearer <token>".
+const NameHeader = "X-Agent-Name"
+
+// Message types. The server sends run, cancel and ping messages, the agent
+// hello, output, result and pong messages.
+const (
+	typeHello  = "hello"
+	typeRun    = "run"
+	typeCancel = "cancel"
+	typeOutput = "output"
+	typeResult = "result"
+	typePing   = "ping"
+	typePong   = "pong"
+)
+
+const (
+	// pingInterval is how often the server checks that an agent is alive
+	pingInterval = 30 * time.Second
+	// readTimeout is how long either side waits for a message before
+	// considering the connection dead
+	readTimeout = 3 * pingInterval
+	// helloTimeout is how long the server waits for the hello of a new agent
+	helloTimeout = 10 * time.Second
+	// cancelGrace is how long a cancelled run waits for the agent's result
+	cancelGrace = 5 * time.Second
+)
+
+// ErrNotConnected is returned when a task targets an agent that is not connected
+var ErrNotConnected = errors.New("agent is not connected")
+
+// message is a JSON-encoded WebSocket message in either direction
+type message struct {
+	Type string `json:"type"`
+	ID   int64  `json:"id,omitempty"` // run ID chosen by the server
+
+	// hello
+	Name     string   `json:"name,omitempty"`
+	Version  string   `json:"version,omitempty"`
+	Commands []string `json:"commands,omitempty"`
+
+	// run
+	Task *config.Task `json:"task,omitempty"`
+
+	// output
+	Stream string `json:"stream,omitempty"`
+	Line   string `json:"line,omitempty"`
+
+	// result
+	Result *command.Result `json:"result,omitempty"`
+	Data   string          `json:"data,omitempty"` // Result.Data, which is not encoded with the result
+	Error  string          `json:"error,omitempty"`
+}
+
+// result converts a result message back into the return values of a command
+func (m message) result() (*command.Result, error) {
+	result := &command.Result{ExitCode: -1}
+	if m.Result != nil {
+		result = m.Result
+		result.Data = m.Data
+	}
+	if m.Error != "" {
+		return result, errors.New(m.Error)
+	}
+	return result, nil
+}
+
+// resultMessage converts the return values of a command into a result message
+func resultMessage(id int64, result *command.Result, err error) message {
+	msg := message{Type: typeResult, ID: id, Result: result}
+	if result != nil {
+		msg.Data = result.Data
+	}
+	if err != nil {
+		msg.Error = err.Error()
+	}
+	return msg
+}
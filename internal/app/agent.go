@@ -0,0 +1,60 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/saintbyte/home-ctrl/internal/agent"
+	"github.com/saintbyte/home-ctrl/internal/command"
+)
+
+// RunAgent runs home-ctrl as a remote agent that executes the tasks of a
+// server. Settings default to the HOME_CTRL_AGENT_* environment variables,
+// so that the token need not appear on the command line.
+func RunAgent(args []string) error {
+	hostname, _ := os.Hostname()
+	flags := flag.NewFlagSet("agent", flag.ContinueOnError)
+	server := flags.String("server", os.Getenv("HOME_CTRL_AGENT_SERVER"), "URL of the home-ctrl server, e.g. http://home:8080")
+	name := flags.String("name", envOr("HOME_CTRL_AGENT_NAME", hostname), "agent name configured on the server")
+	token := flags.String("token", os.Getenv("HOME_CTRL_AGENT_TOKEN"), "agent token configured on the server")
+	offer := flags.String("commands", os.Getenv("HOME_CTRL_AGENT_COMMANDS"), "comma-separated commands offered to the server (default all)")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if *server == "" {
+		return fmt.Errorf("server url is required")
+	}
+
+	client := &agent.Client{
+		Server:   *server,
+		Name:     *name,
+		Token:    *token,
+		Commands: command.NewRegistry(),
+	}
+	for _, name := range strings.Split(*offer, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			client.Offer = append(client.Offer, name)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	fmt.Printf("Starting home-ctrl agent %s\n", client.Name)
+	return client.Run(ctx)
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -1,8 +1,11 @@
 package app
 
 import (
+	"context"
 	"fmt"
+	"github.com/saintbyte/home-ctrl/internal/agent"
 	"github.com/saintbyte/home-ctrl/internal/auth"
+	"github.com/saintbyte/home-ctrl/internal/command"
 	"github.com/saintbyte/home-ctrl/internal/config"
 	"github.com/saintbyte/home-ctrl/internal/database"
 	"github.com/saintbyte/home-ctrl/internal/scheduler"
@@ -14,11 +17,12 @@ import (
 type App struct {
 	name    string
 	version string
-	config  *config.Config
+	config  *config.Current // replaced by Daemon.ReloadConfig
 	db      *database.Database
 	auth    *auth.Auth
 	server  *server.Server
 	sched   *scheduler.Scheduler
+	agents  *agent.Hub
 }
 
 // NewApp creates a new application instance
@@ -48,6 +52,9 @@ func NewApp() (*App, error) {
 	if cfg == nil {
 		cfg = config.DefaultConfig()
 	}
+	if err := scheduler.ValidateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("invalid config file: %w", err)
+	}
 
 	// Initialize database
 	db, err := database.NewDatabase(cfg.DataDir)
@@ -61,38 +68,51 @@ func NewApp() (*App, error) {
 	}
 
 	// Initialize authentication
-	authService := auth.NewAuth(cfg, db)
+	current := config.NewCurrent(cfg)
+	authService := auth.NewAuth(current, db)
 
 	// Add users from config
 	for username, password := range cfg.Auth.Users {
 		authService.AddUser(username, password)
 	}
 
+	// Register commands available to tasks
+	commands := command.NewRegistry()
+	command.RegisterBuiltins(commands, cfg, db)
+
+	// Tasks with an agent run on the remote agent of that name
+	agents := agent.NewHub(cfg.Agents)
+	execute := func(ctx context.Context, task config.Task) (*command.Result, error) {
+		if task.Agent != "" {
+			return agents.Execute(ctx, task)
+		}
+		return commands.Execute(ctx, task)
+	}
+
 	// Create scheduler
-	sched := scheduler.NewScheduler(cfg, func(taskName string) {
-		Log.Info("task executed", "task", taskName)
-	})
+	sched := scheduler.NewScheduler(cfg, db, execute)
 
 	// Create server with auth and database
-	srv := server.NewServer(cfg, authService, db, sched)
+	srv := server.NewServer(current, authService, db, sched, agents)
 	srv.SetupRoutes()
 
 	return &App{
 		name:    "home-ctrl",
 		version: "0.1.0",
-		config:  cfg,
+		config:  current,
 		db:      db,
 		auth:    authService,
 		server:  srv,
 		sched:   sched,
+		agents:  agents,
 	}, nil
 }
 
 // Run starts the application
 func (a *App) Run() error {
 	fmt.Printf("Running %s v%s\n", a.name, a.version)
-	fmt.Printf("Server listening on %s\n", a.config.GetServerAddress())
-	fmt.Printf("Data directory: %s\n", a.config.DataDir)
+	fmt.Printf("Server listening on %s\n", a.config.Load().GetServerAddress())
+	fmt.Printf("Data directory: %s\n", a.config.Load().DataDir)
 
 	// Start scheduler
 	a.sched.Start()
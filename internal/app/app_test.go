@@ -1,6 +1,9 @@
 package app
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -42,4 +45,53 @@ func TestAppRun(t *testing.T) {
 	if a.server == nil {
 		t.Fatal("Server should not be nil")
 	}
-}
\ No newline at end of file
+}
+func TestReloadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	write := func(users, widget string) {
+		t.Helper()
+		data := fmt.Sprintf("data_dir: %s\nauth:\n  users:\n%s\n  session_ttl_hours: 2\nmainview:\n  widgets:\n    - name: %s\n", dir, users, widget)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+	write("    alice: secret", "clock")
+	t.Setenv("HOME_CTRL_CONFIG", path)
+
+	a, err := NewApp()
+	if err != nil {
+		t.Fatalf("NewApp() failed: %v", err)
+	}
+	defer a.Close()
+	if _, err := a.auth.Authenticate("alice", "secret"); err != nil {
+		t.Fatalf("Authenticate() failed: %v", err)
+	}
+
+	// The handlers and the users see the reloaded configuration
+	write("    bob: hunter2", "weather")
+	d := &Daemon{app: a, configPath: path}
+	if err := d.ReloadConfig(); err != nil {
+		t.Fatalf("ReloadConfig() failed: %v", err)
+	}
+	if widgets := a.config.Load().MainView.Widgets; len(widgets) != 1 || widgets[0].Name != "weather" {
+		t.Errorf("Expected the reloaded main view, got %+v", widgets)
+	}
+	if _, err := a.auth.Authenticate("alice", "secret"); err == nil {
+		t.Error("Expected a removed user to be rejected")
+	}
+	if _, err := a.auth.Authenticate("bob", "hunter2"); err != nil {
+		t.Errorf("Expected an added user to log in, got %v", err)
+	}
+
+	// An invalid configuration leaves the current one in effect
+	if err := os.WriteFile(path, []byte("tasks:\n  - name: bad\n    command: shell\n    overlap: sometimes\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if err := d.ReloadConfig(); err == nil {
+		t.Error("Expected an invalid config to be rejected")
+	}
+	if widgets := a.config.Load().MainView.Widgets; len(widgets) != 1 || widgets[0].Name != "weather" {
+		t.Errorf("Expected the previous config to stay in effect, got %+v", widgets)
+	}
+}
@@ -6,10 +6,12 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/saintbyte/home-ctrl/internal/config"
+	"github.com/saintbyte/home-ctrl/internal/scheduler"
 )
 
 // Daemon represents a daemonized application
@@ -44,29 +46,53 @@ func (d *Daemon) SetupSignalHandling() {
 	)
 }
 
-// ReloadConfig reloads the configuration
+// ReloadConfig reloads the configuration. Tasks, the scheduler settings,
+// users, the session lifetime, agents, the timezone, the coordinates and the
+// main view take effect right away; the server address and the data
+// directory only on restart.
 func (d *Daemon) ReloadConfig() error {
 	if d.configPath == "" {
 		return fmt.Errorf("config path not set")
 	}
 
-	slog.Info("Reloading configuration from %s", d.configPath)
+	slog.Info("Reloading configuration", "path", d.configPath)
 
 	// Reload configuration
 	cfg, err := config.LoadConfig(d.configPath)
 	if err != nil {
 		return fmt.Errorf("failed to reload config: %w", err)
 	}
+	if err := scheduler.ValidateConfig(cfg); err != nil {
+		return fmt.Errorf("failed to reload config: %w", err)
+	}
 
-	// Update app configuration
-	d.app.config = cfg
+	// Reschedule the tasks that changed, leaving running jobs alone
+	var summary scheduler.ReloadSummary
+	if d.app.sched != nil {
+		if summary, err = d.app.sched.Reload(cfg); err != nil {
+			return fmt.Errorf("failed to reload tasks: %w", err)
+		}
+	}
 
-	// Reinitialize authentication with new config
-	for username, password := range cfg.Auth.Users {
-		d.app.auth.AddUser(username, password)
+	// Swap the configuration read by the HTTP handlers and replace the users
+	previous := d.app.config.Load()
+	d.app.config.Store(cfg)
+	d.app.auth.SetUsers(cfg.Auth.Users)
+	if d.app.agents != nil {
+		d.app.agents.SetTokens(cfg.Agents)
 	}
 
-	slog.Info("Configuration reloaded successfully")
+	var restart []string
+	if cfg.GetServerAddress() != previous.GetServerAddress() {
+		restart = append(restart, "server")
+	}
+	if cfg.DataDir != previous.DataDir {
+		restart = append(restart, "data_dir")
+	}
+	slog.Info("Configuration reloaded",
+		"tasks", summary.String(),
+		"reloaded", "tasks, scheduler, auth, agents, timezone, coordinates, mainview",
+		"needs_restart", strings.Join(restart, ", "))
 	return nil
 }
 
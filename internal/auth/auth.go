@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -14,31 +15,55 @@ import (
 
 // Auth represents the authentication service
 type Auth struct {
-	config    *config.Config
-	database  *database.Database
-	users     map[string]string // username: password
-	sessionTTL time.Duration
+	config   *config.Current
+	database *database.Database
+	mu       sync.RWMutex
+	users    map[string]string // username: password
 }
 
 // NewAuth creates a new authentication service
-func NewAuth(cfg *config.Config, db *database.Database) *Auth {
+func NewAuth(cfg *config.Current, db *database.Database) *Auth {
 	return &Auth{
-		config:    cfg,
-		database:  db,
-		users:     make(map[string]string),
-		sessionTTL: 24 * time.Hour, // 24 hours by default
+		config:   cfg,
+		database: db,
+		users:    make(map[string]string),
 	}
 }
 
 // AddUser adds a user to the in-memory user store
 func (a *Auth) AddUser(username, password string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	a.users[username] = password
 }
 
+// SetUsers replaces the in-memory user store, e.g. with the users of a
+// reloaded configuration. Sessions of removed users stay valid until they
+// expire.
+func (a *Auth) SetUsers(users map[string]string) {
+	store := make(map[string]string, len(users))
+	for username, password := range users {
+		store[username] = password
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.users = store
+}
+
+// sessionTTL returns the configured lifetime of new sessions, 24 hours by default
+func (a *Auth) sessionTTL() time.Duration {
+	if hours := a.config.Load().Auth.SessionTTL; hours > 0 {
+		return time.Duration(hours) * time.Hour
+	}
+	return 24 * time.Hour
+}
+
 // Authenticate authenticates a user and returns a session token
 func (a *Auth) Authenticate(username, password string) (string, error) {
 	// Check if user exists and password matches
+	a.mu.RLock()
 	storedPassword, exists := a.users[username]
+	a.mu.RUnlock()
 	if !exists || storedPassword != password {
 		return "", fmt.Errorf("invalid username or password")
 	}
@@ -50,7 +75,7 @@ func (a *Auth) Authenticate(username, password string) (string, error) {
 	}
 
 	// Create session in database
-	expiresAt := time.Now().Add(a.sessionTTL)
+	expiresAt := time.Now().Add(a.sessionTTL())
 	_, err = a.database.CreateSession(sessionID, username, expiresAt)
 	if err != nil {
 		return "", fmt.Errorf("failed to create session: %w", err)
@@ -103,6 +128,9 @@ func (a *Auth) AuthMiddleware() gin.HandlerFunc {
 		// Check for API key in header
 		apiKey := c.GetHeader("X-API-Key")
 		if apiKey != "" && a.ValidateAPIKey(apiKey) {
+			if key, err := a.database.GetAPIKeyByKey(apiKey); err == nil && key != nil {
+				c.Set("api_key", key.Name)
+			}
 			c.Next()
 			return
 		}
@@ -160,7 +188,7 @@ func (a *Auth) LoginHandler() gin.HandlerFunc {
 		c.JSON(http.StatusOK, gin.H{
 			"token":        sessionID,
 			"token_type":   "bearer",
-			"expires_in":   int(a.sessionTTL.Seconds()),
+			"expires_in":   int(a.sessionTTL().Seconds()),
 			"username":     req.Username,
 			"message":      "Login successful",
 		})
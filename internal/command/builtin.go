@@ -0,0 +1,36 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/saintbyte/home-ctrl/internal/config"
+	"github.com/saintbyte/home-ctrl/internal/database"
+)
+
+// Built-in command names
+const (
+	CleanupSessionsCommandName = "cleanup_sessions"
+	BackupDataCommandName      = "backup_data"
+)
+
+// RegisterBuiltins registers the built-in Go commands
+func RegisterBuiltins(r *Registry, cfg *config.Config, db *database.Database) {
+	r.Register(CleanupSessionsCommandName, Func(func(ctx context.Context, task config.Task) (*Result, error) {
+		if err := db.CleanupExpiredSessions(); err != nil {
+			return nil, err
+		}
+		return &Result{Output: "expired sessions removed"}, nil
+	}))
+
+	r.Register(BackupDataCommandName, Func(func(ctx context.Context, task config.Task) (*Result, error) {
+		name := fmt.Sprintf("home-ctrl-%s.db", time.Now().Format("20060102-150405"))
+		path := filepath.Join(cfg.DataDir, "backups", name)
+		if err := db.Backup(path); err != nil {
+			return nil, err
+		}
+		return &Result{Output: "backup written to " + path}, nil
+	}))
+}
@@ -0,0 +1,91 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/saintbyte/home-ctrl/internal/config"
+)
+
+// Result represents the outcome of a command execution
+type Result struct {
+	ExitCode   int    `json:"exit_code"`
+	Output     string `json:"output"`
+	StatusCode int    `json:"status_code,omitempty"` // response status of "http" commands
+	Data       string `json:"-"`                     // result data: stdout of "shell" commands, the response body of "http" commands
+	Truncated  bool   `json:"-"`                     // Data was cut off at MaxData bytes
+}
+
+// MaxData is the number of bytes of result data kept of a command
+const MaxData = 1 << 20
+
+// Command is a unit of work that can be referenced by config.Task.Command
+type Command interface {
+	Run(ctx context.Context, task config.Task) (*Result, error)
+}
+
+// Func adapts an ordinary function to the Command interface
+type Func func(ctx context.Context, task config.Task) (*Result, error)
+
+// Run calls f(ctx, task)
+func (f Func) Run(ctx context.Context, task config.Task) (*Result, error) {
+	return f(ctx, task)
+}
+
+// Registry holds the commands available to tasks
+type Registry struct {
+	commands map[string]Command
+	mu       sync.RWMutex
+}
+
+// NewRegistry creates a new registry with the shell and http commands registered
+func NewRegistry() *Registry {
+	r := &Registry{
+		commands: make(map[string]Command),
+	}
+	r.Register(ShellCommandName, &ShellCommand{})
+	r.Register(HTTPCommandName, &HTTPCommand{})
+	return r
+}
+
+// Register adds a command to the registry, replacing any command with the same name
+func (r *Registry) Register(name string, cmd Command) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.commands[name] = cmd
+}
+
+// Get returns a command by name
+func (r *Registry) Get(name string) (Command, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cmd, ok := r.commands[name]
+	return cmd, ok
+}
+
+// Names returns the sorted names of all registered commands
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.commands))
+	for name := range r.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Execute runs the command referenced by the task
+func (r *Registry) Execute(ctx context.Context, task config.Task) (*Result, error) {
+	cmd, ok := r.Get(task.Command)
+	if !ok {
+		return nil, fmt.Errorf("unknown command: %s", task.Command)
+	}
+
+	return cmd.Run(ctx, task)
+}
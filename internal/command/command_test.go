@@ -0,0 +1,152 @@
+package command
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/saintbyte/home-ctrl/internal/config"
+)
+
+func TestRegistryExecute(t *testing.T) {
+	r := NewRegistry()
+	r.Register("hello", Func(func(ctx context.Context, task config.Task) (*Result, error) {
+		return &Result{Output: "hello " + task.Name}, nil
+	}))
+
+	result, err := r.Execute(context.Background(), config.Task{Name: "greeter", Command: "hello"})
+	if err != nil {
+		t.Fatalf("Execute() failed: %v", err)
+	}
+	if result.Output != "hello greeter" {
+		t.Errorf("Expected output 'hello greeter', got '%s'", result.Output)
+	}
+
+	if _, err := r.Execute(context.Background(), config.Task{Name: "missing", Command: "nope"}); err == nil {
+		t.Error("Expected error for unknown command")
+	}
+}
+
+func TestShellCommand(t *testing.T) {
+	testCases := []struct {
+		name     string
+		task     config.Task
+		exitCode int
+		output   string
+		wantErr  bool
+	}{
+		{
+			name: "Successful command",
+			task: config.Task{
+				Name:    "echo",
+				Args:    []string{"sh", "-c", "echo $GREETING; pwd"},
+				Env:     map[string]string{"GREETING": "hi"},
+				WorkDir: "/",
+			},
+			exitCode: 0,
+			output:   "hi\n/\n",
+		},
+		{
+			name: "Non-zero exit code",
+			task: config.Task{
+				Name: "fail",
+				Args: []string{"sh", "-c", "echo oops; exit 3"},
+			},
+			exitCode: 3,
+			output:   "oops\n",
+			wantErr:  true,
+		},
+		{
+			name: "Timeout",
+			task: config.Task{
+				Name:    "slow",
+				Args:    []string{"sleep", "5"},
+				Timeout: 50 * time.Millisecond,
+			},
+			exitCode: -1,
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := (&ShellCommand{}).Run(context.Background(), tc.task)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Expected error=%v, got %v", tc.wantErr, err)
+			}
+			if result.ExitCode != tc.exitCode {
+				t.Errorf("Expected exit code %d, got %d", tc.exitCode, result.ExitCode)
+			}
+			if !strings.HasPrefix(result.Output, tc.output) {
+				t.Errorf("Expected output '%s', got '%s'", tc.output, result.Output)
+			}
+		})
+	}
+}
+
+func TestShellCommandOutputSink(t *testing.T) {
+	var mu sync.Mutex
+	lines := map[string][]string{}
+	ctx := WithOutputSink(context.Background(), func(stream, line string) {
+		mu.Lock()
+		defer mu.Unlock()
+		lines[stream] = append(lines[stream], line)
+	})
+
+	task := config.Task{Name: "streams", Args: []string{"sh", "-c", "echo one; echo two >&2; printf three"}}
+	result, err := (&ShellCommand{}).Run(ctx, task)
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if len(result.Output) != len("one\ntwo\nthree") {
+		t.Errorf("Unexpected combined output %q", result.Output)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(lines[Stdout], ",") != "one,three" {
+		t.Errorf("Unexpected stdout lines %v", lines[Stdout])
+	}
+	if strings.Join(lines[Stderr], ",") != "two" {
+		t.Errorf("Unexpected stderr lines %v", lines[Stderr])
+	}
+}
+
+func TestShellCommandOutputLimit(t *testing.T) {
+	// 2 MiB of stdout followed by a line on stderr
+	task := config.Task{Name: "chatty", Args: []string{"sh", "-c", "head -c 2097152 /dev/zero | tr '\\0' x; echo; echo done >&2"}}
+	result, err := (&ShellCommand{}).Run(context.Background(), task)
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if len(result.Output) != maxCombinedOutput || !strings.HasSuffix(result.Output, "x\ndone\n") {
+		t.Errorf("Expected the last %d bytes of output, got %d bytes ending in %q", maxCombinedOutput, len(result.Output), result.Output[len(result.Output)-10:])
+	}
+	if len(result.Data) != MaxData || !result.Truncated {
+		t.Errorf("Expected %d bytes of truncated data, got %d bytes, truncated=%v", MaxData, len(result.Data), result.Truncated)
+	}
+
+	result, err = (&ShellCommand{}).Run(context.Background(), config.Task{Name: "quiet", Args: []string{"echo", "hi"}})
+	if err != nil || result.Data != "hi\n" || result.Truncated {
+		t.Errorf("Expected untruncated data, got %+v, %v", result, err)
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	b := tailBuffer{buf: make([]byte, 5)}
+	for _, tt := range []struct{ write, want string }{
+		{"ab", "ab"},
+		{"cde", "abcde"},
+		{"f", "bcdef"},
+		{"ghi", "efghi"},
+		{"", "efghi"},
+		{"jklmnopq", "mnopq"},
+	} {
+		b.Write([]byte(tt.write))
+		if got := b.String(); got != tt.want {
+			t.Errorf("After writing %q expected %q, got %q", tt.write, tt.want, got)
+		}
+	}
+}
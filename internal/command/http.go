@@ -0,0 +1,149 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/saintbyte/home-ctrl/internal/config"
+)
+
+// HTTPCommandName is the command name for calling webhooks
+const HTTPCommandName = "http"
+
+// maxResponseSnippet is the number of response body bytes kept in the output
+const maxResponseSnippet = 2048
+
+// HTTPCommand performs the HTTP request described by the task's HTTP and
+// Timeout fields
+type HTTPCommand struct {
+	Client *http.Client // defaults to http.DefaultClient
+}
+
+// ValidateHTTP checks the request of an "http" task
+func ValidateHTTP(request *config.TaskHTTP) error {
+	if request == nil || request.URL == "" {
+		return fmt.Errorf("http command requires a url")
+	}
+	if !strings.Contains(request.URL, "{{") {
+		u, err := url.Parse(request.URL)
+		if err != nil {
+			return fmt.Errorf("invalid url: %w", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("url must use http or https, got %q", request.URL)
+		}
+	}
+	if request.Method != "" && strings.ContainsAny(request.Method, " \t\r\n") {
+		return fmt.Errorf("invalid method %q", request.Method)
+	}
+	for _, status := range request.ExpectedStatus {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("invalid expected status %d", status)
+		}
+	}
+	return nil
+}
+
+// Run performs the request and returns the response status and the start of
+// the response body. Unexpected statuses are reported as exit code 1.
+func (h *HTTPCommand) Run(ctx context.Context, task config.Task) (*Result, error) {
+	if err := ValidateHTTP(task.HTTP); err != nil {
+		return nil, fmt.Errorf("task %s: %w", task.Name, err)
+	}
+	request := task.HTTP
+
+	if task.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, task.Timeout)
+		defer cancel()
+	}
+
+	method := strings.ToUpper(request.Method)
+	if method == "" {
+		method = http.MethodGet
+		if request.Body != "" {
+			method = http.MethodPost
+		}
+	}
+	var body io.Reader
+	if request.Body != "" {
+		body = strings.NewReader(request.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, request.URL, body)
+	if err != nil {
+		return nil, fmt.Errorf("task %s: invalid request: %w", task.Name, err)
+	}
+	for key, value := range request.Headers {
+		req.Header.Set(key, value)
+	}
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return &Result{ExitCode: -1}, fmt.Errorf("task %s: %w", task.Name, ctx.Err())
+		}
+		return &Result{ExitCode: -1}, fmt.Errorf("task %s: request failed: %w", task.Name, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxData+1))
+	truncated := len(data) > MaxData
+	if truncated {
+		data = data[:MaxData]
+	}
+	// Drain a bounded remainder so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	snippet := data
+	if len(snippet) > maxResponseSnippet {
+		snippet = snippet[:maxResponseSnippet]
+	}
+
+	output := newOutputCollector(OutputSinkFrom(ctx))
+	fmt.Fprintf(output.Writer(Stdout), "%s %s: %s\n", method, req.URL.Redacted(), resp.Status)
+	if len(snippet) > 0 {
+		text := strings.ToValidUTF8(string(snippet), string(utf8.RuneError))
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		output.Writer(Stdout).Write([]byte(text))
+	}
+
+	result := &Result{
+		StatusCode: resp.StatusCode,
+		Output:     output.String(),
+		Data:       string(data),
+		Truncated:  truncated,
+	}
+	if err != nil {
+		result.ExitCode = 1
+		return result, fmt.Errorf("task %s: failed to read response: %w", task.Name, err)
+	}
+	if !expectedStatus(request, resp.StatusCode) {
+		result.ExitCode = 1
+		return result, fmt.Errorf("task %s: unexpected status %s", task.Name, resp.Status)
+	}
+	return result, nil
+}
+
+// expectedStatus returns true if status is one of the expected statuses of a
+// request, or a 2xx status if it has none
+func expectedStatus(request *config.TaskHTTP, status int) bool {
+	if len(request.ExpectedStatus) == 0 {
+		return status >= 200 && status < 300
+	}
+	for _, expected := range request.ExpectedStatus {
+		if status == expected {
+			return true
+		}
+	}
+	return false
+}
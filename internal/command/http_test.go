@@ -0,0 +1,123 @@
+package command
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintbyte/home-ctrl/internal/config"
+)
+
+func TestHTTPCommand(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hook":
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, r.Method+" "+r.Header.Get("X-Token")+" "+string(body))
+		case "/created":
+			w.WriteHeader(http.StatusCreated)
+		case "/large":
+			io.WriteString(w, strings.Repeat("x", 10000))
+		case "/slow":
+			time.Sleep(500 * time.Millisecond)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		name       string
+		request    config.TaskHTTP
+		timeout    time.Duration
+		statusCode int
+		exitCode   int
+		output     string
+		wantErr    bool
+	}{
+		{
+			name: "POST with body and headers",
+			request: config.TaskHTTP{
+				URL:     server.URL + "/hook",
+				Headers: map[string]string{"X-Token": "secret"},
+				Body:    `{"on":true}`,
+			},
+			statusCode: 200,
+			output:     "POST secret {\"on\":true}\n",
+		},
+		{
+			name:       "Unexpected status",
+			request:    config.TaskHTTP{URL: server.URL + "/missing"},
+			statusCode: 404,
+			exitCode:   1,
+			output:     "404 page not found\n",
+			wantErr:    true,
+		},
+		{
+			name:       "Expected status",
+			request:    config.TaskHTTP{Method: "put", URL: server.URL + "/created", ExpectedStatus: []int{201, 204}},
+			statusCode: 201,
+		},
+		{
+			name:       "Response snippet",
+			request:    config.TaskHTTP{URL: server.URL + "/large"},
+			statusCode: 200,
+			output:     strings.Repeat("x", maxResponseSnippet) + "\n",
+		},
+		{
+			name:     "Timeout",
+			request:  config.TaskHTTP{URL: server.URL + "/slow"},
+			timeout:  50 * time.Millisecond,
+			exitCode: -1,
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := tc.request
+			task := config.Task{Name: "hook", HTTP: &request, Timeout: tc.timeout}
+			result, err := (&HTTPCommand{}).Run(context.Background(), task)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Expected error=%v, got %v", tc.wantErr, err)
+			}
+			if result.StatusCode != tc.statusCode || result.ExitCode != tc.exitCode {
+				t.Errorf("Expected status %d and exit code %d, got %+v", tc.statusCode, tc.exitCode, result)
+			}
+			if !strings.HasSuffix(result.Output, tc.output) {
+				t.Errorf("Expected output ending in %q, got %q", tc.output, result.Output)
+			}
+		})
+	}
+}
+
+func TestValidateHTTP(t *testing.T) {
+	valid := []*config.TaskHTTP{
+		{URL: "http://localhost/hook"},
+		{URL: "{{.url}}"},
+		{Method: "DELETE", URL: "https://example.com", ExpectedStatus: []int{204}},
+	}
+	for _, request := range valid {
+		if err := ValidateHTTP(request); err != nil {
+			t.Errorf("ValidateHTTP(%+v) failed: %v", request, err)
+		}
+	}
+
+	invalid := []*config.TaskHTTP{
+		nil,
+		{},
+		{URL: "ftp://example.com"},
+		{Method: "GET /", URL: "http://localhost"},
+		{URL: "http://localhost", ExpectedStatus: []int{42}},
+	}
+	for _, request := range invalid {
+		if err := ValidateHTTP(request); err == nil {
+			t.Errorf("Expected ValidateHTTP(%+v) to fail", request)
+		}
+	}
+}
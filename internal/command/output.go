@@ -0,0 +1,160 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+
+	"github.com/saintbyte/home-ctrl/internal/database"
+)
+
+// Output streams
+const (
+	Stdout = "stdout"
+	Stderr = "stderr"
+)
+
+// maxLineLength is the length after which a line without newline is passed on anyway
+const maxLineLength = 64 * 1024
+
+// maxCombinedOutput is the number of trailing bytes of the combined output
+// kept in memory, a few times what is stored with a run
+const maxCombinedOutput = 4 * database.TaskRunOutputLimit
+
+// OutputSink receives the output of a running command line by line.
+// It must not block.
+type OutputSink func(stream, line string)
+
+type outputSinkKey struct{}
+
+// WithOutputSink returns a context that makes commands report their output
+// to sink while they run
+func WithOutputSink(ctx context.Context, sink OutputSink) context.Context {
+	return context.WithValue(ctx, outputSinkKey{}, sink)
+}
+
+// OutputSinkFrom returns the output sink of a context, or nil
+func OutputSinkFrom(ctx context.Context) OutputSink {
+	sink, _ := ctx.Value(outputSinkKey{}).(OutputSink)
+	return sink
+}
+
+// outputCollector gathers the tail of the combined output of a command and
+// the first MaxData bytes of its stdout, and passes complete lines of each
+// stream on to a sink
+type outputCollector struct {
+	sink      OutputSink
+	combined  tailBuffer
+	stdout    bytes.Buffer
+	truncated bool
+	partial   map[string][]byte
+	mu        sync.Mutex
+}
+
+func newOutputCollector(sink OutputSink) *outputCollector {
+	return &outputCollector{
+		sink:     sink,
+		combined: tailBuffer{buf: make([]byte, maxCombinedOutput)},
+		partial:  make(map[string][]byte),
+	}
+}
+
+// Stdout returns the output written to the stdout stream only, and whether
+// it was cut off at MaxData bytes
+func (o *outputCollector) Stdout() (string, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.stdout.String(), o.truncated
+}
+
+// Writer returns a writer for one output stream
+func (o *outputCollector) Writer(stream string) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+
+		o.combined.Write(p)
+		if stream == Stdout && !o.truncated {
+			if room := MaxData - o.stdout.Len(); len(p) > room {
+				o.stdout.Write(p[:room])
+				o.truncated = true
+			} else {
+				o.stdout.Write(p)
+			}
+		}
+		if o.sink == nil {
+			return len(p), nil
+		}
+
+		buf := append(o.partial[stream], p...)
+		for {
+			i := bytes.IndexByte(buf, '\n')
+			if i < 0 {
+				break
+			}
+			o.sink(stream, string(buf[:i]))
+			buf = buf[i+1:]
+		}
+		if len(buf) >= maxLineLength {
+			o.sink(stream, string(buf))
+			buf = nil
+		}
+		o.partial[stream] = append([]byte(nil), buf...)
+		return len(p), nil
+	})
+}
+
+// String flushes incomplete lines to the sink and returns the combined output
+func (o *outputCollector) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.sink != nil {
+		for _, stream := range []string{Stdout, Stderr} {
+			if len(o.partial[stream]) > 0 {
+				o.sink(stream, string(o.partial[stream]))
+			}
+		}
+		o.partial = make(map[string][]byte)
+	}
+	return o.combined.String()
+}
+
+// tailBuffer is a ring buffer keeping the last len(buf) bytes written to it
+type tailBuffer struct {
+	buf  []byte
+	pos  int // where the next byte is written
+	full bool
+}
+
+func (b *tailBuffer) Write(p []byte) {
+	if len(p) >= len(b.buf) {
+		copy(b.buf, p[len(p)-len(b.buf):])
+		b.pos, b.full = 0, true
+		return
+	}
+	n := copy(b.buf[b.pos:], p)
+	if n < len(p) {
+		copy(b.buf, p[n:])
+		b.full = true
+	}
+	b.pos = (b.pos + len(p)) % len(b.buf)
+	if b.pos == 0 && len(p) > 0 {
+		b.full = true
+	}
+}
+
+func (b *tailBuffer) String() string {
+	if !b.full {
+		return string(b.buf[:b.pos])
+	}
+	return string(b.buf[b.pos:]) + string(b.buf[:b.pos])
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,167 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/saintbyte/home-ctrl/internal/config"
+)
+
+// sandboxEnv passes the restrictions of a task to the re-executed daemon
+// binary that applies them before executing the program of the task
+const sandboxEnv = "HOME_CTRL_SANDBOX"
+
+// sandboxSpec are the restrictions applied by the sandbox helper
+type sandboxSpec struct {
+	User   *runAsUser `json:"user,omitempty"`
+	CPU    uint64     `json:"cpu,omitempty"`    // seconds
+	Memory uint64     `json:"memory,omitempty"` // bytes
+	Files  uint64     `json:"files,omitempty"`
+	Nice   int        `json:"nice,omitempty"`
+}
+
+// runAsUser is the resolved run_as setting of a task
+type runAsUser struct {
+	Name   string   `json:"name"`
+	Home   string   `json:"home"`
+	UID    uint32   `json:"uid"`
+	GID    uint32   `json:"gid"`
+	Groups []uint32 `json:"groups"`
+}
+
+// restricted returns true if the program of a task must run in the sandbox helper
+func restricted(task config.Task) bool {
+	return task.RunAs != "" || task.Nice != 0 || task.Limits != config.TaskLimits{}
+}
+
+// ValidateShell checks the restrictions of a "shell" task
+func ValidateShell(task config.Task) error {
+	if restricted(task) && !sandboxSupported {
+		return fmt.Errorf("run_as, limits and nice are not supported on this platform")
+	}
+	if task.Nice < -20 || task.Nice > 19 {
+		return fmt.Errorf("nice must be between -20 and 19, got %d", task.Nice)
+	}
+	if task.Limits.CPUSeconds < 0 || task.Limits.MemoryMB < 0 || task.Limits.OpenFiles < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	for _, pattern := range task.EnvAllow {
+		if pattern == "" {
+			return fmt.Errorf("invalid env_allow entry %q", pattern)
+		}
+	}
+	if task.RunAs != "" {
+		if _, err := lookupRunAs(task.RunAs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookupRunAs resolves a "user" or "user:group" setting. Without a group the
+// program gets the primary and supplementary groups of the user.
+func lookupRunAs(spec string) (*runAsUser, error) {
+	name, group, hasGroup := strings.Cut(spec, ":")
+	u, err := user.Lookup(name)
+	if err != nil {
+		if _, numErr := strconv.Atoi(name); numErr != nil {
+			return nil, fmt.Errorf("unknown run_as user %q", name)
+		}
+		if u, err = user.LookupId(name); err != nil {
+			return nil, fmt.Errorf("unknown run_as user %q", name)
+		}
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("run_as user %q has no numeric uid", name)
+	}
+	runAs := &runAsUser{Name: u.Username, Home: u.HomeDir, UID: uint32(uid)}
+
+	gids := []string{u.Gid}
+	if hasGroup {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return nil, fmt.Errorf("unknown run_as group %q", group)
+			}
+		}
+		gids = []string{g.Gid}
+	} else if ids, err := u.GroupIds(); err == nil {
+		gids = append(gids, ids...)
+	}
+
+	seen := make(map[uint32]bool)
+	for i, id := range gids {
+		gid, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("run_as group %q has no numeric gid", id)
+		}
+		if i == 0 {
+			runAs.GID = uint32(gid)
+		}
+		if !seen[uint32(gid)] {
+			seen[uint32(gid)] = true
+			runAs.Groups = append(runAs.Groups, uint32(gid))
+		}
+	}
+	return runAs, nil
+}
+
+// newSandboxSpec resolves the restrictions of a task
+func newSandboxSpec(task config.Task) (*sandboxSpec, error) {
+	spec := &sandboxSpec{
+		CPU:    uint64(task.Limits.CPUSeconds),
+		Memory: uint64(task.Limits.MemoryMB) << 20,
+		Files:  uint64(task.Limits.OpenFiles),
+		Nice:   task.Nice,
+	}
+	if task.RunAs != "" {
+		runAs, err := lookupRunAs(task.RunAs)
+		if err != nil {
+			return nil, err
+		}
+		spec.User = runAs
+	}
+	return spec, nil
+}
+
+// envAllowed returns true if an environment variable of the daemon may be
+// passed to programs. Patterns ending in "*" match prefixes.
+func envAllowed(allow []string, name string) bool {
+	if allow == nil {
+		return true
+	}
+	for _, pattern := range allow {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// programEnv returns the environment of the program of a task: the allowed
+// daemon variables, the login variables of the run_as user and the task's own
+func programEnv(task config.Task, runAs *runAsUser) []string {
+	var env []string
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		if name != sandboxEnv && envAllowed(task.EnvAllow, name) {
+			env = append(env, entry)
+		}
+	}
+	if runAs != nil {
+		env = append(env, "HOME="+runAs.Home, "USER="+runAs.Name, "LOGNAME="+runAs.Name)
+	}
+	for key, value := range task.Env {
+		env = append(env, key+"="+value)
+	}
+	return env
+}
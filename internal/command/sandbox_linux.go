@@ -0,0 +1,118 @@
+//go:build linux
+
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+
+	"github.com/saintbyte/home-ctrl/internal/config"
+)
+
+const sandboxSupported = true
+
+// sandboxCommand returns a command running the program of a task through the
+// sandbox helper. The helper is the daemon binary itself: it applies the
+// limits, niceness and credentials to itself and then executes the program,
+// so that they are in effect before the program starts.
+func sandboxCommand(ctx context.Context, task config.Task) (*exec.Cmd, error) {
+	spec, err := newSandboxSpec(task)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, "/proc/self/exe")
+	cmd.Args = task.Args
+	cmd.Env = append(programEnv(task, spec.User), sandboxEnv+"="+string(encoded))
+	return cmd, nil
+}
+
+// RunSandboxHelper applies the restrictions of a task and executes its
+// program if the process was started as sandbox helper, otherwise it returns
+// right away. It must be called at the start of main.
+func RunSandboxHelper() {
+	encoded, ok := os.LookupEnv(sandboxEnv)
+	if !ok {
+		return
+	}
+	// The niceness and credentials are attributes of the calling thread, so
+	// they have to be set on the thread that executes the program
+	runtime.LockOSThread()
+	os.Unsetenv(sandboxEnv)
+
+	var spec sandboxSpec
+	if err := json.Unmarshal([]byte(encoded), &spec); err != nil {
+		sandboxFail(126, "invalid restrictions: %v", err)
+	}
+
+	path, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		sandboxFail(127, "%v", err)
+	}
+	if err := spec.apply(); err != nil {
+		sandboxFail(126, "%v", err)
+	}
+	err = syscall.Exec(path, os.Args, os.Environ())
+	sandboxFail(126, "failed to run %s: %v", os.Args[0], err)
+}
+
+// apply restricts the current process. Credentials are changed last, as
+// lowering the niceness may require the privileges of the daemon.
+func (s *sandboxSpec) apply() error {
+	limits := []struct {
+		name     string
+		resource int
+		value    uint64
+	}{
+		{"cpu_seconds", syscall.RLIMIT_CPU, s.CPU},
+		{"memory_mb", syscall.RLIMIT_AS, s.Memory},
+		{"open_files", syscall.RLIMIT_NOFILE, s.Files},
+	}
+	for _, limit := range limits {
+		if limit.value == 0 {
+			continue
+		}
+		rlimit := syscall.Rlimit{Cur: limit.value, Max: limit.value}
+		if err := syscall.Setrlimit(limit.resource, &rlimit); err != nil {
+			return fmt.Errorf("failed to set %s limit: %w", limit.name, err)
+		}
+	}
+
+	if s.Nice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, s.Nice); err != nil {
+			return fmt.Errorf("failed to set nice level: %w", err)
+		}
+	}
+
+	if s.User != nil {
+		groups := make([]int, len(s.User.Groups))
+		for i, gid := range s.User.Groups {
+			groups[i] = int(gid)
+		}
+		if err := syscall.Setgroups(groups); err != nil {
+			return fmt.Errorf("failed to set groups of %s: %w", s.User.Name, err)
+		}
+		if err := syscall.Setgid(int(s.User.GID)); err != nil {
+			return fmt.Errorf("failed to set group of %s: %w", s.User.Name, err)
+		}
+		if err := syscall.Setuid(int(s.User.UID)); err != nil {
+			return fmt.Errorf("failed to run as %s: %w", s.User.Name, err)
+		}
+	}
+	return nil
+}
+
+// sandboxFail reports an error of the sandbox helper on stderr and exits
+func sandboxFail(code int, format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "home-ctrl sandbox: "+format+"\n", args...)
+	os.Exit(code)
+}
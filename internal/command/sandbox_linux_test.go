@@ -0,0 +1,75 @@
+//go:build linux
+
+package command
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/saintbyte/home-ctrl/internal/config"
+)
+
+func TestSandboxLimits(t *testing.T) {
+	task := config.Task{
+		Name:   "limited",
+		Args:   []string{"sh", "-c", "ulimit -t; ulimit -n; cut -d' ' -f19 /proc/self/stat"},
+		Limits: config.TaskLimits{CPUSeconds: 30, OpenFiles: 64},
+		Nice:   5,
+	}
+	result, err := (&ShellCommand{}).Run(context.Background(), task)
+	if err != nil {
+		t.Fatalf("Run() failed: %v (%s)", err, result.Output)
+	}
+	if result.Output != "30\n64\n5\n" {
+		t.Errorf("Unexpected limits and nice level %q", result.Output)
+	}
+
+	// The helper can't run under the race detector with a memory limit
+	spec, err := newSandboxSpec(config.Task{Limits: config.TaskLimits{MemoryMB: 1024}})
+	if err != nil || spec.Memory != 1<<30 {
+		t.Errorf("Expected memory limit in bytes, got %+v, %v", spec, err)
+	}
+
+	task = config.Task{Name: "missing", Args: []string{"no-such-program-home-ctrl"}, Nice: 1}
+	result, err = (&ShellCommand{}).Run(context.Background(), task)
+	if err == nil || result.ExitCode != 127 || !strings.Contains(result.Output, "home-ctrl sandbox") {
+		t.Errorf("Expected exit code 127 for a missing program, got %+v, %v", result, err)
+	}
+}
+
+func TestSandboxNice(t *testing.T) {
+	// The niceness of the executed program itself, not only of its children
+	task := config.Task{Name: "nice", Args: []string{"sh", "-c", "cut -d' ' -f19 /proc/$$/stat"}, Nice: 7}
+	for i := 0; i < 10; i++ {
+		result, err := (&ShellCommand{}).Run(context.Background(), task)
+		if err != nil {
+			t.Fatalf("Run() failed: %v (%s)", err, result.Output)
+		}
+		if result.Output != "7\n" {
+			t.Fatalf("Expected nice level 7 of the program, got %q", result.Output)
+		}
+	}
+}
+
+func TestSandboxRunAs(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing the user requires root")
+	}
+	runAs, err := lookupRunAs("nobody")
+	if err != nil {
+		t.Skipf("no nobody user: %v", err)
+	}
+
+	task := config.Task{Name: "nobody", Args: []string{"sh", "-c", "id -u; echo $USER"}, RunAs: "nobody", WorkDir: "/"}
+	result, err := (&ShellCommand{}).Run(context.Background(), task)
+	if err != nil {
+		t.Fatalf("Run() failed: %v (%s)", err, result.Output)
+	}
+	want := strings.Join([]string{strconv.FormatUint(uint64(runAs.UID), 10), "nobody", ""}, "\n")
+	if result.Output != want {
+		t.Errorf("Expected output %q, got %q", want, result.Output)
+	}
+}
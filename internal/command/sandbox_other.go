@@ -0,0 +1,20 @@
+//go:build !linux
+
+package command
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+
+	"github.com/saintbyte/home-ctrl/internal/config"
+)
+
+const sandboxSupported = false
+
+func sandboxCommand(ctx context.Context, task config.Task) (*exec.Cmd, error) {
+	return nil, fmt.Errorf("run_as, limits and nice are not supported on this platform")
+}
+
+// RunSandboxHelper does nothing on platforms without sandbox support
+func RunSandboxHelper() {}
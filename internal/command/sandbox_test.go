@@ -0,0 +1,72 @@
+package command
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/saintbyte/home-ctrl/internal/config"
+)
+
+// TestMain lets the test binary act as the sandbox helper, like the daemon binary
+func TestMain(m *testing.M) {
+	RunSandboxHelper()
+	os.Exit(m.Run())
+}
+
+func TestEnvAllowlist(t *testing.T) {
+	t.Setenv("HOME_CTRL_TEST_SECRET", "secret")
+	t.Setenv("HOME_CTRL_TEST_LANG", "en")
+
+	task := config.Task{
+		Name:     "env",
+		Args:     []string{"sh", "-c", "env"},
+		Env:      map[string]string{"GREETING": "hi"},
+		EnvAllow: []string{"PATH", "HOME_CTRL_TEST_L*"},
+	}
+	result, err := (&ShellCommand{}).Run(context.Background(), task)
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	for _, want := range []string{"HOME_CTRL_TEST_LANG=en", "GREETING=hi", "PATH="} {
+		if !strings.Contains(result.Output, want) {
+			t.Errorf("Expected %s in environment, got %q", want, result.Output)
+		}
+	}
+	if strings.Contains(result.Output, "SECRET") {
+		t.Errorf("Expected variables not allowed to be dropped, got %q", result.Output)
+	}
+
+	task.EnvAllow = nil
+	result, _ = (&ShellCommand{}).Run(context.Background(), task)
+	if !strings.Contains(result.Output, "HOME_CTRL_TEST_SECRET=secret") {
+		t.Errorf("Expected all variables without allowlist, got %q", result.Output)
+	}
+}
+
+func TestValidateShell(t *testing.T) {
+	valid := []config.Task{
+		{},
+		{Nice: 10, Limits: config.TaskLimits{CPUSeconds: 60, MemoryMB: 512, OpenFiles: 256}},
+		{RunAs: "root", EnvAllow: []string{"PATH", "LC_*"}},
+	}
+	for _, task := range valid {
+		if err := ValidateShell(task); err != nil && sandboxSupported {
+			t.Errorf("ValidateShell(%+v) failed: %v", task, err)
+		}
+	}
+
+	invalid := []config.Task{
+		{Nice: 20},
+		{Limits: config.TaskLimits{MemoryMB: -1}},
+		{RunAs: "no-such-user-home-ctrl"},
+		{RunAs: "root:no-such-group-home-ctrl"},
+		{EnvAllow: []string{""}},
+	}
+	for _, task := range invalid {
+		if err := ValidateShell(task); err == nil {
+			t.Errorf("Expected ValidateShell(%+v) to fail", task)
+		}
+	}
+}
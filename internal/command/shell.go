@@ -0,0 +1,69 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/saintbyte/home-ctrl/internal/config"
+)
+
+// ShellCommandName is the command name for running external programs
+const ShellCommandName = "shell"
+
+// ShellCommand runs an external program described by the task's Args, Env,
+// WorkDir and Timeout fields, restricted by its RunAs, Limits, Nice and
+// EnvAllow fields
+type ShellCommand struct{}
+
+// Run executes the program and returns its exit code and combined output
+func (s *ShellCommand) Run(ctx context.Context, task config.Task) (*Result, error) {
+	if len(task.Args) == 0 {
+		return nil, fmt.Errorf("task %s: shell command requires args", task.Name)
+	}
+
+	if task.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, task.Timeout)
+		defer cancel()
+	}
+
+	var cmd *exec.Cmd
+	if restricted(task) {
+		var err error
+		if cmd, err = sandboxCommand(ctx, task); err != nil {
+			return nil, fmt.Errorf("task %s: %w", task.Name, err)
+		}
+	} else {
+		cmd = exec.CommandContext(ctx, task.Args[0], task.Args[1:]...)
+		cmd.Env = programEnv(task, nil)
+	}
+	// Don't wait forever for children that inherited the output pipe
+	cmd.WaitDelay = time.Second
+	cmd.Dir = task.WorkDir
+
+	output := newOutputCollector(OutputSinkFrom(ctx))
+	cmd.Stdout = output.Writer(Stdout)
+	cmd.Stderr = output.Writer(Stderr)
+
+	err := cmd.Run()
+	result := &Result{
+		ExitCode: cmd.ProcessState.ExitCode(),
+		Output:   output.String(),
+	}
+	result.Data, result.Truncated = output.Stdout()
+	if err != nil {
+		if ctx.Err() != nil {
+			return result, fmt.Errorf("task %s: %w", task.Name, ctx.Err())
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return result, fmt.Errorf("task %s: exited with code %d", task.Name, exitErr.ExitCode())
+		}
+		return result, fmt.Errorf("task %s: failed to run %s: %w", task.Name, task.Args[0], err)
+	}
+
+	return result, nil
+}
@@ -3,6 +3,9 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -10,9 +13,108 @@ import (
 // Task represents a background task configuration
 type Task struct {
 	Name     string `yaml:"name"`
-	Schedule string `yaml:"schedule"` // cron expression
+	Schedule string `yaml:"schedule"` // cron expression with optional seconds, "@every 30s" or "sunset-30m"
+	Timezone string `yaml:"timezone"` // IANA zone of the schedule, defaults to the app timezone
 	Enabled  bool   `yaml:"enabled"`
-	Command  string `yaml:"command"`
+	Command  string `yaml:"command"` // registered command name, e.g. "shell"
+	Agent    string `yaml:"agent"`   // remote agent running the command, the server itself if empty
+
+	// Options for the "shell" command
+	Args    []string          `yaml:"args"`    // argv, first element is the program
+	Env     map[string]string `yaml:"env"`     // extra environment variables
+	WorkDir string            `yaml:"workdir"` // working directory
+	Timeout time.Duration     `yaml:"timeout"` // e.g. "30s", zero means no limit
+
+	// Restrictions of the "shell" command. run_as, limits and nice are only
+	// supported on Linux.
+	RunAs    string     `yaml:"run_as"`    // user or "user:group" to run the program as
+	Limits   TaskLimits `yaml:"limits"`    // resource limits of the program
+	Nice     int        `yaml:"nice"`      // niceness of the program, -20 (highest priority) to 19
+	EnvAllow []string   `yaml:"env_allow"` // daemon environment variables passed to the program, e.g. "PATH" or "LC_*"; all if unset
+
+	// Request of the "http" command
+	HTTP *TaskHTTP `yaml:"http"`
+
+	Overlap string `yaml:"overlap"` // "allow" (default), "skip" or "queue" when a run is still in progress
+
+	// Publishing the result of successful runs to the key-value store
+	OutputKey  string `yaml:"output_key"`  // key written with the result data
+	OutputPath string `yaml:"output_path"` // JSON path of the published value, e.g. "$.devices[0].state"
+	OutputType string `yaml:"output_type"` // value type of the published value: "string" (default), "number", "bool" or "json"
+
+	// Parameters of manual runs, substituted into args, env, workdir and the
+	// HTTP request as Go templates, e.g. "{{.host}}"
+	Params []TaskParam `yaml:"params"`
+
+	// Runs missed while the scheduler was down
+	CatchUp         string        `yaml:"catch_up"`          // "none" (default), "once" or "all"
+	CatchUpLookback time.Duration `yaml:"catch_up_lookback"` // how far back to look for missed runs, default 24h
+
+	// Failure handling
+	Retries         int           `yaml:"retries"`           // extra attempts after a failed run
+	RetryBackoff    time.Duration `yaml:"retry_backoff"`     // delay before the first retry, doubled for each next one
+	RetryBackoffMax time.Duration `yaml:"retry_backoff_max"` // upper limit for the retry delay
+	OnFailure       string        `yaml:"on_failure"`        // "continue" (default) or "disable" once all attempts failed
+
+	// Chaining: a triggered task first runs its dependencies, then the
+	// follow-up tasks matching its outcome
+	DependsOn    []string `yaml:"depends_on"`     // tasks that must succeed before this one runs
+	OnSuccess    []string `yaml:"on_success"`     // tasks to run after this one succeeded
+	OnFailureRun []string `yaml:"on_failure_run"` // tasks to run after this one failed
+}
+
+// TaskLimits are resource limits of the program of a task, zero means unlimited
+type TaskLimits struct {
+	CPUSeconds int `yaml:"cpu_seconds" json:"cpu_seconds,omitempty"` // CPU time
+	MemoryMB   int `yaml:"memory_mb" json:"memory_mb,omitempty"`     // virtual address space
+	OpenFiles  int `yaml:"open_files" json:"open_files,omitempty"`   // open file descriptors
+}
+
+// TaskHTTP describes the request made by an "http" task
+type TaskHTTP struct {
+	Method         string            `yaml:"method" json:"method"` // defaults to GET, or POST if there is a body
+	URL            string            `yaml:"url" json:"url"`
+	Headers        map[string]string `yaml:"headers" json:"headers,omitempty"`
+	Body           string            `yaml:"body" json:"body,omitempty"`
+	ExpectedStatus []int             `yaml:"expected_status" json:"expected_status,omitempty"` // any 2xx status if empty
+}
+
+// TaskParam declares a typed parameter of a task
+type TaskParam struct {
+	Name     string `yaml:"name" json:"name"`
+	Type     string `yaml:"type" json:"type"` // "string" (default), "int", "number" or "bool"
+	Default  any    `yaml:"default" json:"default,omitempty"`
+	Required bool   `yaml:"required" json:"required"`
+}
+
+// Chained returns true if the task depends on or triggers other tasks
+func (t *Task) Chained() bool {
+	return len(t.DependsOn) > 0 || len(t.OnSuccess) > 0 || len(t.OnFailureRun) > 0
+}
+
+// Links returns the names of all tasks referenced by the task
+func (t *Task) Links() []string {
+	links := make([]string, 0, len(t.DependsOn)+len(t.OnSuccess)+len(t.OnFailureRun))
+	links = append(links, t.DependsOn...)
+	links = append(links, t.OnSuccess...)
+	return append(links, t.OnFailureRun...)
+}
+
+// SchedulerConfig represents the background task scheduler configuration
+type SchedulerConfig struct {
+	Workers       int           `yaml:"workers"`        // maximum number of tasks running at the same time
+	ShutdownGrace time.Duration `yaml:"shutdown_grace"` // how long Stop waits for cancelled runs, default 10s
+	LeaseTTL      time.Duration `yaml:"lease_ttl"`      // how long the scheduler lease of an instance lasts without renewal, default 30s
+
+	Maintenance []MaintenanceWindow `yaml:"maintenance"` // recurring windows without scheduled runs
+}
+
+// MaintenanceWindow is a recurring period during which scheduled task runs are skipped
+type MaintenanceWindow struct {
+	Name     string        `yaml:"name"`
+	Schedule string        `yaml:"schedule"` // start of the window, same syntax as task schedules
+	Timezone string        `yaml:"timezone"` // IANA zone of the schedule, defaults to the app timezone
+	Duration time.Duration `yaml:"duration"`
 }
 
 // Widget represents a widget in the main view
@@ -40,11 +142,46 @@ type Config struct {
 
 	DataDir string `yaml:"data_dir"`
 
+	Timezone string `yaml:"timezone"` // default IANA zone, e.g. "Europe/Moscow"; empty means the server's local zone
+
+	// Home coordinates in degrees (north and east positive), used by
+	// sunrise/sunset task schedules
+	Latitude  float64 `yaml:"latitude"`
+	Longitude float64 `yaml:"longitude"`
+
+	Scheduler SchedulerConfig `yaml:"scheduler"`
+
+	// Remote agents allowed to connect (name: token), see "home-ctrl agent"
+	Agents map[string]string `yaml:"agents"`
+
 	Tasks []Task `yaml:"tasks"`
 
 	MainView MainView `yaml:"mainview"`
 }
 
+// Current holds the configuration in effect. Components serving requests
+// read it on each use, so a reloaded configuration replaces it as a whole.
+type Current struct {
+	config atomic.Pointer[Config]
+}
+
+// NewCurrent returns a holder of cfg
+func NewCurrent(cfg *Config) *Current {
+	current := &Current{}
+	current.config.Store(cfg)
+	return current
+}
+
+// Load returns the configuration in effect
+func (c *Current) Load() *Config {
+	return c.config.Load()
+}
+
+// Store replaces the configuration in effect
+func (c *Current) Store(cfg *Config) {
+	c.config.Store(cfg)
+}
+
 // DefaultConfig returns the default configuration
 func DefaultConfig() *Config {
 	return &Config{
@@ -65,6 +202,9 @@ func DefaultConfig() *Config {
 			},
 			SessionTTL: 24, // 24 hours
 		},
+		Scheduler: SchedulerConfig{
+			Workers: 4,
+		},
 		Tasks: []Task{},
 		MainView: MainView{
 			Widgets: []Widget{},
@@ -88,9 +228,132 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file: %w", err)
+	}
+
 	return &config, nil
 }
 
+// Validate checks the configuration for unknown timezones, duplicate tasks,
+// unknown agents, task chain cycles and incomplete maintenance windows. The
+// settings of each task are checked by scheduler.ValidateConfig.
+func (c *Config) Validate() error {
+	if _, err := time.LoadLocation(c.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone: %w", err)
+	}
+	if c.Latitude < -90 || c.Latitude > 90 {
+		return fmt.Errorf("invalid latitude: %g", c.Latitude)
+	}
+	if c.Longitude < -180 || c.Longitude > 180 {
+		return fmt.Errorf("invalid longitude: %g", c.Longitude)
+	}
+
+	names := make(map[string]bool, len(c.Tasks))
+	for _, task := range c.Tasks {
+		if names[task.Name] {
+			return fmt.Errorf("duplicate task name: %s", task.Name)
+		}
+		names[task.Name] = true
+
+		if _, err := time.LoadLocation(task.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone of task %s: %w", task.Name, err)
+		}
+		if _, ok := c.Agents[task.Agent]; task.Agent != "" && !ok {
+			return fmt.Errorf("task %s runs on unknown agent %s", task.Name, task.Agent)
+		}
+	}
+
+	for name, token := range c.Agents {
+		if token == "" {
+			return fmt.Errorf("agent %s needs a token", name)
+		}
+	}
+
+	for _, window := range c.Scheduler.Maintenance {
+		if window.Schedule == "" || window.Duration <= 0 {
+			return fmt.Errorf("maintenance window %s needs a schedule and a positive duration", window.Name)
+		}
+		if _, err := time.LoadLocation(window.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone of maintenance window %s: %w", window.Name, err)
+		}
+	}
+
+	if cycle := FindTaskCycle(c.Tasks); cycle != nil {
+		return fmt.Errorf("task chain cycle: %s", strings.Join(cycle, " -> "))
+	}
+	return nil
+}
+
+// Location returns the configured default timezone, or the local zone
+func (c *Config) Location() *time.Location {
+	if c.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// HasCoordinates returns true if the home coordinates are configured
+func (c *Config) HasCoordinates() bool {
+	return c.Latitude != 0 || c.Longitude != 0
+}
+
+// FindTaskCycle returns the task names forming a cycle through depends_on,
+// on_success and on_failure_run links, or nil. Links to unknown tasks are ignored.
+func FindTaskCycle(tasks []Task) []string {
+	byName := make(map[string]*Task, len(tasks))
+	for i := range tasks {
+		byName[tasks[i].Name] = &tasks[i]
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(tasks))
+	var path []string
+
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		switch state[name] {
+		case visiting:
+			for i := range path {
+				if path[i] == name {
+					return append(append([]string{}, path[i:]...), name)
+				}
+			}
+		case visited:
+			return nil
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+		for _, link := range byName[name].Links() {
+			if _, ok := byName[link]; !ok {
+				continue
+			}
+			if cycle := visit(link); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+
+	for i := range tasks {
+		if cycle := visit(tasks[i].Name); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
 // GetServerAddress returns the server address (host:port)
 func (c *Config) GetServerAddress() string {
 	return fmt.Sprintf("%s:%d", c.Server.Host, c.Server.Port)
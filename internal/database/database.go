@@ -25,8 +25,9 @@ func NewDatabase(dataDir string) (*Database, error) {
 	// Database file path
 	dbPath := filepath.Join(dataDir, "home-ctrl.db")
 
-	// Open database connection
-	db, err := sql.Open("sqlite", dbPath)
+	// Open database connection; wait for locks instead of failing
+	// when background tasks write concurrently
+	db, err := sql.Open("sqlite", dbPath+"?_pragma=busy_timeout(5000)")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -67,6 +68,33 @@ func createTables(db *sql.DB) error {
 	return nil
 }
 
+// addColumn adds a column to an existing table unless it is already present
+func (d *Database) addColumn(table, column, definition string) error {
+	rows, err := d.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("failed to read %s columns: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return fmt.Errorf("failed to scan %s columns: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	rows.Close()
+
+	if _, err := d.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add %s.%s column: %w", table, column, err)
+	}
+	return nil
+}
+
 // Close closes the database connection
 func (d *Database) Close() error {
 	if d.db != nil {
@@ -86,6 +114,9 @@ func (d *Database) InitDatabase() error {
 	if err := d.CreateKeyValueTable(); err != nil {
 		return fmt.Errorf("failed to create key-value table: %w", err)
 	}
+	if err := d.CreateKeyValueSchemasTable(); err != nil {
+		return fmt.Errorf("failed to create key-value schemas table: %w", err)
+	}
 
 	// Check if we have any API keys
 	var count int
@@ -108,3 +139,15 @@ func (d *Database) InitDatabase() error {
 
 	return nil
 }
+
+// Backup writes a consistent copy of the database to the given file
+func (d *Database) Backup(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	if _, err := d.db.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("failed to backup database: %w", err)
+	}
+	return nil
+}
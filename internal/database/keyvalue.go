@@ -2,7 +2,12 @@ package database
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/saintbyte/home-ctrl/internal/database/models"
@@ -15,8 +20,11 @@ func (d *Database) CreateKeyValueTable() error {
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		key TEXT UNIQUE NOT NULL,
 		value TEXT NOT NULL,
+		value_type TEXT NOT NULL DEFAULT 'string',
 		status TEXT NOT NULL DEFAULT 'unread',
 		is_hidden BOOLEAN NOT NULL DEFAULT FALSE,
+		history_retention INTEGER NULL,
+		version INTEGER NOT NULL DEFAULT 1,
 		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
 		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 	)`
@@ -25,23 +33,135 @@ func (d *Database) CreateKeyValueTable() error {
 	if err != nil {
 		return fmt.Errorf("failed to create key_values table: %w", err)
 	}
+	if err := d.addColumn("key_values", "value_type", "TEXT NOT NULL DEFAULT 'string'"); err != nil {
+		return err
+	}
+	if err := d.addColumn("key_values", "history_retention", "INTEGER NULL"); err != nil {
+		return err
+	}
+	if err := d.addColumn("key_values", "version", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
 
-	// Create index for key column
-	_, err = d.db.Exec("CREATE INDEX IF NOT EXISTS idx_key_values_key ON key_values(key)")
-	if err != nil {
-		return fmt.Errorf("failed to create index: %w", err)
+	// Create indexes for lookups and the sort orders of listings
+	for _, index := range []string{
+		"CREATE INDEX IF NOT EXISTS idx_key_values_key ON key_values(key)",
+		"CREATE INDEX IF NOT EXISTS idx_key_values_created_at ON key_values(created_at, id)",
+		"CREATE INDEX IF NOT EXISTS idx_key_values_updated_at ON key_values(updated_at, id)",
+	} {
+		if _, err := d.db.Exec(index); err != nil {
+			return fmt.Errorf("failed to create index: %w", err)
+		}
 	}
 
+	// Every change of a pair is recorded in its history. Histories recorded
+	// before pairs had a version continue from their last version.
+	if err := d.CreateKeyValueHistoryTable(); err != nil {
+		return err
+	}
+	if _, err := d.db.Exec(`
+	UPDATE key_values SET version = (SELECT MAX(version) FROM key_value_history h WHERE h.key = key_values.key)
+	WHERE version < (SELECT MAX(version) FROM key_value_history h WHERE h.key = key_values.key)`); err != nil {
+		return fmt.Errorf("failed to update key-value versions: %w", err)
+	}
 	return nil
 }
 
-// CreateKeyValue creates a new key-value pair
-func (d *Database) CreateKeyValue(key, value string) (*models.KeyValue, error) {
+// ErrKeyNotFound is returned for changes of keys that don't exist
+var ErrKeyNotFound = errors.New("key not found")
+
+// ErrKeyExists is returned when creating a key that already exists
+var ErrKeyExists = errors.New("key already exists")
+
+// ErrVersionConflict is returned when a pair changed between reading and
+// writing it in a transaction
+var ErrVersionConflict = errors.New("key-value pair was changed concurrently")
+
+// ErrKeyValueVersionNotFound is returned for versions not in the history of a key
+var ErrKeyValueVersionNotFound = errors.New("version not found")
+
+// WriteOptions describe a change of a key-value pair
+type WriteOptions struct {
+	By string // who makes the change, recorded in the history, e.g. "user:admin"
+	// Check is a precondition evaluated on the current state of the pair in
+	// the transaction of the change; its error fails the change
+	Check func(kv *models.KeyValue) error
+}
+
+// keyValueColumns are the key_values columns read by scanKeyValue
+const keyValueColumns = "id, key, value, value_type, status, is_hidden, history_retention, version, created_at, updated_at"
+
+// scanKeyValue reads a row of keyValueColumns followed by extra columns
+func scanKeyValue(row interface{ Scan(...any) error }, extra ...any) (*models.KeyValue, error) {
+	var kv models.KeyValue
+	var retention sql.NullInt64
+	dest := append([]any{&kv.ID, &kv.Key, &kv.Value, &kv.ValueType, &kv.Status, &kv.IsHidden, &retention, &kv.Version, &kv.CreatedAt, &kv.UpdatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if retention.Valid {
+		versions := int(retention.Int64)
+		kv.HistoryRetention = &versions
+	}
+	return &kv, nil
+}
+
+// getKeyValue reads key in a transaction, failing with ErrKeyNotFound if it doesn't exist
+func getKeyValue(tx *sql.Tx, key string) (*models.KeyValue, error) {
+	kv, err := scanKeyValue(tx.QueryRow("SELECT "+keyValueColumns+" FROM key_values WHERE key = ?", key))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key-value: %w", err)
+	}
+	return kv, nil
+}
+
+// CreateKeyValue creates a new key-value pair. The value of number, bool and
+// json pairs is JSON text, see models.ParseValue. It fails with ErrKeyExists
+// if the key exists. opts.Check needs an existing pair: with a check set, a
+// missing key fails with ErrKeyNotFound and an existing one with the error of
+// the check or ErrKeyExists.
+func (d *Database) CreateKeyValue(key, value, valueType string, opts WriteOptions) (*models.KeyValue, error) {
 	kv := models.NewKeyValue(key, value)
+	kv.ValueType = valueType
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM key_values WHERE key = ?)", key).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to check key-value existence: %w", err)
+	}
+	if opts.Check != nil {
+		if !exists {
+			return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+		}
+		current, err := getKeyValue(tx, key)
+		if err != nil {
+			return nil, err
+		}
+		if err := opts.Check(current); err != nil {
+			return nil, err
+		}
+	}
+	if exists {
+		return nil, fmt.Errorf("%w: %s", ErrKeyExists, key)
+	}
 
-	result, err := d.db.Exec(
-		"INSERT INTO key_values (key, value, status, is_hidden, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
-		kv.Key, kv.Value, kv.Status, kv.IsHidden, kv.CreatedAt, kv.UpdatedAt,
+	last, err := lastKeyValueVersion(tx, key)
+	if err != nil {
+		return nil, err
+	}
+	kv.Version = last + 1
+
+	result, err := tx.Exec(
+		"INSERT INTO key_values (key, value, value_type, status, is_hidden, version, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		kv.Key, kv.Value, kv.ValueType, kv.Status, kv.IsHidden, kv.Version, kv.CreatedAt, kv.UpdatedAt,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create key-value: %w", err)
@@ -51,20 +171,20 @@ func (d *Database) CreateKeyValue(key, value string) (*models.KeyValue, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to get last insert id: %w", err)
 	}
-
 	kv.ID = int(id)
+
+	if err := recordKeyValueChange(tx, kv, KeyValueChangeCreate, nil, opts.By); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit key-value: %w", err)
+	}
 	return kv, nil
 }
 
 // GetKeyValue retrieves a key-value pair by key
 func (d *Database) GetKeyValue(key string) (*models.KeyValue, error) {
-	var kv models.KeyValue
-
-	err := d.db.QueryRow(
-		"SELECT id, key, value, status, is_hidden, created_at, updated_at FROM key_values WHERE key = ?",
-		key,
-	).Scan(&kv.ID, &kv.Key, &kv.Value, &kv.Status, &kv.IsHidden, &kv.CreatedAt, &kv.UpdatedAt)
-
+	kv, err := scanKeyValue(d.db.QueryRow("SELECT "+keyValueColumns+" FROM key_values WHERE key = ?", key))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -72,118 +192,474 @@ func (d *Database) GetKeyValue(key string) (*models.KeyValue, error) {
 		return nil, fmt.Errorf("failed to get key-value: %w", err)
 	}
 
-	return &kv, nil
+	return kv, nil
 }
 
-// UpdateKeyValue updates an existing key-value pair
-func (d *Database) UpdateKeyValue(key, value string) (*models.KeyValue, error) {
-	kv, err := d.GetKeyValue(key)
+// changeKeyValue applies a change to key, increments its version and records
+// it in the history in one transaction
+func (d *Database) changeKeyValue(key, change string, restoredVersion *int, opts WriteOptions, apply func(kv *models.KeyValue)) (*models.KeyValue, error) {
+	tx, err := d.db.Begin()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get key-value: %w", err)
-	}
-	if kv == nil {
-		return nil, fmt.Errorf("key not found")
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	kv.UpdateValue(value)
+	kv, err := getKeyValue(tx, key)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Check != nil {
+		if err := opts.Check(kv); err != nil {
+			return nil, err
+		}
+	}
+	apply(kv)
+	kv.Version++
 
-	_, err = d.db.Exec(
-		"UPDATE key_values SET value = ?, updated_at = ? WHERE key = ?",
-		kv.Value, kv.UpdatedAt, kv.Key,
+	result, err := tx.Exec(
+		"UPDATE key_values SET value = ?, value_type = ?, status = ?, is_hidden = ?, version = ?, updated_at = ? WHERE id = ? AND version = ?",
+		kv.Value, kv.ValueType, kv.Status, kv.IsHidden, kv.Version, kv.UpdatedAt, kv.ID, kv.Version-1,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update key-value: %w", err)
 	}
-
+	if updated, err := result.RowsAffected(); err != nil || updated != 1 {
+		return nil, ErrVersionConflict
+	}
+	if err := recordKeyValueChange(tx, kv, change, restoredVersion, opts.By); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit key-value: %w", err)
+	}
 	return kv, nil
 }
 
+// UpdateKeyValue updates the value and value type of an existing key-value pair
+func (d *Database) UpdateKeyValue(key, value, valueType string, opts WriteOptions) (*models.KeyValue, error) {
+	return d.changeKeyValue(key, KeyValueChangeValue, nil, opts, func(kv *models.KeyValue) {
+		kv.UpdateValue(value, valueType)
+	})
+}
+
 // UpdateKeyValueStatus updates the status of a key-value pair
-func (d *Database) UpdateKeyValueStatus(key, status string) (*models.KeyValue, error) {
-	kv, err := d.GetKeyValue(key)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get key-value: %w", err)
+func (d *Database) UpdateKeyValueStatus(key, status string, opts WriteOptions) (*models.KeyValue, error) {
+	return d.changeKeyValue(key, KeyValueChangeStatus, nil, opts, func(kv *models.KeyValue) {
+		kv.SetStatus(status)
+	})
+}
+
+// errKeyValueUnchanged stops a change that would leave a pair as it is
+var errKeyValueUnchanged = errors.New("key-value pair unchanged")
+
+// PublishKeyValue sets the value and value type of key and marks it unread
+// in one change, creating the pair if it doesn't exist. A pair that already
+// has the value is left alone and returned with changed false.
+func (d *Database) PublishKeyValue(key, value, valueType string, opts WriteOptions) (kv *models.KeyValue, changed bool, err error) {
+	unchanged := opts
+	unchanged.Check = func(current *models.KeyValue) error {
+		if current.Value == value && current.ValueType == valueType {
+			kv = current
+			return errKeyValueUnchanged
+		}
+		if opts.Check != nil {
+			return opts.Check(current)
+		}
+		return nil
 	}
-	if kv == nil {
-		return nil, fmt.Errorf("key not found")
+	publish := func(kv *models.KeyValue) {
+		kv.UpdateValue(value, valueType)
+		kv.SetStatus(models.StatusUnread)
+	}
+
+	// A pair created concurrently is updated instead
+	for attempt := 0; attempt < 2; attempt++ {
+		updated, err := d.changeKeyValue(key, KeyValueChangeValue, nil, unchanged, publish)
+		if errors.Is(err, errKeyValueUnchanged) {
+			return kv, false, nil
+		}
+		if !errors.Is(err, ErrKeyNotFound) {
+			return updated, err == nil, err
+		}
+		created, err := d.CreateKeyValue(key, value, valueType, opts)
+		if !errors.Is(err, ErrKeyExists) {
+			return created, err == nil, err
+		}
+	}
+	return nil, false, fmt.Errorf("%w: %s", ErrVersionConflict, key)
+}
+
+// UpdateKeyValueHidden updates the hidden flag of a key-value pair
+func (d *Database) UpdateKeyValueHidden(key string, hidden bool, opts WriteOptions) (*models.KeyValue, error) {
+	return d.changeKeyValue(key, KeyValueChangeHidden, nil, opts, func(kv *models.KeyValue) {
+		kv.SetHidden(hidden)
+	})
+}
+
+// Fields key-value pairs can be sorted by
+const (
+	KeyValueSortKey       = "key"
+	KeyValueSortCreatedAt = "created_at"
+	KeyValueSortUpdatedAt = "updated_at"
+)
+
+// ErrInvalidCursor is returned for cursors not issued by ListKeyValues for the same sort order
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// KeyValueFilter selects and orders the key-value pairs returned by ListKeyValues
+type KeyValueFilter struct {
+	IncludeHidden bool
+	Prefix        string     // keys starting with this prefix
+	Direct        bool       // only keys directly in the Prefix namespace, not in nested ones
+	Status        string     // "unread", "read" or "archived"
+	UpdatedSince  *time.Time // pairs updated at or after this time
+	Sort          string     // KeyValueSortKey, KeyValueSortCreatedAt (default) or KeyValueSortUpdatedAt
+	Descending    bool
+	Limit         int    // page size, zero means all pairs
+	Cursor        string // next cursor returned for the previous page
+}
+
+// keyValueCursor is the position after the last pair of a page. It is
+// encoded as opaque URL-safe text.
+type keyValueCursor struct {
+	Sort       string `json:"s"`
+	Descending bool   `json:"d"`
+	Value      string `json:"v"` // sort column of the last pair as stored
+	ID         int    `json:"i"`
+}
+
+func (c keyValueCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeKeyValueCursor(text string) (keyValueCursor, error) {
+	var cursor keyValueCursor
+	data, err := base64.RawURLEncoding.DecodeString(text)
+	if err != nil || json.Unmarshal(data, &cursor) != nil {
+		return cursor, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// KeySeparator separates the namespaces of hierarchical keys such as
+// "sensors/kitchen/temp"
+const KeySeparator = "/"
+
+// KeyActions are the last path segments that address an operation on a key
+// in the API instead of a key, e.g. GET /keyvalue/sensors/temp/status. Keys
+// may not end with them.
+var KeyActions = []string{"status", "exists", "hidden", "history", "rollback", "retention", "cas"}
+
+// ValidateKey checks that a new key can be addressed by its API path
+func ValidateKey(key string) error {
+	segments := strings.Split(key, KeySeparator)
+	for _, segment := range segments {
+		if segment == "" {
+			return errors.New("key must not start or end with a slash or contain empty namespaces")
+		}
+	}
+	if last := segments[len(segments)-1]; len(segments) > 1 && slices.Contains(KeyActions, last) {
+		return fmt.Errorf("key must not end with /%s", last)
+	}
+	return nil
+}
+
+// keyPrefixCondition matches keys starting with prefix. Unlike LIKE it is
+// case-sensitive and has no wildcards.
+func keyPrefixCondition(prefix string) (string, []any) {
+	return "substr(key, 1, length(?)) = ?", []any{prefix, prefix}
+}
+
+// directKeyCondition matches keys without a separator after prefix
+func directKeyCondition(prefix string) (string, []any) {
+	return "instr(substr(key, length(?) + 1), '" + KeySeparator + "') = 0", []any{prefix}
+}
+
+// ListKeyValues returns a page of the key-value pairs matching filter, the
+// number of matching pairs on all pages and the cursor of the next page,
+// which is empty on the last one. Pages are positioned by the sort column
+// and id of the last pair, so they stay consistent while pairs are added.
+func (d *Database) ListKeyValues(filter KeyValueFilter) ([]models.KeyValue, int, string, error) {
+	sortField := filter.Sort
+	switch sortField {
+	case "":
+		sortField = KeyValueSortCreatedAt
+	case KeyValueSortKey, KeyValueSortCreatedAt, KeyValueSortUpdatedAt:
+	default:
+		return nil, 0, "", fmt.Errorf("unknown sort field %q", filter.Sort)
+	}
+
+	var conditions []string
+	var args []any
+	if !filter.IncludeHidden {
+		conditions = append(conditions, "is_hidden = FALSE")
+	}
+	if filter.Prefix != "" {
+		condition, prefixArgs := keyPrefixCondition(filter.Prefix)
+		conditions = append(conditions, condition)
+		args = append(args, prefixArgs...)
+	}
+	if filter.Direct {
+		condition, directArgs := directKeyCondition(filter.Prefix)
+		conditions = append(conditions, condition)
+		args = append(args, directArgs...)
+	}
+	if filter.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if filter.UpdatedSince != nil {
+		conditions = append(conditions, "updated_at >= ?")
+		args = append(args, filter.UpdatedSince.Local().Round(0))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+	var total int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM key_values"+where, args...).Scan(&total); err != nil {
+		return nil, 0, "", fmt.Errorf("failed to count key-values: %w", err)
 	}
 
-	kv.SetStatus(status)
+	direction, comparison := "ASC", ">"
+	if filter.Descending {
+		direction, comparison = "DESC", "<"
+	}
+	if filter.Cursor != "" {
+		cursor, err := decodeKeyValueCursor(filter.Cursor)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		if cursor.Sort != sortField || cursor.Descending != filter.Descending {
+			return nil, 0, "", fmt.Errorf("%w: issued for another sort order", ErrInvalidCursor)
+		}
+		condition := fmt.Sprintf("(%s, id) %s (?, ?)", sortField, comparison)
+		if where == "" {
+			where = " WHERE " + condition
+		} else {
+			where += " AND " + condition
+		}
+		args = append(args, cursor.Value, cursor.ID)
+	}
 
-	_, err = d.db.Exec(
-		"UPDATE key_values SET status = ?, updated_at = ? WHERE key = ?",
-		kv.Status, kv.UpdatedAt, kv.Key,
+	query := fmt.Sprintf(
+		"SELECT %s, CAST(%s AS TEXT) FROM key_values%s ORDER BY %s %s, id %s",
+		keyValueColumns, sortField, where, sortField, direction, direction,
 	)
+	if filter.Limit > 0 {
+		// One more row tells whether there is a next page
+		query += " LIMIT ?"
+		args = append(args, filter.Limit+1)
+	}
+	rows, err := d.db.Query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to update key-value status: %w", err)
+		return nil, 0, "", fmt.Errorf("failed to list key-values: %w", err)
 	}
+	defer rows.Close()
 
-	return kv, nil
+	keyValues := []models.KeyValue{}
+	var sortValues []string
+	for rows.Next() {
+		var sortValue string
+		kv, err := scanKeyValue(rows, &sortValue)
+		if err != nil {
+			return nil, 0, "", fmt.Errorf("failed to scan key-value: %w", err)
+		}
+		keyValues = append(keyValues, *kv)
+		sortValues = append(sortValues, sortValue)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, "", fmt.Errorf("failed to list key-values: %w", err)
+	}
+
+	nextCursor := ""
+	if filter.Limit > 0 && len(keyValues) > filter.Limit {
+		keyValues = keyValues[:filter.Limit]
+		last := keyValues[len(keyValues)-1]
+		nextCursor = keyValueCursor{
+			Sort:       sortField,
+			Descending: filter.Descending,
+			Value:      sortValues[filter.Limit-1],
+			ID:         last.ID,
+		}.encode()
+	}
+	return keyValues, total, nextCursor, nil
 }
 
-// UpdateKeyValueHidden updates the hidden flag of a key-value pair
-func (d *Database) UpdateKeyValueHidden(key string, hidden bool) (*models.KeyValue, error) {
-	kv, err := d.GetKeyValue(key)
+// ListKeyValueNamespaces returns the namespaces directly below prefix, which
+// is empty or ends with KeySeparator, with the number of keys in each of them
+func (d *Database) ListKeyValueNamespaces(prefix string, includeHidden bool) ([]models.KeyValueNamespace, error) {
+	condition, args := keyPrefixCondition(prefix)
+	if !includeHidden {
+		condition += " AND is_hidden = FALSE"
+	}
+	query := fmt.Sprintf(`
+	SELECT substr(rest, 1, instr(rest, '%[1]s') - 1) AS name, COUNT(*)
+	FROM (SELECT substr(key, length(?) + 1) AS rest FROM key_values WHERE %[2]s)
+	WHERE instr(rest, '%[1]s') > 0
+	GROUP BY name
+	ORDER BY name`, KeySeparator, condition)
+
+	rows, err := d.db.Query(query, append([]any{prefix}, args...)...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get key-value: %w", err)
+		return nil, fmt.Errorf("failed to list key-value namespaces: %w", err)
 	}
-	if kv == nil {
-		return nil, fmt.Errorf("key not found")
+	defer rows.Close()
+
+	namespaces := []models.KeyValueNamespace{}
+	for rows.Next() {
+		var namespace models.KeyValueNamespace
+		if err := rows.Scan(&namespace.Name, &namespace.Keys); err != nil {
+			return nil, fmt.Errorf("failed to scan key-value namespace: %w", err)
+		}
+		namespace.Prefix = prefix + namespace.Name + KeySeparator
+		namespaces = append(namespaces, namespace)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list key-value namespaces: %w", err)
 	}
+	return namespaces, nil
+}
 
-	kv.SetHidden(hidden)
+// UpdateKeyValuesWithPrefix sets the status and/or hidden flag of all
+// key-value pairs whose key starts with prefix. A nil status or hidden flag
+// is left unchanged. It returns the number of pairs that changed.
+func (d *Database) UpdateKeyValuesWithPrefix(prefix string, status *string, hidden *bool, changedBy string) (int, error) {
+	var newStatus, newHidden any
+	if status != nil {
+		newStatus = *status
+	}
+	if hidden != nil {
+		newHidden = *hidden
+	}
 
-	_, err = d.db.Exec(
-		"UPDATE key_values SET is_hidden = ?, updated_at = ? WHERE key = ?",
-		kv.IsHidden, kv.UpdatedAt, kv.Key,
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	condition, args := keyPrefixCondition(prefix)
+	rows, err := tx.Query(
+		`UPDATE key_values SET status = COALESCE(?, status), is_hidden = COALESCE(?, is_hidden), version = version + 1, updated_at = ?
+		WHERE `+condition+` AND (status != COALESCE(?, status) OR is_hidden != COALESCE(?, is_hidden))
+		RETURNING `+keyValueColumns,
+		append(append([]any{newStatus, newHidden, time.Now()}, args...), newStatus, newHidden)...,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to update key-value hidden flag: %w", err)
+		return 0, fmt.Errorf("failed to update key-values: %w", err)
+	}
+	var updated []*models.KeyValue
+	for rows.Next() {
+		kv, err := scanKeyValue(rows)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan key-value: %w", err)
+		}
+		updated = append(updated, kv)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to update key-values: %w", err)
 	}
 
-	return kv, nil
+	change := KeyValueChangeStatus
+	if status == nil {
+		change = KeyValueChangeHidden
+	}
+	for _, kv := range updated {
+		if err := recordKeyValueChange(tx, kv, change, nil, changedBy); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit key-values: %w", err)
+	}
+	return len(updated), nil
 }
 
-// ListKeyValues lists all key-value pairs with optional filters
-func (d *Database) ListKeyValues(includeHidden bool) ([]models.KeyValue, error) {
-	var query string
-	var rows *sql.Rows
-	var err error
-
-	if includeHidden {
-		query = "SELECT id, key, value, status, is_hidden, created_at, updated_at FROM key_values ORDER BY created_at DESC"
-		rows, err = d.db.Query(query)
-	} else {
-		query = "SELECT id, key, value, status, is_hidden, created_at, updated_at FROM key_values WHERE is_hidden = FALSE ORDER BY created_at DESC"
-		rows, err = d.db.Query(query)
+// deleteKeyValues deletes the key-value pairs matching condition and returns
+// their number. Each deletion is recorded in the history of the key, which
+// is kept within the retention of the key.
+func (d *Database) deleteKeyValues(changedBy, condition string, args ...any) (int, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
+	rows, err := tx.Query("DELETE FROM key_values WHERE "+condition+" RETURNING "+keyValueColumns, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list key-values: %w", err)
+		return 0, fmt.Errorf("failed to delete key-values: %w", err)
 	}
-	defer rows.Close()
-
-	var keyValues []models.KeyValue
+	var deleted []*models.KeyValue
 	for rows.Next() {
-		var kv models.KeyValue
-		if err := rows.Scan(&kv.ID, &kv.Key, &kv.Value, &kv.Status, &kv.IsHidden, &kv.CreatedAt, &kv.UpdatedAt); err != nil {
-			return nil, fmt.Errorf("failed to scan key-value: %w", err)
+		kv, err := scanKeyValue(rows)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan key-value: %w", err)
 		}
-		keyValues = append(keyValues, kv)
+		deleted = append(deleted, kv)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to delete key-values: %w", err)
 	}
 
-	return keyValues, nil
+	now := time.Now()
+	for _, kv := range deleted {
+		kv.Version++
+		kv.UpdatedAt = now
+		if err := recordKeyValueChange(tx, kv, KeyValueChangeDelete, nil, changedBy); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit key-value deletion: %w", err)
+	}
+	return len(deleted), nil
 }
 
-// DeleteKeyValue deletes a key-value pair
-func (d *Database) DeleteKeyValue(key string) error {
-	_, err := d.db.Exec("DELETE FROM key_values WHERE key = ?", key)
+// DeleteKeyValue deletes a key-value pair, recording the deletion in its
+// history. A missing key is not an error unless opts.Check is set, then it
+// fails with ErrKeyNotFound.
+func (d *Database) DeleteKeyValue(key string, opts WriteOptions) error {
+	if opts.Check == nil {
+		if _, err := d.deleteKeyValues(opts.By, "key = ?", key); err != nil {
+			return fmt.Errorf("failed to delete key-value: %w", err)
+		}
+		return nil
+	}
+
+	kv, err := d.GetKeyValue(key)
+	if err != nil {
+		return err
+	}
+	if kv == nil {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, key)
+	}
+	if err := opts.Check(kv); err != nil {
+		return err
+	}
+	deleted, err := d.deleteKeyValues(opts.By, "id = ? AND version = ?", kv.ID, kv.Version)
 	if err != nil {
 		return fmt.Errorf("failed to delete key-value: %w", err)
 	}
+	if deleted != 1 {
+		return ErrVersionConflict
+	}
 	return nil
 }
 
+// DeleteKeyValuesWithPrefix deletes all key-value pairs whose key starts
+// with prefix and returns their number
+func (d *Database) DeleteKeyValuesWithPrefix(prefix, changedBy string) (int, error) {
+	condition, args := keyPrefixCondition(prefix)
+	return d.deleteKeyValues(changedBy, condition, args...)
+}
+
 // CheckKeyValueStatus checks if a key exists and returns its status
 func (d *Database) CheckKeyValueStatus(key string) (string, bool, error) {
 	var status string
@@ -221,10 +697,7 @@ func (d *Database) CheckKeyValueExists(key string) (bool, error) {
 // CleanupKeyValues cleans up old or archived key-value pairs
 func (d *Database) CleanupKeyValues(olderThan time.Duration) error {
 	cutoff := time.Now().Add(-olderThan)
-	_, err := d.db.Exec(
-		"DELETE FROM key_values WHERE status = ? AND updated_at < ?",
-		models.StatusArchived, cutoff,
-	)
+	_, err := d.deleteKeyValues("cleanup", "status = ? AND updated_at < ?", models.StatusArchived, cutoff)
 	if err != nil {
 		return fmt.Errorf("failed to cleanup key-values: %w", err)
 	}
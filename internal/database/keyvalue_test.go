@@ -0,0 +1,376 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saintbyte/home-ctrl/internal/database/models"
+)
+
+func newKeyValueDatabase(t *testing.T) *Database {
+	t.Helper()
+
+	db, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.CreateKeyValueTable(); err != nil {
+		t.Fatalf("Failed to create key-value table: %v", err)
+	}
+	return db
+}
+
+func keys(keyValues []models.KeyValue) []string {
+	names := []string{}
+	for _, kv := range keyValues {
+		names = append(names, kv.Key)
+	}
+	return names
+}
+
+func TestListKeyValuesFilters(t *testing.T) {
+	db := newKeyValueDatabase(t)
+	for _, key := range []string{"sensors/temp", "sensors/Humidity", "Sensors/upper", "devices/lamp", "sensors%/odd"} {
+		if _, err := db.CreateKeyValue(key, "1", models.ValueTypeString, WriteOptions{}); err != nil {
+			t.Fatalf("CreateKeyValue() failed: %v", err)
+		}
+	}
+	db.UpdateKeyValueStatus("sensors/temp", models.StatusRead, WriteOptions{})
+	db.UpdateKeyValueHidden("devices/lamp", true, WriteOptions{})
+
+	tests := []struct {
+		name     string
+		filter   KeyValueFilter
+		expected []string
+	}{
+		{"visible", KeyValueFilter{Sort: KeyValueSortKey}, []string{"Sensors/upper", "sensors%/odd", "sensors/Humidity", "sensors/temp"}},
+		{"hidden", KeyValueFilter{Sort: KeyValueSortKey, IncludeHidden: true}, []string{"Sensors/upper", "devices/lamp", "sensors%/odd", "sensors/Humidity", "sensors/temp"}},
+		{"prefix", KeyValueFilter{Sort: KeyValueSortKey, Prefix: "sensors/"}, []string{"sensors/Humidity", "sensors/temp"}},
+		{"wildcards", KeyValueFilter{Sort: KeyValueSortKey, Prefix: "sensors%"}, []string{"sensors%/odd"}},
+		{"status", KeyValueFilter{Status: models.StatusRead}, []string{"sensors/temp"}},
+		{"descending", KeyValueFilter{Sort: KeyValueSortKey, Descending: true, Prefix: "sensors/"}, []string{"sensors/temp", "sensors/Humidity"}},
+	}
+	for _, test := range tests {
+		keyValues, total, _, err := db.ListKeyValues(test.filter)
+		if err != nil {
+			t.Fatalf("%s: ListKeyValues() failed: %v", test.name, err)
+		}
+		if got := keys(keyValues); total != len(test.expected) || len(got) != len(test.expected) {
+			t.Errorf("%s: expected %v, got %v (total %d)", test.name, test.expected, got, total)
+		} else {
+			for i := range got {
+				if got[i] != test.expected[i] {
+					t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+					break
+				}
+			}
+		}
+	}
+
+	future := time.Now().Add(time.Hour)
+	if keyValues, total, _, err := db.ListKeyValues(KeyValueFilter{UpdatedSince: &future}); err != nil || total != 0 || len(keyValues) != 0 {
+		t.Errorf("Expected no pairs updated in the future, got %v, %d, %v", keys(keyValues), total, err)
+	}
+	past := time.Now().Add(-time.Hour).UTC()
+	if _, total, _, err := db.ListKeyValues(KeyValueFilter{UpdatedSince: &past}); err != nil || total != 4 {
+		t.Errorf("Expected all visible pairs updated in the last hour, got %d, %v", total, err)
+	}
+}
+
+func TestListKeyValuesCursor(t *testing.T) {
+	db := newKeyValueDatabase(t)
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		if _, err := db.CreateKeyValue(key, "1", models.ValueTypeString, WriteOptions{}); err != nil {
+			t.Fatalf("CreateKeyValue() failed: %v", err)
+		}
+	}
+
+	for _, filter := range []KeyValueFilter{
+		{Sort: KeyValueSortKey, Limit: 2},
+		{Sort: KeyValueSortCreatedAt, Limit: 2},
+		{Sort: KeyValueSortUpdatedAt, Descending: true, Limit: 2},
+	} {
+		var seen []string
+		pages := 0
+		for {
+			keyValues, total, next, err := db.ListKeyValues(filter)
+			if err != nil {
+				t.Fatalf("ListKeyValues(%+v) failed: %v", filter, err)
+			}
+			if total != 5 {
+				t.Errorf("Expected a total of 5 on every page, got %d", total)
+			}
+			seen = append(seen, keys(keyValues)...)
+			pages++
+			if next == "" {
+				break
+			}
+			filter.Cursor = next
+		}
+
+		expected := "abcde"
+		if filter.Descending {
+			expected = "edcba"
+		}
+		if got := strings.Join(seen, ""); got != expected || pages != 3 {
+			t.Errorf("Sorting by %s: expected %s in 3 pages, got %v in %d", filter.Sort, expected, seen, pages)
+		}
+	}
+
+	// Pairs added before the cursor position don't shift the next page
+	_, _, next, _ := db.ListKeyValues(KeyValueFilter{Sort: KeyValueSortKey, Limit: 2})
+	db.CreateKeyValue("0", "1", models.ValueTypeString, WriteOptions{})
+	if keyValues, total, _, err := db.ListKeyValues(KeyValueFilter{Sort: KeyValueSortKey, Limit: 2, Cursor: next}); err != nil || total != 6 || strings.Join(keys(keyValues), "") != "cd" {
+		t.Errorf("Expected the page after b to be c, d of 6, got %v of %d, %v", keys(keyValues), total, err)
+	}
+
+	if _, _, _, err := db.ListKeyValues(KeyValueFilter{Sort: KeyValueSortUpdatedAt, Cursor: next}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor for a cursor of another sort order, got %v", err)
+	}
+	if _, _, _, err := db.ListKeyValues(KeyValueFilter{Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestKeyValueNamespaces(t *testing.T) {
+	db := newKeyValueDatabase(t)
+	for _, key := range []string{"sensors/kitchen/temp", "sensors/kitchen/humidity", "sensors/garage/temp", "sensors/outdoor", "sensorsX/temp", "flat"} {
+		if _, err := db.CreateKeyValue(key, "1", models.ValueTypeString, WriteOptions{}); err != nil {
+			t.Fatalf("CreateKeyValue() failed: %v", err)
+		}
+	}
+
+	namespaces, err := db.ListKeyValueNamespaces("sensors/", false)
+	if err != nil {
+		t.Fatalf("ListKeyValueNamespaces() failed: %v", err)
+	}
+	if len(namespaces) != 2 || namespaces[0] != (models.KeyValueNamespace{Name: "garage", Prefix: "sensors/garage/", Keys: 1}) ||
+		namespaces[1] != (models.KeyValueNamespace{Name: "kitchen", Prefix: "sensors/kitchen/", Keys: 2}) {
+		t.Errorf("Unexpected namespaces below sensors/: %+v", namespaces)
+	}
+	keyValues, _, _, err := db.ListKeyValues(KeyValueFilter{Prefix: "sensors/", Direct: true})
+	if err != nil || strings.Join(keys(keyValues), ",") != "sensors/outdoor" {
+		t.Errorf("Expected only sensors/outdoor directly below sensors/, got %v, %v", keys(keyValues), err)
+	}
+	keyValues, _, _, err = db.ListKeyValues(KeyValueFilter{Direct: true})
+	if err != nil || strings.Join(keys(keyValues), ",") != "flat" {
+		t.Errorf("Expected only flat at the top level, got %v, %v", keys(keyValues), err)
+	}
+
+	archived, hidden := models.StatusArchived, true
+	if updated, err := db.UpdateKeyValuesWithPrefix("sensors/kitchen/", &archived, &hidden, ""); err != nil || updated != 2 {
+		t.Errorf("Expected 2 updated pairs, got %d, %v", updated, err)
+	}
+	if updated, err := db.UpdateKeyValuesWithPrefix("sensors/", nil, &hidden, ""); err != nil || updated != 2 {
+		t.Errorf("Expected only the 2 visible pairs to be hidden, got %d, %v", updated, err)
+	}
+	if kv, _ := db.GetKeyValue("sensors/kitchen/temp"); kv == nil || kv.Status != models.StatusArchived || !kv.IsHidden {
+		t.Errorf("Expected sensors/kitchen/temp to be archived and hidden, got %+v", kv)
+	}
+	if kv, _ := db.GetKeyValue("sensors/outdoor"); kv == nil || kv.Status != models.StatusUnread {
+		t.Errorf("Expected the status of sensors/outdoor to be unchanged, got %+v", kv)
+	}
+	if namespaces, err := db.ListKeyValueNamespaces("sensors/", false); err != nil || len(namespaces) != 0 {
+		t.Errorf("Expected no visible namespaces, got %+v, %v", namespaces, err)
+	}
+
+	if deleted, err := db.DeleteKeyValuesWithPrefix("sensors/", ""); err != nil || deleted != 4 {
+		t.Errorf("Expected 4 deleted pairs, got %d, %v", deleted, err)
+	}
+	keyValues, _, _, _ = db.ListKeyValues(KeyValueFilter{IncludeHidden: true, Sort: KeyValueSortKey})
+	if strings.Join(keys(keyValues), ",") != "flat,sensorsX/temp" {
+		t.Errorf("Expected only keys outside sensors/ to remain, got %v", keys(keyValues))
+	}
+}
+
+func TestKeyValueHistory(t *testing.T) {
+	db := newKeyValueDatabase(t)
+	if _, err := db.CreateKeyValue("thermostat/target", "20", models.ValueTypeNumber, WriteOptions{By: "user:admin"}); err != nil {
+		t.Fatalf("CreateKeyValue() failed: %v", err)
+	}
+	db.UpdateKeyValue("thermostat/target", "21", models.ValueTypeNumber, WriteOptions{By: "api_key:panel"})
+	db.UpdateKeyValueStatus("thermostat/target", models.StatusRead, WriteOptions{By: "user:admin"})
+	db.UpdateKeyValue("thermostat/target", "off", models.ValueTypeString, WriteOptions{By: "task:night"})
+
+	versions, err := db.ListKeyValueHistory("thermostat/target")
+	if err != nil {
+		t.Fatalf("ListKeyValueHistory() failed: %v", err)
+	}
+	changes := []string{}
+	for _, version := range versions {
+		changes = append(changes, fmt.Sprintf("%d %s %s %s %s", version.Version, version.Change, version.Value, version.Status, version.ChangedBy))
+	}
+	expected := []string{
+		"4 value off read task:night",
+		"3 status 21 read user:admin",
+		"2 value 21 unread api_key:panel",
+		"1 create 20 unread user:admin",
+	}
+	if strings.Join(changes, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected history:\n%s", strings.Join(changes, "\n"))
+	}
+
+	kv, err := db.RollbackKeyValue("thermostat/target", 2, WriteOptions{By: "user:admin"})
+	if err != nil {
+		t.Fatalf("RollbackKeyValue() failed: %v", err)
+	}
+	if kv.Value != "21" || kv.ValueType != models.ValueTypeNumber || kv.Status != models.StatusUnread {
+		t.Errorf("Expected version 2 to be restored, got %+v", kv)
+	}
+	latest, _ := db.GetKeyValueVersion("thermostat/target", 5)
+	if latest == nil || latest.Change != KeyValueChangeRollback || latest.RestoredVersion == nil || *latest.RestoredVersion != 2 {
+		t.Errorf("Expected the rollback to be recorded as version 5, got %+v", latest)
+	}
+	if _, err := db.RollbackKeyValue("thermostat/target", 9, WriteOptions{}); !errors.Is(err, ErrKeyValueVersionNotFound) {
+		t.Errorf("Expected ErrKeyValueVersionNotFound, got %v", err)
+	}
+	if _, err := db.UpdateKeyValue("missing", "1", models.ValueTypeString, WriteOptions{}); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+
+	// Lowering the retention drops the oldest versions right away
+	retention := 2
+	if _, err := db.SetKeyValueHistoryRetention("thermostat/target", &retention, WriteOptions{}); err != nil {
+		t.Fatalf("SetKeyValueHistoryRetention() failed: %v", err)
+	}
+	db.UpdateKeyValueHidden("thermostat/target", true, WriteOptions{})
+	versions, _ = db.ListKeyValueHistory("thermostat/target")
+	if len(versions) != 2 || versions[0].Version != 6 || versions[1].Version != 5 {
+		t.Errorf("Expected versions 6 and 5 to be kept, got %+v", versions)
+	}
+
+	db.CreateKeyValue("thermostat/mode", "auto", models.ValueTypeString, WriteOptions{})
+	archived := models.StatusArchived
+	db.UpdateKeyValuesWithPrefix("thermostat/", &archived, nil, "user:admin")
+	versions, _ = db.ListKeyValueHistory("thermostat/mode")
+	if len(versions) != 2 || versions[0].Change != KeyValueChangeStatus || versions[0].Status != models.StatusArchived {
+		t.Errorf("Expected the prefix update to be recorded, got %+v", versions)
+	}
+
+	// A deletion is recorded within the retention of the key, and a key
+	// created again continues its history
+	db.DeleteKeyValue("thermostat/target", WriteOptions{By: "user:admin"})
+	versions, _ = db.ListKeyValueHistory("thermostat/target")
+	if len(versions) != 2 || versions[0].Version != 8 || versions[0].Change != KeyValueChangeDelete ||
+		versions[0].Value != "21" || versions[0].ChangedBy != "user:admin" {
+		t.Errorf("Expected the deletion to be recorded as version 8, got %+v", versions)
+	}
+	if kv, err := db.CreateKeyValue("thermostat/target", "19", models.ValueTypeNumber, WriteOptions{}); err != nil || kv.Version != 9 {
+		t.Errorf("Expected the key to be created again as version 9, got %+v, %v", kv, err)
+	}
+	if versions, _ := db.ListKeyValueHistory("thermostat/target"); len(versions) != 3 || versions[0].Change != KeyValueChangeCreate {
+		t.Errorf("Expected the history to continue, got %+v", versions)
+	}
+
+	db.DeleteKeyValuesWithPrefix("thermostat/", "user:admin")
+	if versions, _ := db.ListKeyValueHistory("thermostat/mode"); len(versions) != 3 || versions[0].Change != KeyValueChangeDelete {
+		t.Errorf("Expected the prefix deletion to be recorded, got %+v", versions)
+	}
+}
+
+func TestKeyValueVersions(t *testing.T) {
+	db := newKeyValueDatabase(t)
+	kv, err := db.CreateKeyValue("lamp", "on", models.ValueTypeString, WriteOptions{})
+	if err != nil || kv.Version != 1 {
+		t.Fatalf("Expected version 1, got %+v, %v", kv, err)
+	}
+	if _, err := db.CreateKeyValue("lamp", "off", models.ValueTypeString, WriteOptions{}); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("Expected ErrKeyExists, got %v", err)
+	}
+
+	db.UpdateKeyValue("lamp", "off", models.ValueTypeString, WriteOptions{})
+	kv, _ = db.UpdateKeyValueHidden("lamp", true, WriteOptions{})
+	if kv.Version != 3 {
+		t.Errorf("Expected version 3, got %d", kv.Version)
+	}
+
+	// A failing check leaves the pair unchanged
+	stale := func(current *models.KeyValue) error {
+		if current.Version != 2 {
+			return ErrVersionConflict
+		}
+		return nil
+	}
+	if _, err := db.UpdateKeyValue("lamp", "on", models.ValueTypeString, WriteOptions{Check: stale}); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected ErrVersionConflict, got %v", err)
+	}
+	if err := db.DeleteKeyValue("lamp", WriteOptions{Check: stale}); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected ErrVersionConflict deleting, got %v", err)
+	}
+	if kv, _ := db.GetKeyValue("lamp"); kv == nil || kv.Value != "off" || kv.Version != 3 {
+		t.Errorf("Expected version 3 to be unchanged, got %+v", kv)
+	}
+
+	archived := models.StatusArchived
+	db.UpdateKeyValuesWithPrefix("", &archived, nil, "")
+	if kv, _ := db.GetKeyValue("lamp"); kv.Version != 4 {
+		t.Errorf("Expected prefix updates to increase the version, got %d", kv.Version)
+	}
+
+	if err := db.DeleteKeyValue("missing", WriteOptions{Check: stale}); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestPublishKeyValue(t *testing.T) {
+	db := newKeyValueDatabase(t)
+	publish := WriteOptions{By: "task:poll"}
+	if kv, changed, err := db.PublishKeyValue("lamp", "on", models.ValueTypeString, publish); err != nil || !changed || kv.Version != 1 {
+		t.Fatalf("Expected the pair to be created, got %+v, %v, %v", kv, changed, err)
+	}
+	db.UpdateKeyValueStatus("lamp", models.StatusRead, WriteOptions{})
+
+	if kv, changed, err := db.PublishKeyValue("lamp", "on", models.ValueTypeString, publish); err != nil || changed || kv.Status != models.StatusRead {
+		t.Errorf("Expected an unchanged value to be left alone, got %+v, %v, %v", kv, changed, err)
+	}
+	kv, changed, err := db.PublishKeyValue("lamp", "off", models.ValueTypeString, publish)
+	if err != nil || !changed || kv.Value != "off" || kv.Status != models.StatusUnread || kv.Version != 3 {
+		t.Errorf("Expected the value to change and be unread in version 3, got %+v, %v, %v", kv, changed, err)
+	}
+	if err := ValidateKey("lamp/history"); err == nil {
+		t.Errorf("Expected keys ending with an action to be invalid")
+	}
+}
+
+func TestFindKeyValueSchema(t *testing.T) {
+	db := newKeyValueDatabase(t)
+	if err := db.CreateKeyValueSchemasTable(); err != nil {
+		t.Fatalf("Failed to create key-value schemas table: %v", err)
+	}
+	for _, prefix := range []string{"sensors/", "sensors/kitchen", "sensors/kitchen/temp/"} {
+		if _, err := db.SetKeyValueSchema(prefix, []byte(`{}`)); err != nil {
+			t.Fatalf("SetKeyValueSchema() failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"sensors/kitchen", "sensors/kitchen"},
+		{"sensors/kitchen/humidity", "sensors/kitchen"},
+		{"sensors/kitchen/temp/max", "sensors/kitchen/temp/"},
+		// A sibling sharing the first characters of a prefix is not below it
+		{"sensors/kitchenette", "sensors/"},
+		{"sensors/kitchen_temp", "sensors/"},
+		{"sensors", ""},
+		{"devices/lamp", ""},
+	}
+	for _, tt := range tests {
+		schema, err := db.FindKeyValueSchema(tt.key)
+		if err != nil {
+			t.Fatalf("FindKeyValueSchema(%q) failed: %v", tt.key, err)
+		}
+		got := ""
+		if schema != nil {
+			got = schema.Prefix
+		}
+		if got != tt.want {
+			t.Errorf("FindKeyValueSchema(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
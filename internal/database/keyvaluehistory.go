@@ -0,0 +1,199 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/saintbyte/home-ctrl/internal/database/models"
+)
+
+// DefaultKeyValueHistoryRetention is the number of versions kept for keys
+// without a retention of their own
+const DefaultKeyValueHistoryRetention = 20
+
+// MaxKeyValueHistoryRetention is the largest retention a key can have
+const MaxKeyValueHistoryRetention = 1000
+
+// Changes recorded in the key-value history
+const (
+	KeyValueChangeCreate   = "create"
+	KeyValueChangeValue    = "value"
+	KeyValueChangeStatus   = "status"
+	KeyValueChangeHidden   = "hidden"
+	KeyValueChangeRollback = "rollback"
+	KeyValueChangeDelete   = "delete"
+)
+
+// CreateKeyValueHistoryTable creates the key_value_history table if it doesn't exist
+func (d *Database) CreateKeyValueHistoryTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS key_value_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		key TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		value TEXT NOT NULL,
+		value_type TEXT NOT NULL,
+		status TEXT NOT NULL,
+		is_hidden BOOLEAN NOT NULL,
+		change TEXT NOT NULL,
+		restored_version INTEGER NULL,
+		changed_by TEXT NOT NULL DEFAULT '',
+		changed_at TIMESTAMP NOT NULL,
+		UNIQUE(key, version)
+	)`
+
+	if _, err := d.db.Exec(query); err != nil {
+		return fmt.Errorf("failed to create key_value_history table: %w", err)
+	}
+	return nil
+}
+
+// recordKeyValueChange adds the state of kv after a change to its history
+// under its version and drops the versions beyond its retention
+func recordKeyValueChange(tx *sql.Tx, kv *models.KeyValue, change string, restoredVersion *int, changedBy string) error {
+	if _, err := tx.Exec(
+		`INSERT INTO key_value_history (key, version, value, value_type, status, is_hidden, change, restored_version, changed_by, changed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		kv.Key, kv.Version, kv.Value, kv.ValueType, kv.Status, kv.IsHidden, change, restoredVersion, changedBy, kv.UpdatedAt,
+	); err != nil {
+		return fmt.Errorf("failed to record key-value change: %w", err)
+	}
+
+	retention := DefaultKeyValueHistoryRetention
+	if kv.HistoryRetention != nil {
+		retention = *kv.HistoryRetention
+	}
+	if _, err := tx.Exec(
+		"DELETE FROM key_value_history WHERE key = ? AND version <= ?", kv.Key, kv.Version-retention,
+	); err != nil {
+		return fmt.Errorf("failed to prune key-value history: %w", err)
+	}
+	return nil
+}
+
+// lastKeyValueVersion returns the newest version in the history of key, or
+// 0 if it has none. A key created again continues after it.
+func lastKeyValueVersion(tx *sql.Tx, key string) (int, error) {
+	var version int
+	if err := tx.QueryRow("SELECT COALESCE(MAX(version), 0) FROM key_value_history WHERE key = ?", key).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to get last key-value version: %w", err)
+	}
+	return version, nil
+}
+
+const keyValueVersionColumns = "version, key, value, value_type, status, is_hidden, change, restored_version, changed_by, changed_at"
+
+func scanKeyValueVersion(row interface{ Scan(...any) error }) (*models.KeyValueVersion, error) {
+	var version models.KeyValueVersion
+	var restored sql.NullInt64
+	if err := row.Scan(&version.Version, &version.Key, &version.Value, &version.ValueType, &version.Status,
+		&version.IsHidden, &version.Change, &restored, &version.ChangedBy, &version.ChangedAt); err != nil {
+		return nil, err
+	}
+	if restored.Valid {
+		restoredVersion := int(restored.Int64)
+		version.RestoredVersion = &restoredVersion
+	}
+	return &version, nil
+}
+
+// ListKeyValueHistory returns the recorded versions of key, newest first
+func (d *Database) ListKeyValueHistory(key string) ([]models.KeyValueVersion, error) {
+	rows, err := d.db.Query(
+		"SELECT "+keyValueVersionColumns+" FROM key_value_history WHERE key = ? ORDER BY version DESC", key,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list key-value history: %w", err)
+	}
+	defer rows.Close()
+
+	versions := []models.KeyValueVersion{}
+	for rows.Next() {
+		version, err := scanKeyValueVersion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan key-value version: %w", err)
+		}
+		versions = append(versions, *version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list key-value history: %w", err)
+	}
+	return versions, nil
+}
+
+// GetKeyValueVersion returns a recorded version of key, or nil if it is not
+// in the history
+func (d *Database) GetKeyValueVersion(key string, version int) (*models.KeyValueVersion, error) {
+	recorded, err := scanKeyValueVersion(d.db.QueryRow(
+		"SELECT "+keyValueVersionColumns+" FROM key_value_history WHERE key = ? AND version = ?", key, version,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key-value version: %w", err)
+	}
+	return recorded, nil
+}
+
+// RollbackKeyValue restores the value, value type, status and hidden flag
+// of a recorded version of key. The rollback is recorded as a new version.
+func (d *Database) RollbackKeyValue(key string, version int, opts WriteOptions) (*models.KeyValue, error) {
+	recorded, err := d.GetKeyValueVersion(key, version)
+	if err != nil {
+		return nil, err
+	}
+	if recorded == nil {
+		return nil, fmt.Errorf("%w: %s version %d", ErrKeyValueVersionNotFound, key, version)
+	}
+
+	return d.changeKeyValue(key, KeyValueChangeRollback, &version, opts, func(kv *models.KeyValue) {
+		kv.UpdateValue(recorded.Value, recorded.ValueType)
+		kv.Status = recorded.Status
+		kv.IsHidden = recorded.IsHidden
+	})
+}
+
+// SetKeyValueHistoryRetention sets the number of versions kept for key,
+// including the current one. Nil restores DefaultKeyValueHistoryRetention.
+// The version of the pair doesn't change; opts.By is not used.
+func (d *Database) SetKeyValueHistoryRetention(key string, versions *int, opts WriteOptions) (*models.KeyValue, error) {
+	if versions != nil && (*versions < 1 || *versions > MaxKeyValueHistoryRetention) {
+		return nil, fmt.Errorf("retention must be between 1 and %d versions", MaxKeyValueHistoryRetention)
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	kv, err := getKeyValue(tx, key)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Check != nil {
+		if err := opts.Check(kv); err != nil {
+			return nil, err
+		}
+	}
+	kv.HistoryRetention = versions
+	if _, err := tx.Exec("UPDATE key_values SET history_retention = ? WHERE id = ?", versions, kv.ID); err != nil {
+		return nil, fmt.Errorf("failed to set key-value history retention: %w", err)
+	}
+
+	retention := DefaultKeyValueHistoryRetention
+	if versions != nil {
+		retention = *versions
+	}
+	if _, err := tx.Exec(
+		"DELETE FROM key_value_history WHERE key = ? AND version <= ?", key, kv.Version-retention,
+	); err != nil {
+		return nil, fmt.Errorf("failed to prune key-value history: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit key-value history retention: %w", err)
+	}
+	return kv, nil
+}
@@ -0,0 +1,175 @@
+package database
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/saintbyte/home-ctrl/internal/database/models"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// ErrInvalidValue is returned for values rejected by the schema of their key
+var ErrInvalidValue = errors.New("value does not match the schema")
+
+// KeyValueSchema is a JSON Schema validating the values of the key Prefix
+// and the keys below it
+type KeyValueSchema struct {
+	Prefix    string          `json:"prefix"`
+	Schema    json.RawMessage `json:"schema"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// CreateKeyValueSchemasTable creates the key_value_schemas table if it doesn't exist
+func (d *Database) CreateKeyValueSchemasTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS key_value_schemas (
+		prefix TEXT PRIMARY KEY,
+		schema TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := d.db.Exec(query); err != nil {
+		return fmt.Errorf("failed to create key_value_schemas table: %w", err)
+	}
+	return nil
+}
+
+// SetKeyValueSchema registers the schema of prefix, replacing an existing one
+func (d *Database) SetKeyValueSchema(prefix string, schema json.RawMessage) (*KeyValueSchema, error) {
+	now := time.Now()
+	if _, err := d.db.Exec(
+		`INSERT INTO key_value_schemas (prefix, schema, created_at, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(prefix) DO UPDATE SET schema = excluded.schema, updated_at = excluded.updated_at`,
+		prefix, string(schema), now, now,
+	); err != nil {
+		return nil, fmt.Errorf("failed to set key-value schema: %w", err)
+	}
+	return d.GetKeyValueSchema(prefix)
+}
+
+// GetKeyValueSchema returns the schema registered for prefix, or nil if there is none
+func (d *Database) GetKeyValueSchema(prefix string) (*KeyValueSchema, error) {
+	schema, err := scanKeyValueSchema(d.db.QueryRow(
+		"SELECT prefix, schema, created_at, updated_at FROM key_value_schemas WHERE prefix = ?", prefix,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key-value schema: %w", err)
+	}
+	return schema, nil
+}
+
+// FindKeyValueSchema returns the schema of the longest prefix of key, or nil
+// if no registered prefix matches. Prefixes match whole segments: the
+// schema of "sensors/kitchen" applies to that key and to the keys below
+// "sensors/kitchen/", but not to "sensors/kitchenette".
+func (d *Database) FindKeyValueSchema(key string) (*KeyValueSchema, error) {
+	schema, err := scanKeyValueSchema(d.db.QueryRow(
+		`SELECT prefix, schema, created_at, updated_at FROM key_value_schemas
+		WHERE prefix = ?
+			OR (substr(prefix, -1) = ? AND substr(?, 1, length(prefix)) = prefix)
+			OR substr(?, 1, length(prefix) + 1) = prefix || ?
+		ORDER BY length(prefix) DESC LIMIT 1`, key, KeySeparator, key, key, KeySeparator,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find key-value schema: %w", err)
+	}
+	return schema, nil
+}
+
+// ListKeyValueSchemas returns all registered schemas ordered by prefix
+func (d *Database) ListKeyValueSchemas() ([]KeyValueSchema, error) {
+	rows, err := d.db.Query("SELECT prefix, schema, created_at, updated_at FROM key_value_schemas ORDER BY prefix")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list key-value schemas: %w", err)
+	}
+	defer rows.Close()
+
+	schemas := []KeyValueSchema{}
+	for rows.Next() {
+		schema, err := scanKeyValueSchema(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan key-value schema: %w", err)
+		}
+		schemas = append(schemas, *schema)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list key-value schemas: %w", err)
+	}
+	return schemas, nil
+}
+
+// DeleteKeyValueSchema removes the schema of prefix. It returns false if
+// there was none.
+func (d *Database) DeleteKeyValueSchema(prefix string) (bool, error) {
+	result, err := d.db.Exec("DELETE FROM key_value_schemas WHERE prefix = ?", prefix)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete key-value schema: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get deleted rows: %w", err)
+	}
+	return deleted > 0, nil
+}
+
+func scanKeyValueSchema(row interface{ Scan(...any) error }) (*KeyValueSchema, error) {
+	var schema KeyValueSchema
+	var text string
+	if err := row.Scan(&schema.Prefix, &text, &schema.CreatedAt, &schema.UpdatedAt); err != nil {
+		return nil, err
+	}
+	schema.Schema = json.RawMessage(text)
+	return &schema, nil
+}
+
+// CompileKeyValueSchema compiles the schema document of prefix. References
+// to other documents are not loaded.
+func CompileKeyValueSchema(prefix string, document []byte) (*jsonschema.Schema, error) {
+	schema, err := jsonschema.UnmarshalJSON(bytes.NewReader(document))
+	if err != nil {
+		return nil, fmt.Errorf("schema must be valid JSON: %w", err)
+	}
+
+	location := "urn:home-ctrl:keyvalue-schema:" + prefix
+	compiler := jsonschema.NewCompiler()
+	compiler.UseLoader(jsonschema.SchemeURLLoader{})
+	if err := compiler.AddResource(location, schema); err != nil {
+		return nil, err
+	}
+	return compiler.Compile(location)
+}
+
+// ValidateKeyValue checks a value about to be stored for key against the
+// schema with the longest prefix of key, if there is one. Values the schema
+// rejects fail with ErrInvalidValue.
+func (d *Database) ValidateKeyValue(key, value, valueType string) error {
+	registered, err := d.FindKeyValueSchema(key)
+	if err != nil || registered == nil {
+		return err
+	}
+	schema, err := CompileKeyValueSchema(registered.Prefix, registered.Schema)
+	if err != nil {
+		return fmt.Errorf("failed to compile the schema of %s: %w", registered.Prefix, err)
+	}
+
+	decoded, err := (&models.KeyValue{Value: value, ValueType: valueType}).Decoded()
+	if err != nil {
+		return fmt.Errorf("failed to decode value: %w", err)
+	}
+	if err := schema.Validate(decoded); err != nil {
+		return fmt.Errorf("%w of %s: %v", ErrInvalidValue, registered.Prefix, err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Lease is a named lock held by one process until it expires or is released
+type Lease struct {
+	Name       string    `json:"name"`
+	Holder     string    `json:"holder"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// CreateLeasesTable creates the leases table if it doesn't exist.
+// Times are stored as Unix milliseconds so they compare correctly in SQL.
+func (d *Database) CreateLeasesTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS leases (
+		name TEXT PRIMARY KEY,
+		holder TEXT NOT NULL,
+		acquired_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL
+	)`
+
+	if _, err := d.db.Exec(query); err != nil {
+		return fmt.Errorf("failed to create leases table: %w", err)
+	}
+	return nil
+}
+
+// AcquireLease takes or renews a lease for ttl. It returns false if the lease
+// is held by another holder and has not expired yet.
+func (d *Database) AcquireLease(name, holder string, ttl time.Duration, now time.Time) (bool, error) {
+	result, err := d.db.Exec(
+		`INSERT INTO leases (name, holder, acquired_at, expires_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			acquired_at = CASE WHEN leases.holder = excluded.holder THEN leases.acquired_at ELSE excluded.acquired_at END,
+			holder = excluded.holder,
+			expires_at = excluded.expires_at
+		WHERE leases.holder = excluded.holder OR leases.expires_at <= excluded.acquired_at`,
+		name, holder, now.UnixMilli(), now.Add(ttl).UnixMilli(),
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lease: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lease: %w", err)
+	}
+	return affected > 0, nil
+}
+
+// ReleaseLease gives up a lease if it is held by holder
+func (d *Database) ReleaseLease(name, holder string) error {
+	if _, err := d.db.Exec("DELETE FROM leases WHERE name = ? AND holder = ?", name, holder); err != nil {
+		return fmt.Errorf("failed to release lease: %w", err)
+	}
+	return nil
+}
+
+// GetLease retrieves a lease by name, or nil if nobody holds it
+func (d *Database) GetLease(name string) (*Lease, error) {
+	var lease Lease
+	var acquiredAt, expiresAt int64
+	err := d.db.QueryRow(
+		"SELECT name, holder, acquired_at, expires_at FROM leases WHERE name = ?",
+		name,
+	).Scan(&lease.Name, &lease.Holder, &acquiredAt, &expiresAt)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get lease: %w", err)
+	}
+
+	lease.AcquiredAt = time.UnixMilli(acquiredAt)
+	lease.ExpiresAt = time.UnixMilli(expiresAt)
+	return &lease, nil
+}
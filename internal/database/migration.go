@@ -87,6 +87,11 @@ func (d *Database) runMigration(migration Migration) error {
 		}
 
 		if _, err := d.db.Exec(stmt); err != nil {
+			// Migrations run on every start and SQLite can't add a column only
+			// if it is missing, so a column added before is not an error
+			if strings.Contains(err.Error(), "duplicate column name") {
+				continue
+			}
 			return fmt.Errorf("failed to execute statement '%s': %w", stmt, err)
 		}
 	}
@@ -106,6 +111,21 @@ func (d *Database) InitDatabaseWithMigrations() error {
 	if err := d.CreateKeyValueTable(); err != nil {
 		return fmt.Errorf("failed to create key-value table: %w", err)
 	}
+	if err := d.CreateKeyValueSchemasTable(); err != nil {
+		return fmt.Errorf("failed to create key-value schemas table: %w", err)
+	}
+	if err := d.CreateTaskRunsTable(); err != nil {
+		return fmt.Errorf("failed to create task runs table: %w", err)
+	}
+	if err := d.CreateTasksTable(); err != nil {
+		return fmt.Errorf("failed to create tasks table: %w", err)
+	}
+	if err := d.CreateTaskChainsTable(); err != nil {
+		return fmt.Errorf("failed to create task chains table: %w", err)
+	}
+	if err := d.CreateLeasesTable(); err != nil {
+		return fmt.Errorf("failed to create leases table: %w", err)
+	}
 
 	return nil
 }
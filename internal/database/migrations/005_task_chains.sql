@@ -0,0 +1,12 @@
+-- Migration 005: Task chain runs
+
+CREATE TABLE IF NOT EXISTS task_chains (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    task_name TEXT NOT NULL,
+    trigger_type TEXT NOT NULL,
+    status TEXT NOT NULL,
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_task_chains_task_name ON task_chains(task_name, started_at);
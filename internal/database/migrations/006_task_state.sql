@@ -0,0 +1,6 @@
+-- Migration 006: Scheduler state of tasks, used to catch up missed runs
+
+CREATE TABLE IF NOT EXISTS task_state (
+    name TEXT PRIMARY KEY,
+    last_fired_at TIMESTAMP NOT NULL
+);
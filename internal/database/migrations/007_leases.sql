@@ -0,0 +1,9 @@
+-- Migration 007: Leases electing the instance that runs scheduled tasks
+-- when several share a data directory. Times are Unix milliseconds.
+
+CREATE TABLE IF NOT EXISTS leases (
+    name TEXT PRIMARY KEY,
+    holder TEXT NOT NULL,
+    acquired_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL
+);
@@ -0,0 +1,7 @@
+-- Migration 008: Global scheduler state, e.g. whether scheduled runs are paused
+
+CREATE TABLE IF NOT EXISTS scheduler_state (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    paused BOOLEAN NOT NULL DEFAULT FALSE,
+    paused_at TIMESTAMP NULL
+);
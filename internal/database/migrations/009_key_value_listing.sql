@@ -0,0 +1,4 @@
+-- Migration 009: Indexes for the sort orders of key-value listings
+
+CREATE INDEX IF NOT EXISTS idx_key_values_created_at ON key_values(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_key_values_updated_at ON key_values(updated_at, id);
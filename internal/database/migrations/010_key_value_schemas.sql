@@ -0,0 +1,9 @@
+-- Migration 010: JSON Schemas validating the values of the keys starting
+-- with a prefix
+
+CREATE TABLE IF NOT EXISTS key_value_schemas (
+    prefix TEXT PRIMARY KEY,
+    schema TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,17 @@
+-- Migration 011: History of the values, statuses and hidden flags of
+-- key-value pairs
+
+CREATE TABLE IF NOT EXISTS key_value_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    key TEXT NOT NULL,
+    version INTEGER NOT NULL,
+    value TEXT NOT NULL,
+    value_type TEXT NOT NULL,
+    status TEXT NOT NULL,
+    is_hidden BOOLEAN NOT NULL,
+    change TEXT NOT NULL,
+    restored_version INTEGER NULL,
+    changed_by TEXT NOT NULL DEFAULT '',
+    changed_at TIMESTAMP NOT NULL,
+    UNIQUE(key, version)
+);
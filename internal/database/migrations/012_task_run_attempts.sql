@@ -0,0 +1,3 @@
+-- Migration 012: Attempt number of task runs, counting retries of a trigger
+
+ALTER TABLE task_runs ADD COLUMN attempt INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,3 @@
+-- Migration 013: Task chain a task run belongs to
+
+ALTER TABLE task_runs ADD COLUMN chain_id INTEGER NULL;
@@ -0,0 +1,3 @@
+-- Migration 014: Parameters of task runs of parameterized tasks
+
+ALTER TABLE task_runs ADD COLUMN params TEXT NULL;
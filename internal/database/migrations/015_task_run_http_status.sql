@@ -0,0 +1,3 @@
+-- Migration 015: Response status of task runs of HTTP tasks
+
+ALTER TABLE task_runs ADD COLUMN http_status INTEGER NULL;
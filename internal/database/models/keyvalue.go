@@ -1,16 +1,43 @@
 package models
 
-import "time"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
 
 // KeyValue represents a key-value pair with status and visibility flags
 type KeyValue struct {
-	ID        int       `json:"id" db:"id"`
-	Key       string    `json:"key" db:"key"`
-	Value     string    `json:"value" db:"value"`
-	Status    string    `json:"status" db:"status"`       // "unread", "read", "archived"
-	IsHidden  bool      `json:"is_hidden" db:"is_hidden"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	ID               int       `json:"id" db:"id"`
+	Key              string    `json:"key" db:"key"`
+	Value            string    `json:"value" db:"value"`
+	ValueType        string    `json:"value_type" db:"value_type"` // "string", "number", "bool" or "json"
+	Status           string    `json:"status" db:"status"`         // "unread", "read", "archived"
+	IsHidden         bool      `json:"is_hidden" db:"is_hidden"`
+	HistoryRetention *int      `json:"history_retention" db:"history_retention"` // versions kept in the history, the default if nil
+	Version          int       `json:"version" db:"version"`                     // incremented by every change
+	CreatedAt        time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Value types of key-value pairs. Values of the number, bool and json types
+// are stored as JSON text and returned as JSON in API responses.
+const (
+	ValueTypeString = "string"
+	ValueTypeNumber = "number"
+	ValueTypeBool   = "bool"
+	ValueTypeJSON   = "json"
+)
+
+// KeyValueNamespace is a level of hierarchical keys, e.g. "kitchen" in
+// "sensors/kitchen/temp"
+type KeyValueNamespace struct {
+	Name   string `json:"name"`
+	Prefix string `json:"prefix"` // full prefix of the keys in the namespace, ending with a separator
+	Keys   int    `json:"keys"`   // number of keys in the namespace and the namespaces below it
 }
 
 // KeyValueStatus constants
@@ -25,8 +52,10 @@ func NewKeyValue(key, value string) *KeyValue {
 	return &KeyValue{
 		Key:       key,
 		Value:     value,
+		ValueType: ValueTypeString,
 		Status:    StatusUnread,
 		IsHidden:  false,
+		Version:   1,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
@@ -44,8 +73,200 @@ func (kv *KeyValue) SetHidden(hidden bool) {
 	kv.UpdatedAt = time.Now()
 }
 
-// UpdateValue updates the value
-func (kv *KeyValue) UpdateValue(value string) {
+// UpdateValue updates the value and its type
+func (kv *KeyValue) UpdateValue(value, valueType string) {
 	kv.Value = value
+	kv.ValueType = valueType
 	kv.UpdatedAt = time.Now()
-}
\ No newline at end of file
+}
+
+// ETag returns the entity tag of the current version of the pair. It
+// includes the ID so that a recreated key doesn't match tags of the old one.
+func (kv *KeyValue) ETag() string {
+	return fmt.Sprintf(`"%d.%d"`, kv.ID, kv.Version)
+}
+
+// MatchesETag reports whether an If-Match header value, a list of entity
+// tags or "*", matches the pair. Weak tags never match.
+func (kv *KeyValue) MatchesETag(ifMatch string) bool {
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == kv.ETag() {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyValueVersion is the state of a key-value pair recorded in its history
+// after a change
+type KeyValueVersion struct {
+	Version         int       `json:"version"`
+	Key             string    `json:"key"`
+	Value           string    `json:"value"`
+	ValueType       string    `json:"value_type"`
+	Status          string    `json:"status"`
+	IsHidden        bool      `json:"is_hidden"`
+	Change          string    `json:"change"`                     // "create", "value", "status", "hidden" or "rollback"
+	RestoredVersion *int      `json:"restored_version,omitempty"` // version restored by a rollback
+	ChangedBy       string    `json:"changed_by"`                 // e.g. "user:admin", "api_key:<name>" or "task:<name>"
+	ChangedAt       time.Time `json:"changed_at"`
+}
+
+// typedValue returns the value to encode for a value of valueType
+func typedValue(value, valueType string) any {
+	if valueType != ValueTypeString && valueType != "" && json.Valid([]byte(value)) {
+		return json.RawMessage(value)
+	}
+	return value
+}
+
+// MarshalJSON encodes the value of number, bool and json pairs as JSON
+// rather than as a string
+func (kv KeyValue) MarshalJSON() ([]byte, error) {
+	type plain KeyValue
+	return json.Marshal(struct {
+		plain
+		Value any `json:"value"`
+	}{plain: plain(kv), Value: typedValue(kv.Value, kv.ValueType)})
+}
+
+// MarshalJSON encodes the value like KeyValue.MarshalJSON
+func (v KeyValueVersion) MarshalJSON() ([]byte, error) {
+	type plain KeyValueVersion
+	return json.Marshal(struct {
+		plain
+		Value any `json:"value"`
+	}{plain: plain(v), Value: typedValue(v.Value, v.ValueType)})
+}
+
+// Decoded returns the value as decoded JSON: a string for string pairs,
+// otherwise the result of decoding the stored JSON with numbers as json.Number
+func (kv *KeyValue) Decoded() (any, error) {
+	if kv.ValueType == ValueTypeString || kv.ValueType == "" {
+		return kv.Value, nil
+	}
+	return decodeJSON([]byte(kv.Value))
+}
+
+// ParseValue converts a JSON value of an API request into the text stored
+// for it and its type. Without a value type it is inferred from the JSON:
+// strings are string pairs, objects and arrays json pairs. A string is
+// accepted for the number, bool and json types when it contains JSON of the
+// type, so that "21.5" can be stored as a number.
+func ParseValue(raw json.RawMessage, valueType string) (string, string, error) {
+	value, err := decodeJSON(raw)
+	if err != nil {
+		return "", "", fmt.Errorf("value must be valid JSON: %w", err)
+	}
+	if value == nil {
+		return "", "", errors.New("value must not be null")
+	}
+	if text, ok := value.(string); ok && valueType != "" && valueType != ValueTypeString {
+		if value, err = decodeJSON([]byte(text)); err != nil {
+			return "", "", fmt.Errorf("value must contain JSON for value_type %s: %w", valueType, err)
+		}
+	}
+
+	switch value.(type) {
+	case string:
+		if valueType == "" || valueType == ValueTypeString {
+			return value.(string), ValueTypeString, nil
+		}
+	case json.Number:
+		if valueType == "" {
+			valueType = ValueTypeNumber
+		}
+	case bool:
+		if valueType == "" {
+			valueType = ValueTypeBool
+		}
+	default:
+		if valueType == "" {
+			valueType = ValueTypeJSON
+		}
+	}
+	if !valueMatches(value, valueType) {
+		return "", "", fmt.Errorf("value does not match value_type %s", valueType)
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode value: %w", err)
+	}
+	return string(encoded), valueType, nil
+}
+
+// valueMatches reports whether a decoded JSON value can be stored as valueType
+func valueMatches(value any, valueType string) bool {
+	switch valueType {
+	case ValueTypeString:
+		_, ok := value.(string)
+		return ok
+	case ValueTypeNumber:
+		_, ok := value.(json.Number)
+		return ok
+	case ValueTypeBool:
+		_, ok := value.(bool)
+		return ok
+	case ValueTypeJSON:
+		return true
+	}
+	return false
+}
+
+// MergePatch applies an RFC 7386 JSON merge patch to the value of a json pair
+func (kv *KeyValue) MergePatch(patch []byte) error {
+	if kv.ValueType != ValueTypeJSON {
+		return fmt.Errorf("merge patches apply to json values, not %s", kv.ValueType)
+	}
+	target, err := kv.Decoded()
+	if err != nil {
+		return fmt.Errorf("failed to decode value: %w", err)
+	}
+	decodedPatch, err := decodeJSON(patch)
+	if err != nil {
+		return fmt.Errorf("patch must be valid JSON: %w", err)
+	}
+
+	encoded, err := json.Marshal(mergePatch(target, decodedPatch))
+	if err != nil {
+		return fmt.Errorf("failed to encode value: %w", err)
+	}
+	kv.UpdateValue(string(encoded), ValueTypeJSON)
+	return nil
+}
+
+// mergePatch implements the MergePatch function of RFC 7386
+func mergePatch(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+		} else {
+			targetObject[name] = mergePatch(targetObject[name], value)
+		}
+	}
+	return targetObject
+}
+
+// decodeJSON decodes a single JSON value, keeping numbers exact
+func decodeJSON(data []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("unexpected data after the value")
+	}
+	return value, nil
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseValue(t *testing.T) {
+	tests := []struct {
+		raw, valueType       string
+		expected, expectType string
+	}{
+		{`"hello"`, "", "hello", ValueTypeString},
+		{`"21.5"`, "", "21.5", ValueTypeString},
+		{`21.50`, "", "21.50", ValueTypeNumber},
+		{`"21.5"`, ValueTypeNumber, "21.5", ValueTypeNumber},
+		{`false`, "", "false", ValueTypeBool},
+		{`"true"`, ValueTypeBool, "true", ValueTypeBool},
+		{`{"b": 1, "a": [1, 2]}`, "", `{"a":[1,2],"b":1}`, ValueTypeJSON},
+		{`"{\"a\":1}"`, ValueTypeJSON, `{"a":1}`, ValueTypeJSON},
+		{`12`, ValueTypeJSON, "12", ValueTypeJSON},
+	}
+	for _, test := range tests {
+		value, valueType, err := ParseValue(json.RawMessage(test.raw), test.valueType)
+		if err != nil || value != test.expected || valueType != test.expectType {
+			t.Errorf("ParseValue(%s, %q) = %q, %q, %v; expected %q, %q", test.raw, test.valueType, value, valueType, err, test.expected, test.expectType)
+		}
+	}
+
+	for _, invalid := range []struct{ raw, valueType string }{
+		{`null`, ""},
+		{`{`, ""},
+		{`1 2`, ""},
+		{`"abc"`, ValueTypeNumber},
+		{`"1"`, ValueTypeBool},
+		{`1`, ValueTypeString},
+	} {
+		if _, _, err := ParseValue(json.RawMessage(invalid.raw), invalid.valueType); err == nil {
+			t.Errorf("Expected ParseValue(%s, %q) to fail", invalid.raw, invalid.valueType)
+		}
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	for _, test := range []struct {
+		kv       KeyValue
+		expected string
+	}{
+		{KeyValue{Value: "21.5", ValueType: ValueTypeString}, `"21.5"`},
+		{KeyValue{Value: "21.5", ValueType: ValueTypeNumber}, `21.5`},
+		{KeyValue{Value: `{"a":1}`, ValueType: ValueTypeJSON}, `{"a":1}`},
+	} {
+		encoded, err := json.Marshal(test.kv)
+		if err != nil {
+			t.Fatalf("Marshal() failed: %v", err)
+		}
+		var decoded map[string]json.RawMessage
+		json.Unmarshal(encoded, &decoded)
+		if string(decoded["value"]) != test.expected {
+			t.Errorf("Expected value %s, got %s", test.expected, decoded["value"])
+		}
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	// Examples of RFC 7386, appendix A
+	tests := []struct{ target, patch, expected string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, test := range tests {
+		kv := KeyValue{Value: test.target, ValueType: ValueTypeJSON}
+		if err := kv.MergePatch([]byte(test.patch)); err != nil {
+			t.Fatalf("MergePatch(%s, %s) failed: %v", test.target, test.patch, err)
+		}
+		if kv.Value != test.expected {
+			t.Errorf("MergePatch(%s, %s) = %s; expected %s", test.target, test.patch, kv.Value, test.expected)
+		}
+	}
+
+	kv := KeyValue{Value: "text", ValueType: ValueTypeString}
+	if err := kv.MergePatch([]byte(`{"a":1}`)); err == nil {
+		t.Errorf("Expected merge patches of string values to fail")
+	}
+}
+
+func TestMatchesETag(t *testing.T) {
+	kv := KeyValue{ID: 7, Version: 3}
+	if kv.ETag() != `"7.3"` {
+		t.Fatalf("Unexpected ETag %s", kv.ETag())
+	}
+	for ifMatch, expected := range map[string]bool{
+		`*`:            true,
+		`"7.3"`:        true,
+		`"7.2", "7.3"`: true,
+		`"7.2"`:        false,
+		`W/"7.3"`:      false,
+		`7.3`:          false,
+		`"8.3","7.30"`: false,
+	} {
+		if kv.MatchesETag(ifMatch) != expected {
+			t.Errorf("MatchesETag(%s) = %v; expected %v", ifMatch, !expected, expected)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// TaskChain represents a run of a task together with its dependencies and follow-up tasks.
+// Its status is failed when any run of the chain failed or was skipped.
+type TaskChain struct {
+	ID         int        `json:"id"`
+	TaskName   string     `json:"task_name"`
+	Trigger    string     `json:"trigger"`
+	Status     string     `json:"status"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Runs       []TaskRun  `json:"runs"`
+}
+
+// CreateTaskChainsTable creates the task_chains table if it doesn't exist
+func (d *Database) CreateTaskChainsTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS task_chains (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		task_name TEXT NOT NULL,
+		trigger_type TEXT NOT NULL,
+		status TEXT NOT NULL,
+		started_at TIMESTAMP NOT NULL,
+		finished_at TIMESTAMP NULL
+	)`
+
+	_, err := d.db.Exec(query)
+	if err != nil {
+		return fmt.Errorf("failed to create task_chains table: %w", err)
+	}
+
+	indexes := []string{
+		"CREATE INDEX IF NOT EXISTS idx_task_chains_task_name ON task_chains(task_name, started_at)",
+		"CREATE INDEX IF NOT EXISTS idx_task_runs_chain_id ON task_runs(chain_id)",
+	}
+	for _, index := range indexes {
+		if _, err := d.db.Exec(index); err != nil {
+			return fmt.Errorf("failed to create index: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// CreateTaskChain records the start of a task chain
+func (d *Database) CreateTaskChain(taskName, trigger string, startedAt time.Time) (*TaskChain, error) {
+	result, err := d.db.Exec(
+		"INSERT INTO task_chains (task_name, trigger_type, status, started_at) VALUES (?, ?, ?, ?)",
+		taskName, trigger, TaskRunStatusRunning, startedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create task chain: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last insert id: %w", err)
+	}
+
+	return &TaskChain{
+		ID:        int(id),
+		TaskName:  taskName,
+		Trigger:   trigger,
+		Status:    TaskRunStatusRunning,
+		StartedAt: startedAt,
+		Runs:      []TaskRun{},
+	}, nil
+}
+
+// FinishTaskChain records the outcome of a task chain
+func (d *Database) FinishTaskChain(id int, status string, finishedAt time.Time) error {
+	_, err := d.db.Exec(
+		"UPDATE task_chains SET status = ?, finished_at = ? WHERE id = ?",
+		status, finishedAt, id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to finish task chain: %w", err)
+	}
+	return nil
+}
+
+// ListTaskChains lists the chains started by a task, newest first, including their runs,
+// and returns the total number of chains
+func (d *Database) ListTaskChains(taskName string, limit, offset int) ([]TaskChain, int, error) {
+	var total int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM task_chains WHERE task_name = ?", taskName).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count task chains: %w", err)
+	}
+
+	rows, err := d.db.Query(
+		"SELECT id, task_name, trigger_type, status, started_at, finished_at FROM task_chains WHERE task_name = ? ORDER BY started_at DESC, id DESC LIMIT ? OFFSET ?",
+		taskName, limit, offset,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list task chains: %w", err)
+	}
+	defer rows.Close()
+
+	chains := []TaskChain{}
+	for rows.Next() {
+		var chain TaskChain
+		var finishedAt sql.NullTime
+		if err := rows.Scan(&chain.ID, &chain.TaskName, &chain.Trigger, &chain.Status, &chain.StartedAt, &finishedAt); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan task chain: %w", err)
+		}
+		if finishedAt.Valid {
+			chain.FinishedAt = &finishedAt.Time
+		}
+		chains = append(chains, chain)
+	}
+	rows.Close()
+
+	for i := range chains {
+		runs, err := d.ListChainTaskRuns(chains[i].ID)
+		if err != nil {
+			return nil, 0, err
+		}
+		chains[i].Runs = runs
+	}
+
+	return chains, total, nil
+}
+
+// ListChainTaskRuns lists the runs of a task chain in the order they were triggered
+func (d *Database) ListChainTaskRuns(chainID int) ([]TaskRun, error) {
+	rows, err := d.db.Query(
+		"SELECT "+taskRunColumns+" FROM task_runs WHERE chain_id = ? ORDER BY id",
+		chainID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chain task runs: %w", err)
+	}
+	defer rows.Close()
+
+	runs := []TaskRun{}
+	for rows.Next() {
+		run, err := scanTaskRun(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan task run: %w", err)
+		}
+		runs = append(runs, *run)
+	}
+
+	return runs, nil
+}
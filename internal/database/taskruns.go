@@ -0,0 +1,263 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Task run triggers
+const (
+	TriggerCron    = "cron"
+	TriggerManual  = "manual"
+	TriggerCatchUp = "catch_up" // a schedule window missed while the scheduler was down
+)
+
+// Task run statuses
+const (
+	TaskRunStatusQueued    = "queued"
+	TaskRunStatusRunning   = "running"
+	TaskRunStatusSuccess   = "success"
+	TaskRunStatusRetrying  = "retrying" // failed, another attempt follows
+	TaskRunStatusFailed    = "failed"
+	TaskRunStatusSkipped   = "skipped"
+	TaskRunStatusCancelled = "cancelled"
+)
+
+// taskRunColumns are the task_runs columns read by scanTaskRun
+const taskRunColumns = "id, task_name, trigger_type, status, attempt, chain_id, params, exit_code, http_status, output, started_at, finished_at"
+
+// TaskRunOutputLimit is the number of trailing output bytes kept per run
+const TaskRunOutputLimit = 4096
+
+// TaskRun represents a single execution of a task
+type TaskRun struct {
+	ID         int            `json:"id"`
+	TaskName   string         `json:"task_name"`
+	Trigger    string         `json:"trigger"`
+	Status     string         `json:"status"`
+	Attempt    int            `json:"attempt"`
+	ChainID    *int           `json:"chain_id,omitempty"`
+	Params     map[string]any `json:"params,omitempty"` // arguments of parameterized tasks
+	ExitCode   int            `json:"exit_code"`
+	HTTPStatus *int           `json:"http_status,omitempty"` // response status of HTTP tasks
+	Output     string         `json:"output"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+}
+
+// CreateTaskRunsTable creates the task_runs table if it doesn't exist
+func (d *Database) CreateTaskRunsTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS task_runs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		task_name TEXT NOT NULL,
+		trigger_type TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempt INTEGER NOT NULL DEFAULT 1,
+		chain_id INTEGER NULL,
+		params TEXT NULL,
+		exit_code INTEGER NOT NULL DEFAULT 0,
+		http_status INTEGER NULL,
+		output TEXT NOT NULL DEFAULT '',
+		started_at TIMESTAMP NOT NULL,
+		finished_at TIMESTAMP NULL
+	)`
+
+	_, err := d.db.Exec(query)
+	if err != nil {
+		return fmt.Errorf("failed to create task_runs table: %w", err)
+	}
+
+	_, err = d.db.Exec("CREATE INDEX IF NOT EXISTS idx_task_runs_task_name ON task_runs(task_name, started_at)")
+	if err != nil {
+		return fmt.Errorf("failed to create index: %w", err)
+	}
+
+	return nil
+}
+
+// CreateTaskRun records an attempt of a triggered task run waiting to be started.
+// chainID links the run to a task chain, 0 means none. params holds the
+// arguments of parameterized tasks and may be nil.
+func (d *Database) CreateTaskRun(taskName, trigger string, attempt, chainID int, params map[string]any, queuedAt time.Time) (*TaskRun, error) {
+	var chain sql.NullInt64
+	if chainID != 0 {
+		chain = sql.NullInt64{Int64: int64(chainID), Valid: true}
+	}
+	var encoded sql.NullString
+	if len(params) > 0 {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode task run params: %w", err)
+		}
+		encoded = sql.NullString{String: string(data), Valid: true}
+	}
+
+	result, err := d.db.Exec(
+		"INSERT INTO task_runs (task_name, trigger_type, status, attempt, chain_id, params, started_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		taskName, trigger, TaskRunStatusQueued, attempt, chain, encoded, queuedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create task run: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last insert id: %w", err)
+	}
+
+	run := &TaskRun{
+		ID:        int(id),
+		TaskName:  taskName,
+		Trigger:   trigger,
+		Status:    TaskRunStatusQueued,
+		Attempt:   attempt,
+		Params:    params,
+		StartedAt: queuedAt,
+	}
+	if chain.Valid {
+		run.ChainID = &chainID
+	}
+	return run, nil
+}
+
+// SetTaskRunStatus changes the status of a finished task run
+func (d *Database) SetTaskRunStatus(id int, status string) error {
+	_, err := d.db.Exec("UPDATE task_runs SET status = ? WHERE id = ?", status, id)
+	if err != nil {
+		return fmt.Errorf("failed to update task run status: %w", err)
+	}
+	return nil
+}
+
+// StartTaskRun marks a queued task run as running
+func (d *Database) StartTaskRun(id int, startedAt time.Time) error {
+	_, err := d.db.Exec(
+		"UPDATE task_runs SET status = ?, started_at = ? WHERE id = ?",
+		TaskRunStatusRunning, startedAt, id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to start task run: %w", err)
+	}
+	return nil
+}
+
+// FinishTaskRun records the outcome of a task run, keeping only the tail of the output
+func (d *Database) FinishTaskRun(id int, status string, exitCode int, output string, finishedAt time.Time) error {
+	if len(output) > TaskRunOutputLimit {
+		output = output[len(output)-TaskRunOutputLimit:]
+	}
+
+	_, err := d.db.Exec(
+		"UPDATE task_runs SET status = ?, exit_code = ?, output = ?, finished_at = ? WHERE id = ?",
+		status, exitCode, output, finishedAt, id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to finish task run: %w", err)
+	}
+	return nil
+}
+
+// SetTaskRunHTTPStatus records the response status of an HTTP task run
+func (d *Database) SetTaskRunHTTPStatus(id, status int) error {
+	if _, err := d.db.Exec("UPDATE task_runs SET http_status = ? WHERE id = ?", status, id); err != nil {
+		return fmt.Errorf("failed to set task run http status: %w", err)
+	}
+	return nil
+}
+
+// GetTaskRun retrieves a task run by ID, or nil if it doesn't exist
+func (d *Database) GetTaskRun(id int) (*TaskRun, error) {
+	run, err := scanTaskRun(d.db.QueryRow(
+		"SELECT "+taskRunColumns+" FROM task_runs WHERE id = ?",
+		id,
+	))
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get task run: %w", err)
+	}
+
+	return run, nil
+}
+
+// GetLastTaskRun retrieves the most recent run of a task
+func (d *Database) GetLastTaskRun(taskName string) (*TaskRun, error) {
+	run, err := scanTaskRun(d.db.QueryRow(
+		"SELECT "+taskRunColumns+" FROM task_runs WHERE task_name = ? ORDER BY started_at DESC, id DESC LIMIT 1",
+		taskName,
+	))
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get last task run: %w", err)
+	}
+
+	return run, nil
+}
+
+// ListTaskRuns lists runs of a task, newest first, and returns the total number of runs
+func (d *Database) ListTaskRuns(taskName string, limit, offset int) ([]TaskRun, int, error) {
+	var total int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM task_runs WHERE task_name = ?", taskName).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count task runs: %w", err)
+	}
+
+	rows, err := d.db.Query(
+		"SELECT "+taskRunColumns+" FROM task_runs WHERE task_name = ? ORDER BY started_at DESC, id DESC LIMIT ? OFFSET ?",
+		taskName, limit, offset,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list task runs: %w", err)
+	}
+	defer rows.Close()
+
+	runs := []TaskRun{}
+	for rows.Next() {
+		run, err := scanTaskRun(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan task run: %w", err)
+		}
+		runs = append(runs, *run)
+	}
+
+	return runs, total, nil
+}
+
+// scanTaskRun scans a task_runs row
+func scanTaskRun(row interface{ Scan(dest ...any) error }) (*TaskRun, error) {
+	var run TaskRun
+	var chainID sql.NullInt64
+	var params sql.NullString
+	var httpStatus sql.NullInt64
+	var finishedAt sql.NullTime
+
+	if err := row.Scan(&run.ID, &run.TaskName, &run.Trigger, &run.Status, &run.Attempt, &chainID, &params, &run.ExitCode, &httpStatus, &run.Output, &run.StartedAt, &finishedAt); err != nil {
+		return nil, err
+	}
+
+	if chainID.Valid {
+		id := int(chainID.Int64)
+		run.ChainID = &id
+	}
+	if params.Valid {
+		if err := json.Unmarshal([]byte(params.String), &run.Params); err != nil {
+			return nil, fmt.Errorf("failed to decode task run params: %w", err)
+		}
+	}
+	if httpStatus.Valid {
+		status := int(httpStatus.Int64)
+		run.HTTPStatus = &status
+	}
+	if finishedAt.Valid {
+		run.FinishedAt = &finishedAt.Time
+	}
+
+	return &run, nil
+}
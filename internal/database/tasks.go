@@ -0,0 +1,190 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// TaskDefinition represents a task created through the API.
+// Definition holds the task serialized as YAML, in the same format as the config file.
+type TaskDefinition struct {
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	Definition string    `json:"definition"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// CreateTasksTable creates the tasks, task_overrides and task_state tables if they don't exist
+func (d *Database) CreateTasksTable() error {
+	tables := []string{
+		`CREATE TABLE IF NOT EXISTS tasks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT UNIQUE NOT NULL,
+			definition TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS task_overrides (
+			name TEXT PRIMARY KEY,
+			enabled BOOLEAN NOT NULL,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS task_state (
+			name TEXT PRIMARY KEY,
+			last_fired_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS scheduler_state (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			paused BOOLEAN NOT NULL DEFAULT FALSE,
+			paused_at TIMESTAMP NULL
+		)`,
+	}
+
+	for _, table := range tables {
+		if _, err := d.db.Exec(table); err != nil {
+			return fmt.Errorf("failed to create tasks table: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// SaveTaskDefinition creates or replaces a task definition
+func (d *Database) SaveTaskDefinition(name, definition string) error {
+	now := time.Now()
+	_, err := d.db.Exec(
+		`INSERT INTO tasks (name, definition, created_at, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET definition = excluded.definition, updated_at = excluded.updated_at`,
+		name, definition, now, now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save task definition: %w", err)
+	}
+	return nil
+}
+
+// ListTaskDefinitions lists all task definitions ordered by name
+func (d *Database) ListTaskDefinitions() ([]TaskDefinition, error) {
+	rows, err := d.db.Query("SELECT id, name, definition, created_at, updated_at FROM tasks ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list task definitions: %w", err)
+	}
+	defer rows.Close()
+
+	var definitions []TaskDefinition
+	for rows.Next() {
+		var def TaskDefinition
+		if err := rows.Scan(&def.ID, &def.Name, &def.Definition, &def.CreatedAt, &def.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan task definition: %w", err)
+		}
+		definitions = append(definitions, def)
+	}
+
+	return definitions, nil
+}
+
+// DeleteTaskDefinition deletes a task definition by name
+func (d *Database) DeleteTaskDefinition(name string) error {
+	_, err := d.db.Exec("DELETE FROM tasks WHERE name = ?", name)
+	if err != nil {
+		return fmt.Errorf("failed to delete task definition: %w", err)
+	}
+	return nil
+}
+
+// SetTaskOverride persists the enabled flag of a config-defined task
+func (d *Database) SetTaskOverride(name string, enabled bool) error {
+	_, err := d.db.Exec(
+		`INSERT INTO task_overrides (name, enabled, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET enabled = excluded.enabled, updated_at = excluded.updated_at`,
+		name, enabled, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save task override: %w", err)
+	}
+	return nil
+}
+
+// GetTaskOverrides returns the enabled flag overrides keyed by task name
+func (d *Database) GetTaskOverrides() (map[string]bool, error) {
+	rows, err := d.db.Query("SELECT name, enabled FROM task_overrides")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list task overrides: %w", err)
+	}
+	defer rows.Close()
+
+	overrides := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		var enabled bool
+		if err := rows.Scan(&name, &enabled); err != nil {
+			return nil, fmt.Errorf("failed to scan task override: %w", err)
+		}
+		overrides[name] = enabled
+	}
+
+	return overrides, nil
+}
+
+// SetTaskLastFired records the time a task was last fired by its schedule
+func (d *Database) SetTaskLastFired(name string, firedAt time.Time) error {
+	_, err := d.db.Exec(
+		`INSERT INTO task_state (name, last_fired_at) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET last_fired_at = excluded.last_fired_at`,
+		name, firedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save task state: %w", err)
+	}
+	return nil
+}
+
+// GetTaskLastFired returns the time a task was last fired by its schedule,
+// or nil if it never was
+func (d *Database) GetTaskLastFired(name string) (*time.Time, error) {
+	var firedAt time.Time
+	err := d.db.QueryRow("SELECT last_fired_at FROM task_state WHERE name = ?", name).Scan(&firedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task state: %w", err)
+	}
+	return &firedAt, nil
+}
+
+// SetSchedulerPaused records whether scheduled task runs are paused
+func (d *Database) SetSchedulerPaused(paused bool, at time.Time) error {
+	var pausedAt sql.NullTime
+	if paused {
+		pausedAt = sql.NullTime{Time: at, Valid: true}
+	}
+	_, err := d.db.Exec(
+		`INSERT INTO scheduler_state (id, paused, paused_at) VALUES (1, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET paused = excluded.paused, paused_at = excluded.paused_at`,
+		paused, pausedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save scheduler state: %w", err)
+	}
+	return nil
+}
+
+// GetSchedulerPaused returns whether scheduled task runs are paused and since when
+func (d *Database) GetSchedulerPaused() (bool, *time.Time, error) {
+	var paused bool
+	var pausedAt sql.NullTime
+	err := d.db.QueryRow("SELECT paused, paused_at FROM scheduler_state WHERE id = 1").Scan(&paused, &pausedAt)
+	if err == sql.ErrNoRows {
+		return false, nil, nil
+	}
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to get scheduler state: %w", err)
+	}
+	if !paused || !pausedAt.Valid {
+		return paused, nil, nil
+	}
+	return true, &pausedAt.Time, nil
+}
@@ -0,0 +1,80 @@
+package scheduler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/saintbyte/home-ctrl/internal/solar"
+)
+
+// astroPattern matches schedules such as "sunset", "sunset-30m" or "sunrise+1h15m"
+var astroPattern = regexp.MustCompile(`^([a-z]+)\s*(?:([+-])\s*([0-9][0-9hms.]*))?$`)
+
+// astroSchedule fires at a solar event plus an offset. The event time is
+// calculated for every day anew, so the schedule follows the seasons.
+type astroSchedule struct {
+	event     solar.Event
+	offset    time.Duration
+	latitude  float64
+	longitude float64
+	location  *time.Location
+}
+
+// astroEventPattern matches the leading word of a schedule
+var astroEventPattern = regexp.MustCompile(`^[a-z]+`)
+
+// isAstroSchedule returns true if the schedule starts with a solar event name
+func isAstroSchedule(spec string) bool {
+	name := astroEventPattern.FindString(strings.ToLower(strings.TrimSpace(spec)))
+	_, err := solar.ParseEvent(name)
+	return err == nil
+}
+
+// parseAstroSchedule parses a solar event schedule such as "sunset-30m"
+func parseAstroSchedule(spec string) (solar.Event, time.Duration, error) {
+	m := astroPattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(spec)))
+	if m == nil {
+		return "", 0, fmt.Errorf("expected <event>[+-<duration>], e.g. sunset-30m")
+	}
+	event, err := solar.ParseEvent(m[1])
+	if err != nil {
+		return "", 0, err
+	}
+
+	var offset time.Duration
+	if m[2] != "" {
+		offset, err = time.ParseDuration(m[3])
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid offset: %w", err)
+		}
+		if offset >= 24*time.Hour {
+			return "", 0, fmt.Errorf("offset must be less than 24h")
+		}
+		if m[2] == "-" {
+			offset = -offset
+		}
+	}
+	return event, offset, nil
+}
+
+// Next returns the first event time plus offset after t
+func (a *astroSchedule) Next(t time.Time) time.Time {
+	local := t.In(a.location)
+	year, month, day := local.Date()
+
+	// Start a day early since the offset can move an event into the next day.
+	// Near the poles an event may not occur for months.
+	for i := -1; i <= 366; i++ {
+		date := time.Date(year, month, day+i, 12, 0, 0, 0, a.location)
+		at, ok := solar.Calculate(date, a.latitude, a.longitude).Get(a.event)
+		if !ok {
+			continue
+		}
+		if at = at.Add(a.offset); at.After(t) {
+			return at
+		}
+	}
+	return time.Time{}
+}
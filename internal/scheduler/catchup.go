@@ -0,0 +1,129 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/saintbyte/home-ctrl/internal/config"
+	"github.com/saintbyte/home-ctrl/internal/database"
+)
+
+// Catch-up policies for schedule windows missed while the scheduler was down
+const (
+	CatchUpNone = "none" // skip missed runs
+	CatchUpOnce = "once" // run the task once if any run was missed
+	CatchUpAll  = "all"  // run the task once for every missed run
+)
+
+// defaultCatchUpLookback limits how far back missed runs are looked for
+const defaultCatchUpLookback = 24 * time.Hour
+
+// maxCatchUpRuns limits the number of runs the "all" policy catches up
+const maxCatchUpRuns = 100
+
+// missedRuns returns the fire times of a schedule after last and up to now,
+// ignoring the ones older than the lookback
+func missedRuns(schedule cron.Schedule, last, now time.Time, lookback time.Duration) []time.Time {
+	if lookback <= 0 {
+		lookback = defaultCatchUpLookback
+	}
+	if from := now.Add(-lookback); last.Before(from) {
+		last = from
+	}
+
+	var missed []time.Time
+	for next := schedule.Next(last); !next.IsZero() && !next.After(now); next = schedule.Next(next) {
+		missed = append(missed, next)
+		if len(missed) == maxCatchUpRuns {
+			break
+		}
+	}
+	return missed
+}
+
+// recordFired persists the time of the last successful scheduled run of a
+// task, or of a schedule window skipped on purpose
+func (s *Scheduler) recordFired(name string, firedAt time.Time) {
+	if s.db == nil {
+		return
+	}
+	if err := s.db.SetTaskLastFired(name, firedAt); err != nil {
+		fmt.Printf("Failed to record fire time of task %s: %v\n", name, err)
+	}
+}
+
+// catchUp runs the scheduled tasks that missed schedule windows since they
+// were last fired, according to their catch-up policy
+func (s *Scheduler) catchUp(tasks []config.Task, now time.Time) {
+	if s.db == nil {
+		return
+	}
+
+	if reason := s.suppressed(now); reason != "" {
+		// Missed runs are not made up for while runs are suppressed
+		fmt.Printf("Not catching up missed runs: %s\n", reason)
+		for _, task := range tasks {
+			s.recordFired(task.Name, now)
+		}
+		return
+	}
+
+	for _, task := range tasks {
+		last, err := s.db.GetTaskLastFired(task.Name)
+		if err != nil {
+			fmt.Printf("Failed to check missed runs of task %s: %v\n", task.Name, err)
+			continue
+		}
+		if last == nil {
+			// Never fired before, nothing can have been missed
+			s.recordFired(task.Name, now)
+			continue
+		}
+		if task.CatchUp == "" || task.CatchUp == CatchUpNone {
+			continue
+		}
+
+		schedule, err := s.taskSchedule(task)
+		if err != nil {
+			continue
+		}
+		missed := s.outsideMaintenance(missedRuns(schedule, *last, now, task.CatchUpLookback))
+		if len(missed) == 0 {
+			continue
+		}
+
+		count := len(missed)
+		if task.CatchUp == CatchUpOnce {
+			count = 1
+		}
+		fmt.Printf("Task %s missed %d run(s) since %s, catching up %d\n",
+			task.Name, len(missed), last.Format(time.RFC3339), count)
+
+		go func(task config.Task, count int) {
+			for i := 0; i < count; i++ {
+				_, job, err := s.prepare(task, database.TriggerCatchUp, nil)
+				if errors.Is(err, ErrSchedulerStopped) {
+					return
+				}
+				if err != nil {
+					fmt.Printf("Skipping catch-up run of task %s: %v\n", task.Name, err)
+					continue
+				}
+				job()
+			}
+		}(task, count)
+	}
+}
+
+// outsideMaintenance drops the times that fall into a maintenance window
+func (s *Scheduler) outsideMaintenance(times []time.Time) []time.Time {
+	var kept []time.Time
+	for _, t := range times {
+		if _, ok := s.inMaintenance(t); !ok {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
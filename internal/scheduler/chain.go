@@ -0,0 +1,148 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/saintbyte/home-ctrl/internal/config"
+	"github.com/saintbyte/home-ctrl/internal/database"
+)
+
+// chainRun tracks the tasks executed as part of one chain. Tasks run one
+// after another and each task runs at most once per chain.
+type chainRun struct {
+	sched     *Scheduler
+	ctx       context.Context // context of the triggered task, cancelling it cancels the chain
+	trigger   string
+	id        int
+	done      map[string]bool // task name -> succeeded
+	failed    bool
+	cancelled bool
+}
+
+// createChain records the start of a task chain
+func (s *Scheduler) createChain(task config.Task, trigger string) *database.TaskChain {
+	if s.db == nil {
+		return nil
+	}
+
+	chain, err := s.db.CreateTaskChain(task.Name, trigger, time.Now())
+	if err != nil {
+		fmt.Printf("Failed to record chain of task %s: %v\n", task.Name, err)
+		return nil
+	}
+	return chain
+}
+
+// runChain runs a triggered task with its dependencies and follow-up tasks
+// and records the outcome of the whole chain. It returns true if the task
+// itself succeeded.
+func (s *Scheduler) runChain(task config.Task, trigger string, chain *database.TaskChain, run *database.TaskRun, instance *Instance, queue chan struct{}) bool {
+	c := &chainRun{
+		sched:   s,
+		ctx:     instance.ctx,
+		trigger: trigger,
+		done:    map[string]bool{task.Name: false},
+	}
+	if chain != nil {
+		c.id = chain.ID
+	}
+
+	fmt.Printf("Starting chain of task %s\n", task.Name)
+	ok := c.run(task, run, instance, queue)
+
+	status := database.TaskRunStatusSuccess
+	switch {
+	case c.cancelled:
+		status = database.TaskRunStatusCancelled
+	case c.failed:
+		status = database.TaskRunStatusFailed
+	}
+	fmt.Printf("Chain of task %s finished: %s\n", task.Name, status)
+
+	if chain != nil {
+		if err := s.db.FinishTaskChain(chain.ID, status, time.Now()); err != nil {
+			fmt.Printf("Failed to record result of chain %d: %v\n", chain.ID, err)
+		}
+	}
+	return ok
+}
+
+// run executes the dependencies of a task, the task itself and then the
+// follow-up tasks matching its outcome. It returns true if the task succeeded.
+func (c *chainRun) run(task config.Task, run *database.TaskRun, instance *Instance, queue chan struct{}) bool {
+	defer c.sched.removeInstance(task.Name, instance)
+
+	for _, dep := range task.DependsOn {
+		if !c.step(dep) {
+			if instance.ctx.Err() != nil {
+				c.sched.cancelRun(task, run)
+				c.cancelled = true
+			} else {
+				c.sched.skipRun(task, run, fmt.Sprintf("dependency %s failed", dep))
+			}
+			c.done[task.Name] = false
+			c.failed = true
+			return false
+		}
+	}
+
+	ok := c.sched.runInstance(task, c.trigger, c.id, run, instance, queue) == nil
+	c.done[task.Name] = ok
+	if !ok {
+		c.failed = true
+	}
+	if instance.ctx.Err() != nil {
+		// A cancelled task doesn't trigger its follow-ups
+		c.cancelled = true
+		return false
+	}
+
+	next := task.OnSuccess
+	if !ok {
+		next = task.OnFailureRun
+	}
+	for _, name := range next {
+		c.step(name)
+	}
+	return ok
+}
+
+// step runs a linked task unless it already ran in this chain
+func (c *chainRun) step(name string) bool {
+	if ok, seen := c.done[name]; seen {
+		return ok
+	}
+	// Mark the task before running it so a cycle can't recurse forever
+	c.done[name] = false
+
+	info, err := c.sched.GetTask(name)
+	if err != nil {
+		fmt.Printf("Chain step failed: %v\n", err)
+		c.failed = true
+		return false
+	}
+
+	task, params, err := c.sched.parameterize(info.Task, nil)
+	if err != nil {
+		c.sched.recordSkipped(info.Task, c.trigger, c.id, err.Error())
+		c.failed = true
+		return false
+	}
+
+	instance, queue, err := c.sched.admit(c.ctx, task, c.trigger)
+	if err != nil {
+		if errors.Is(err, ErrTaskRunning) {
+			c.sched.recordSkipped(task, c.trigger, c.id, "previous run still in progress")
+		}
+		c.failed = true
+		return false
+	}
+
+	instance.params = params
+	run := c.sched.createRun(task, c.trigger, 1, c.id, params)
+	c.sched.setInstanceRun(instance, run)
+	return c.run(task, run, instance, queue)
+}
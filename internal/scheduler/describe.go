@@ -0,0 +1,269 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var monthNames = []string{"", "January", "February", "March", "April", "May", "June",
+	"July", "August", "September", "October", "November", "December"}
+
+var weekdayNames = []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+
+// cronField describes one field of a cron expression
+type cronField struct {
+	unit  string
+	names []string // display names indexed by value, if any
+}
+
+var (
+	secondField  = cronField{unit: "second"}
+	minuteField  = cronField{unit: "minute"}
+	hourField    = cronField{unit: "hour"}
+	dayField     = cronField{unit: "day"}
+	monthField   = cronField{unit: "month", names: monthNames}
+	weekdayField = cronField{unit: "weekday", names: weekdayNames}
+)
+
+// DescribeSchedule returns a human-readable description of a schedule
+// expression, e.g. "At 03:30 on Monday through Friday". The expression is
+// expected to be valid.
+func DescribeSchedule(spec string) string {
+	spec = strings.TrimSpace(spec)
+
+	var zone string
+	if hasTimezonePrefix(spec) {
+		prefix, rest, _ := strings.Cut(spec, " ")
+		_, zone, _ = strings.Cut(prefix, "=")
+		spec = strings.TrimSpace(rest)
+	}
+
+	description := describeSpec(spec)
+	if zone != "" {
+		description += " (" + zone + ")"
+	}
+	return description
+}
+
+func describeSpec(spec string) string {
+	if isAstroSchedule(spec) {
+		event, offset, err := parseAstroSchedule(spec)
+		if err != nil {
+			return spec
+		}
+		switch {
+		case offset > 0:
+			return fmt.Sprintf("Every day %s after %s", formatDuration(offset), event)
+		case offset < 0:
+			return fmt.Sprintf("Every day %s before %s", formatDuration(-offset), event)
+		}
+		return fmt.Sprintf("Every day at %s", event)
+	}
+
+	if strings.HasPrefix(spec, "@") {
+		return describeDescriptor(spec)
+	}
+
+	fields := strings.Fields(spec)
+	second := "0"
+	if len(fields) == 6 {
+		second, fields = fields[0], fields[1:]
+	}
+	if len(fields) != 5 {
+		return spec
+	}
+	minute, hour, dom, month, dow := fields[0], fields[1], fields[2], fields[3], fields[4]
+
+	parts := []string{describeTime(second, minute, hour)}
+	if !isWildcard(dom) {
+		parts = append(parts, "on "+describeField(dom, dayField)+" of the month")
+	}
+	if !isWildcard(dow) {
+		parts = append(parts, "on "+describeField(dow, weekdayField))
+	}
+	if !isWildcard(month) {
+		parts = append(parts, "in "+describeField(month, monthField))
+	}
+	return strings.Join(parts, " ")
+}
+
+func describeDescriptor(spec string) string {
+	switch spec {
+	case "@yearly", "@annually":
+		return "At 00:00 on January 1"
+	case "@monthly":
+		return "At 00:00 on day 1 of the month"
+	case "@weekly":
+		return "At 00:00 on Sunday"
+	case "@daily", "@midnight":
+		return "At 00:00"
+	case "@hourly":
+		return "Every hour"
+	}
+	if interval, ok := strings.CutPrefix(spec, "@every "); ok {
+		if d, err := time.ParseDuration(strings.TrimSpace(interval)); err == nil {
+			return "Every " + formatDuration(d)
+		}
+	}
+	return spec
+}
+
+// describeTime describes the time of day fields
+func describeTime(second, minute, hour string) string {
+	if isNumber(second) && isNumber(minute) && isNumberList(hour) {
+		times := []string{}
+		for _, h := range strings.Split(hour, ",") {
+			times = append(times, clockTime(h, minute, second))
+		}
+		return "At " + joinList(times)
+	}
+	if second == "0" && isNumber(minute) && (isWildcard(hour) || strings.Contains(hour, "/")) {
+		description := "Every hour"
+		if !isWildcard(hour) {
+			description = describeField(hour, hourField)
+			description = strings.ToUpper(description[:1]) + description[1:]
+		}
+		if minute != "0" {
+			description += " at minute " + minute
+		}
+		return description
+	}
+
+	var parts []string
+	switch {
+	case second == "0":
+	case isWildcard(second):
+		parts = append(parts, "every second")
+	default:
+		parts = append(parts, describeField(second, secondField))
+	}
+
+	switch {
+	case isWildcard(minute):
+		if len(parts) == 0 {
+			parts = append(parts, "every minute")
+		}
+	case strings.HasPrefix(minute, "*/"):
+		parts = append(parts, describeField(minute, minuteField))
+	default:
+		parts = append(parts, describeField(minute, minuteField)+" past the hour")
+	}
+
+	if !isWildcard(hour) {
+		if strings.Contains(hour, "/") {
+			parts = append(parts, describeField(hour, hourField))
+		} else {
+			parts = append(parts, "during "+describeField(hour, hourField))
+		}
+	}
+
+	description := strings.Join(parts, ", ")
+	return strings.ToUpper(description[:1]) + description[1:]
+}
+
+// describeField describes a single cron field such as "*/5", "1-5" or "1,15"
+func describeField(value string, field cronField) string {
+	if base, step, ok := strings.Cut(value, "/"); ok {
+		description := fmt.Sprintf("every %s %ss", step, field.unit)
+		if step == "1" {
+			description = "every " + field.unit
+		}
+		if !isWildcard(base) {
+			if from, to, ok := strings.Cut(base, "-"); ok {
+				description += fmt.Sprintf(" from %s through %s", field.name(from), field.name(to))
+			} else {
+				description += " starting at " + field.name(base)
+			}
+		}
+		return description
+	}
+
+	items := strings.Split(value, ",")
+	for i, item := range items {
+		if from, to, ok := strings.Cut(item, "-"); ok {
+			items[i] = field.name(from) + " through " + field.name(to)
+		} else {
+			items[i] = field.name(item)
+		}
+	}
+	if field.names != nil {
+		return joinList(items)
+	}
+
+	unit := field.unit
+	if len(items) > 1 || strings.Contains(value, "-") {
+		unit += "s"
+	}
+	return unit + " " + joinList(items)
+}
+
+// name returns the display name of a field value
+func (f cronField) name(value string) string {
+	if f.names == nil {
+		return value
+	}
+	if n, err := strconv.Atoi(value); err == nil {
+		if f.unit == "weekday" && n == 7 {
+			n = 0
+		}
+		if n >= 0 && n < len(f.names) && f.names[n] != "" {
+			return f.names[n]
+		}
+	}
+	// Named values such as MON or JAN
+	for _, name := range f.names {
+		if len(name) >= 3 && strings.EqualFold(name[:3], value) {
+			return name
+		}
+	}
+	return value
+}
+
+func clockTime(hour, minute, second string) string {
+	h, _ := strconv.Atoi(hour)
+	m, _ := strconv.Atoi(minute)
+	s, _ := strconv.Atoi(second)
+	if s != 0 {
+		return fmt.Sprintf("%02d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%02d:%02d", h, m)
+}
+
+// formatDuration formats a duration without zero components, e.g. "1h30m"
+func formatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+func joinList(items []string) string {
+	if len(items) <= 1 {
+		return strings.Join(items, "")
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
+
+func isWildcard(value string) bool {
+	return value == "*" || value == "?"
+}
+
+func isNumber(value string) bool {
+	_, err := strconv.Atoi(value)
+	return err == nil
+}
+
+func isNumberList(value string) bool {
+	for _, item := range strings.Split(value, ",") {
+		if !isNumber(item) {
+			return false
+		}
+	}
+	return true
+}
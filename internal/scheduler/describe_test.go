@@ -0,0 +1,35 @@
+package scheduler
+
+import "testing"
+
+func TestDescribeSchedule(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected string
+	}{
+		{"0 3 * * *", "At 03:00"},
+		{"30 9,18 * * 1-5", "At 09:30 and 18:30 on Monday through Friday"},
+		{"*/5 * * * *", "Every 5 minutes"},
+		{"* * * * *", "Every minute"},
+		{"0 * * * *", "Every hour"},
+		{"15 */2 * * *", "Every 2 hours at minute 15"},
+		{"15 9-17 * * *", "Minute 15 past the hour, during hours 9 through 17"},
+		{"0 0 1,15 * *", "At 00:00 on days 1 and 15 of the month"},
+		{"0 12 * JAN,JUL SUN", "At 12:00 on Sunday in January and July"},
+		{"*/10 * * * * *", "Every 10 seconds"},
+		{"30 0 6 * * *", "At 06:00:30"},
+		{"@every 1h30m", "Every 1h30m"},
+		{"@daily", "At 00:00"},
+		{"sunset-30m", "Every day 30m before sunset"},
+		{"sunrise", "Every day at sunrise"},
+		{"CRON_TZ=Europe/Moscow 0 7 * * *", "At 07:00 (Europe/Moscow)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			if got := DescribeSchedule(tt.spec); got != tt.expected {
+				t.Errorf("DescribeSchedule(%q) = %q, want %q", tt.spec, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package scheduler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/saintbyte/home-ctrl/internal/config"
+)
+
+// schedulerLease is the name of the lease held by the instance that runs
+// scheduled tasks when several instances share a database
+const schedulerLease = "scheduler"
+
+// defaultLeaseTTL is how long the scheduler lease lasts without renewal when none is configured
+const defaultLeaseTTL = 30 * time.Second
+
+// LeaderStatus tells which instance runs the scheduled tasks
+type LeaderStatus struct {
+	Leader         bool       `json:"leader"`                     // this instance runs scheduled tasks
+	Instance       string     `json:"instance"`                   // ID of this instance
+	LeaderInstance string     `json:"leader_instance,omitempty"`  // ID of the instance holding the lease
+	LeaseExpiresAt *time.Time `json:"lease_expires_at,omitempty"` // when the lease expires unless renewed
+}
+
+// newInstanceID returns an ID identifying this process as lease holder
+func newInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+func (s *Scheduler) leaseTTL() time.Duration {
+	if s.config.Load().Scheduler.LeaseTTL > 0 {
+		return s.config.Load().Scheduler.LeaseTTL
+	}
+	return defaultLeaseTTL
+}
+
+// isLeader returns true if this instance may run scheduled tasks. Without a
+// database there is no one to share the schedule with.
+func (s *Scheduler) isLeader() bool {
+	if s.db == nil {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Now().Before(s.leaderUntil)
+}
+
+// campaign acquires or renews the scheduler lease and returns true if this
+// instance just became the leader. When renewing fails, leadership ends once
+// the lease held so far expires, which is when other instances may take over.
+func (s *Scheduler) campaign(now time.Time) bool {
+	ttl := s.leaseTTL()
+	acquired, err := s.db.AcquireLease(schedulerLease, s.instanceID, ttl, now)
+	if err != nil {
+		fmt.Printf("Failed to renew scheduler lease: %v\n", err)
+		return false
+	}
+
+	s.mu.Lock()
+	wasLeader := now.Before(s.leaderUntil)
+	if acquired {
+		s.leaderUntil = now.Add(ttl)
+	} else {
+		s.leaderUntil = time.Time{}
+	}
+	s.mu.Unlock()
+
+	switch {
+	case acquired && !wasLeader:
+		fmt.Printf("Instance %s is now the scheduler leader\n", s.instanceID)
+		return true
+	case !acquired && wasLeader:
+		fmt.Printf("Instance %s lost the scheduler lease\n", s.instanceID)
+	}
+	return false
+}
+
+// lead renews the scheduler lease until the scheduler stops and catches up
+// missed runs whenever this instance takes over. The renewal interval
+// follows the lease TTL of the current configuration.
+func (s *Scheduler) lead() {
+	defer close(s.leading)
+
+	interval := s.leaseTTL() / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case now := <-ticker.C:
+			if s.campaign(now) {
+				s.catchUp(s.scheduledTasks(), now)
+			}
+			if ttl := s.leaseTTL(); ttl/3 != interval {
+				interval = ttl / 3
+				ticker.Reset(interval)
+			}
+		}
+	}
+}
+
+// resign waits for the lease renewal to end and releases the lease so that
+// another instance can take over right away
+func (s *Scheduler) resign() {
+	if s.leading == nil {
+		return
+	}
+	<-s.leading
+
+	if err := s.db.ReleaseLease(schedulerLease, s.instanceID); err != nil {
+		fmt.Printf("Failed to release scheduler lease: %v\n", err)
+	}
+	s.mu.Lock()
+	s.leaderUntil = time.Time{}
+	s.mu.Unlock()
+}
+
+// scheduledTasks returns the enabled tasks that have a schedule
+func (s *Scheduler) scheduledTasks() []config.Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var scheduled []config.Task
+	for _, info := range s.tasks {
+		if info.Enabled && info.Schedule != "" {
+			scheduled = append(scheduled, info.Task)
+		}
+	}
+	return scheduled
+}
+
+// LeaderStatus reports whether this instance runs the scheduled tasks and
+// which instance holds the scheduler lease
+func (s *Scheduler) LeaderStatus() LeaderStatus {
+	status := LeaderStatus{Leader: s.isLeader(), Instance: s.instanceID}
+	if s.db == nil {
+		status.LeaderInstance = s.instanceID
+		return status
+	}
+
+	lease, err := s.db.GetLease(schedulerLease)
+	if err != nil {
+		fmt.Printf("Failed to read scheduler lease: %v\n", err)
+		return status
+	}
+	if lease != nil && lease.ExpiresAt.After(time.Now()) {
+		status.LeaderInstance = lease.Holder
+		status.LeaseExpiresAt = &lease.ExpiresAt
+	}
+	return status
+}
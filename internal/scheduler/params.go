@@ -0,0 +1,238 @@
+package scheduler
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/saintbyte/home-ctrl/internal/config"
+)
+
+// Task parameter types
+const (
+	ParamString = "string"
+	ParamInt    = "int"
+	ParamNumber = "number"
+	ParamBool   = "bool"
+)
+
+var paramNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// paramType returns the type of a parameter, defaulting to string
+func paramType(param config.TaskParam) string {
+	if param.Type == "" {
+		return ParamString
+	}
+	return param.Type
+}
+
+// coerceParam checks that a value matches the type of a parameter and
+// converts it to string, int64, float64 or bool
+func coerceParam(param config.TaskParam, value any) (any, error) {
+	switch paramType(param) {
+	case ParamString:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case ParamInt:
+		switch v := value.(type) {
+		case int:
+			return int64(v), nil
+		case int64:
+			return v, nil
+		case uint64:
+			if v <= math.MaxInt64 {
+				return int64(v), nil
+			}
+		case float64:
+			if v == math.Trunc(v) && math.Abs(v) <= 1<<53 {
+				return int64(v), nil
+			}
+		}
+	case ParamNumber:
+		switch v := value.(type) {
+		case int:
+			return float64(v), nil
+		case int64:
+			return float64(v), nil
+		case uint64:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		}
+	case ParamBool:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	default:
+		return nil, fmt.Errorf("parameter %s has unknown type %q", param.Name, param.Type)
+	}
+	return nil, fmt.Errorf("parameter %s must be of type %s, got %v", param.Name, paramType(param), value)
+}
+
+// validateParams checks the parameter declarations and command templates of a task
+func validateParams(task config.Task) error {
+	seen := make(map[string]bool, len(task.Params))
+	for _, param := range task.Params {
+		if !paramNamePattern.MatchString(param.Name) {
+			return fmt.Errorf("invalid parameter name %q", param.Name)
+		}
+		if seen[param.Name] {
+			return fmt.Errorf("duplicate parameter %s", param.Name)
+		}
+		seen[param.Name] = true
+
+		switch paramType(param) {
+		case ParamString, ParamInt, ParamNumber, ParamBool:
+		default:
+			return fmt.Errorf("parameter %s has unknown type %q", param.Name, param.Type)
+		}
+		if param.Default != nil {
+			if _, err := coerceParam(param, param.Default); err != nil {
+				return fmt.Errorf("invalid default: %w", err)
+			}
+		}
+		if param.Required && param.Default == nil && task.Schedule != "" {
+			return fmt.Errorf("scheduled task requires a default for parameter %s", param.Name)
+		}
+	}
+
+	if len(task.Params) > 0 {
+		for _, text := range templates(task) {
+			if _, err := template.New(task.Name).Parse(text); err != nil {
+				return fmt.Errorf("invalid template %q: %w", text, err)
+			}
+		}
+	}
+	return nil
+}
+
+// zeroParam returns the zero value of a parameter type
+func zeroParam(param config.TaskParam) any {
+	switch paramType(param) {
+	case ParamInt:
+		return int64(0)
+	case ParamNumber:
+		return float64(0)
+	case ParamBool:
+		return false
+	}
+	return ""
+}
+
+// resolveParams validates the arguments of a run against the parameters of a
+// task and fills in defaults. Optional parameters without default get the
+// zero value of their type.
+func resolveParams(task config.Task, args map[string]any) (map[string]any, error) {
+	declared := make(map[string]bool, len(task.Params))
+	for _, param := range task.Params {
+		declared[param.Name] = true
+	}
+	var unknown []string
+	for name := range args {
+		if !declared[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("%w: unknown parameter(s) %s", ErrInvalidArgs, strings.Join(unknown, ", "))
+	}
+
+	params := make(map[string]any, len(task.Params))
+	for _, param := range task.Params {
+		value, ok := args[param.Name]
+		if !ok || value == nil {
+			switch {
+			case param.Default != nil:
+				value = param.Default
+			case param.Required:
+				return nil, fmt.Errorf("%w: parameter %s is required", ErrInvalidArgs, param.Name)
+			default:
+				value = zeroParam(param)
+			}
+		}
+
+		coerced, err := coerceParam(param, value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidArgs, err)
+		}
+		params[param.Name] = coerced
+	}
+	return params, nil
+}
+
+// renderTask substitutes the parameters into the command templates of a task
+func renderTask(task config.Task, params map[string]any) (config.Task, error) {
+	if len(task.Params) == 0 {
+		return task, nil
+	}
+
+	render := func(text string) (string, error) {
+		tmpl, err := template.New(task.Name).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidArgs, err)
+		}
+		var out strings.Builder
+		if err := tmpl.Execute(&out, params); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidArgs, err)
+		}
+		return out.String(), nil
+	}
+
+	var err error
+	if task.WorkDir, err = render(task.WorkDir); err != nil {
+		return task, err
+	}
+	args := make([]string, len(task.Args))
+	for i, arg := range task.Args {
+		if args[i], err = render(arg); err != nil {
+			return task, err
+		}
+	}
+	task.Args = args
+	env := make(map[string]string, len(task.Env))
+	for key, value := range task.Env {
+		if env[key], err = render(value); err != nil {
+			return task, err
+		}
+	}
+	task.Env = env
+
+	if task.HTTP != nil {
+		request := *task.HTTP
+		if request.URL, err = render(request.URL); err != nil {
+			return task, err
+		}
+		if request.Body, err = render(request.Body); err != nil {
+			return task, err
+		}
+		headers := make(map[string]string, len(request.Headers))
+		for key, value := range request.Headers {
+			if headers[key], err = render(value); err != nil {
+				return task, err
+			}
+		}
+		request.Headers = headers
+		task.HTTP = &request
+	}
+	return task, nil
+}
+
+// templates returns the fields of a task that are command templates
+func templates(task config.Task) []string {
+	texts := append([]string{task.WorkDir}, task.Args...)
+	for _, value := range task.Env {
+		texts = append(texts, value)
+	}
+	if task.HTTP != nil {
+		texts = append(texts, task.HTTP.URL, task.HTTP.Body)
+		for _, value := range task.HTTP.Headers {
+			texts = append(texts, value)
+		}
+	}
+	return texts
+}
@@ -0,0 +1,207 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/saintbyte/home-ctrl/internal/config"
+)
+
+// Reasons recorded for scheduled runs that were skipped
+const (
+	skippedPaused      = "skipped (paused)"
+	skippedMaintenance = "skipped (maintenance)"
+)
+
+// maintenanceWindow is a configured maintenance window with its parsed schedule
+type maintenanceWindow struct {
+	config.MaintenanceWindow
+	schedule cron.Schedule
+}
+
+// current returns the start of the window containing t, if any
+func (w maintenanceWindow) current(t time.Time) (time.Time, bool) {
+	start := w.schedule.Next(t.Add(-w.Duration))
+	return start, !start.IsZero() && !start.After(t)
+}
+
+// MaintenanceStatus describes a maintenance window and its current or next occurrence
+type MaintenanceStatus struct {
+	Name     string     `json:"name"`
+	Schedule string     `json:"schedule"`
+	Duration string     `json:"duration"`
+	Active   bool       `json:"active"`
+	Start    *time.Time `json:"start,omitempty"`
+	End      *time.Time `json:"end,omitempty"`
+}
+
+// Status describes the global state of the scheduler
+type Status struct {
+	Paused      bool                `json:"paused"`
+	PausedAt    *time.Time          `json:"paused_at,omitempty"`
+	Maintenance []MaintenanceStatus `json:"maintenance"`
+	Leader      LeaderStatus        `json:"leader"`
+}
+
+// loadMaintenance parses the configured maintenance windows and restores the
+// persisted paused state
+func (s *Scheduler) loadMaintenance() {
+	s.maintenance = s.parseMaintenance(s.config.Load().Scheduler.Maintenance)
+
+	if s.db != nil {
+		paused, pausedAt, err := s.db.GetSchedulerPaused()
+		if err != nil {
+			fmt.Printf("Failed to load scheduler state: %v\n", err)
+			return
+		}
+		s.paused, s.pausedAt = paused, pausedAt
+		if paused {
+			fmt.Println("Scheduled task runs are paused")
+		}
+	}
+}
+
+// parseMaintenance parses maintenance windows, leaving out invalid ones
+func (s *Scheduler) parseMaintenance(windows []config.MaintenanceWindow) []maintenanceWindow {
+	var parsed []maintenanceWindow
+	for _, window := range windows {
+		schedule, err := s.maintenanceSchedule(window)
+		if err != nil {
+			fmt.Printf("Ignoring maintenance window %s: %v\n", window.Name, err)
+			continue
+		}
+		parsed = append(parsed, maintenanceWindow{MaintenanceWindow: window, schedule: schedule})
+	}
+	return parsed
+}
+
+func (s *Scheduler) maintenanceSchedule(window config.MaintenanceWindow) (cron.Schedule, error) {
+	if strings.HasPrefix(strings.TrimSpace(window.Schedule), "@every") {
+		return nil, fmt.Errorf("interval schedules have no fixed start")
+	}
+	loc, err := s.location(config.Task{Timezone: window.Timezone})
+	if err != nil {
+		return nil, err
+	}
+	schedule, err := s.parseSchedule(window.Schedule, loc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", window.Schedule, err)
+	}
+	return schedule, nil
+}
+
+// Pause stops scheduled task runs until Resume is called. The state is
+// persisted and shared by all instances using the same database. Manual
+// runs are still possible.
+func (s *Scheduler) Pause() error {
+	return s.setPaused(true)
+}
+
+// Resume lets scheduled task runs happen again
+func (s *Scheduler) Resume() error {
+	return s.setPaused(false)
+}
+
+func (s *Scheduler) setPaused(paused bool) error {
+	now := time.Now()
+	if s.db != nil {
+		if err := s.db.SetSchedulerPaused(paused, now); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	s.paused = paused
+	s.pausedAt = nil
+	if paused {
+		s.pausedAt = &now
+	}
+	s.mu.Unlock()
+
+	if paused {
+		fmt.Println("Scheduled task runs paused")
+	} else {
+		fmt.Println("Scheduled task runs resumed")
+	}
+	return nil
+}
+
+// isPaused returns whether scheduled runs are paused and since when. The
+// database is authoritative, as another instance may have changed the state.
+func (s *Scheduler) isPaused() (bool, *time.Time) {
+	if s.db != nil {
+		paused, pausedAt, err := s.db.GetSchedulerPaused()
+		if err == nil {
+			s.mu.Lock()
+			s.paused, s.pausedAt = paused, pausedAt
+			s.mu.Unlock()
+			return paused, pausedAt
+		}
+		fmt.Printf("Failed to load scheduler state: %v\n", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused, s.pausedAt
+}
+
+// maintenanceWindows returns the current maintenance windows, which Reload may replace
+func (s *Scheduler) maintenanceWindows() []maintenanceWindow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maintenance
+}
+
+// inMaintenance returns the maintenance window containing t, if any
+func (s *Scheduler) inMaintenance(t time.Time) (string, bool) {
+	for _, window := range s.maintenanceWindows() {
+		if _, ok := window.current(t); ok {
+			return window.Name, true
+		}
+	}
+	return "", false
+}
+
+// suppressed returns why scheduled runs at t must be skipped, or ""
+func (s *Scheduler) suppressed(t time.Time) string {
+	if paused, _ := s.isPaused(); paused {
+		return skippedPaused
+	}
+	if _, ok := s.inMaintenance(t); ok {
+		return skippedMaintenance
+	}
+	return ""
+}
+
+// Status reports whether scheduled runs are paused, the maintenance windows
+// and which instance runs the scheduled tasks
+func (s *Scheduler) Status() Status {
+	now := time.Now()
+	paused, pausedAt := s.isPaused()
+	status := Status{
+		Paused:      paused,
+		PausedAt:    pausedAt,
+		Maintenance: []MaintenanceStatus{},
+		Leader:      s.LeaderStatus(),
+	}
+
+	for _, window := range s.maintenanceWindows() {
+		info := MaintenanceStatus{
+			Name:     window.Name,
+			Schedule: window.Schedule,
+			Duration: window.Duration.String(),
+		}
+		start, active := window.current(now)
+		if !active {
+			start = window.schedule.Next(now)
+		}
+		if !start.IsZero() {
+			end := start.Add(window.Duration)
+			info.Active, info.Start, info.End = active, &start, &end
+		}
+		status.Maintenance = append(status.Maintenance, info)
+	}
+	return status
+}
@@ -0,0 +1,144 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/saintbyte/home-ctrl/internal/command"
+	"github.com/saintbyte/home-ctrl/internal/config"
+	"github.com/saintbyte/home-ctrl/internal/database"
+	"github.com/saintbyte/home-ctrl/internal/database/models"
+)
+
+// parseJSONPath splits a path such as "$.devices[0].name" or "devices.0.name"
+// into object keys (strings) and array indexes (ints)
+func parseJSONPath(path string) ([]any, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return nil, nil
+	}
+
+	var segments []any
+	for _, part := range strings.Split(path, ".") {
+		name, rest, _ := strings.Cut(part, "[")
+		if name == "" && rest == "" {
+			return nil, fmt.Errorf("invalid JSON path %q: empty segment", path)
+		}
+		if name != "" {
+			if index, err := strconv.Atoi(name); err == nil {
+				segments = append(segments, index)
+			} else {
+				segments = append(segments, name)
+			}
+		}
+		for rest != "" {
+			indexText, after, ok := strings.Cut(rest, "]")
+			index, err := strconv.Atoi(indexText)
+			if !ok || err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid JSON path %q: bad array index", path)
+			}
+			segments = append(segments, index)
+			if after == "" {
+				break
+			}
+			if !strings.HasPrefix(after, "[") {
+				return nil, fmt.Errorf("invalid JSON path %q", path)
+			}
+			rest = after[1:]
+		}
+	}
+	return segments, nil
+}
+
+// extractJSON returns the value at path in a JSON document. Strings are
+// returned as is, other values as JSON.
+func extractJSON(data, path string) (string, error) {
+	segments, err := parseJSONPath(path)
+	if err != nil {
+		return "", err
+	}
+
+	var value any
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		return "", fmt.Errorf("result is not valid JSON: %w", err)
+	}
+	for _, segment := range segments {
+		switch key := segment.(type) {
+		case string:
+			object, ok := value.(map[string]any)
+			if !ok {
+				return "", fmt.Errorf("%s: %q is not an object member", path, key)
+			}
+			if value, ok = object[key]; !ok {
+				return "", fmt.Errorf("%s: no member %q", path, key)
+			}
+		case int:
+			array, ok := value.([]any)
+			if !ok || key >= len(array) {
+				return "", fmt.Errorf("%s: no array element %d", path, key)
+			}
+			value = array[key]
+		}
+	}
+
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// publishResult writes the result data of a successful run, or its output if
+// the command reports no data, to the output key of the task as a value of
+// its output type and marks it unread. Unchanged values are left alone so
+// that repeated runs don't resurface them. Result data cut off at
+// command.MaxData bytes is not published.
+func (s *Scheduler) publishResult(task config.Task, result *command.Result) error {
+	if task.OutputKey == "" || s.db == nil {
+		return nil
+	}
+
+	value := ""
+	if result != nil {
+		if result.Truncated {
+			return fmt.Errorf("failed to publish output: result data exceeds %d bytes", command.MaxData)
+		}
+		value = result.Data
+		if value == "" {
+			value = result.Output
+		}
+	}
+	value = strings.TrimRight(value, "\r\n")
+	if task.OutputPath != "" {
+		extracted, err := extractJSON(value, task.OutputPath)
+		if err != nil {
+			return fmt.Errorf("failed to extract output: %w", err)
+		}
+		value = extracted
+	}
+
+	// The value is parsed and checked against the schema of its key like a
+	// string value set through the API
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to publish output: %w", err)
+	}
+	value, valueType, err := models.ParseValue(raw, task.OutputType)
+	if err != nil {
+		return fmt.Errorf("failed to publish output: %w", err)
+	}
+	if err := s.db.ValidateKeyValue(task.OutputKey, value, valueType); err != nil {
+		return fmt.Errorf("failed to publish output: %w", err)
+	}
+
+	write := database.WriteOptions{By: "task:" + task.Name}
+	if _, _, err := s.db.PublishKeyValue(task.OutputKey, value, valueType, write); err != nil {
+		return fmt.Errorf("failed to publish output: %w", err)
+	}
+	return nil
+}
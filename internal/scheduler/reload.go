@@ -0,0 +1,160 @@
+package scheduler
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/saintbyte/home-ctrl/internal/config"
+	"github.com/saintbyte/home-ctrl/internal/database"
+)
+
+// ReloadSummary lists the tasks changed by Reload
+type ReloadSummary struct {
+	Added     []string `json:"added"`
+	Removed   []string `json:"removed"`
+	Changed   []string `json:"changed"`
+	Unchanged int      `json:"unchanged"`
+}
+
+func (r ReloadSummary) String() string {
+	parts := []string{
+		summaryPart("added", r.Added),
+		summaryPart("removed", r.Removed),
+		summaryPart("changed", r.Changed),
+		fmt.Sprintf("%d unchanged", r.Unchanged),
+	}
+	return strings.Join(parts, ", ")
+}
+
+func summaryPart(label string, names []string) string {
+	if len(names) == 0 {
+		return "0 " + label
+	}
+	return fmt.Sprintf("%d %s (%s)", len(names), label, strings.Join(names, ", "))
+}
+
+// Reload applies a new configuration. Only the cron entries of config tasks
+// that were added, removed or changed are replaced; runs in progress finish
+// with the definition they were started with. A change of the default
+// timezone or the coordinates reschedules all tasks. A configuration with
+// invalid tasks is rejected and the current one is kept.
+func (s *Scheduler) Reload(cfg *config.Config) (ReloadSummary, error) {
+	if err := ValidateConfig(cfg); err != nil {
+		return ReloadSummary{}, err
+	}
+
+	var overrides map[string]bool
+	var definitions []database.TaskDefinition
+	if s.db != nil {
+		var err error
+		if overrides, err = s.db.GetTaskOverrides(); err != nil {
+			fmt.Printf("Failed to load task overrides: %v\n", err)
+		}
+		if definitions, err = s.db.ListTaskDefinitions(); err != nil {
+			fmt.Printf("Failed to load stored tasks: %v\n", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := s.config.Load()
+	previous := make(map[string]config.Task, len(old.Tasks))
+	for _, task := range old.Tasks {
+		previous[task.Name] = task
+	}
+	// Schedules without a timezone of their own and sun-based ones depend on these
+	rescheduleAll := old.Timezone != cfg.Timezone ||
+		old.Latitude != cfg.Latitude || old.Longitude != cfg.Longitude
+	s.config.Store(cfg)
+
+	var summary ReloadSummary
+	tasks := make([]TaskInfo, 0, len(cfg.Tasks)+len(s.tasks))
+	defined := make(map[string]bool, len(cfg.Tasks))
+	for _, task := range cfg.Tasks {
+		defined[task.Name] = true
+		i := s.findTask(task.Name)
+		prev, existed := previous[task.Name]
+		if i >= 0 && s.tasks[i].ReadOnly && existed && reflect.DeepEqual(prev, task) {
+			tasks = append(tasks, s.tasks[i])
+			if rescheduleAll {
+				s.reschedule(s.tasks[i].Task)
+			}
+			summary.Unchanged++
+			continue
+		}
+
+		if enabled, ok := overrides[task.Name]; ok {
+			task.Enabled = enabled
+		}
+		if i >= 0 && s.tasks[i].ReadOnly {
+			summary.Changed = append(summary.Changed, task.Name)
+		} else {
+			if i >= 0 {
+				fmt.Printf("Ignoring stored task %s: already defined in configuration\n", task.Name)
+			}
+			summary.Added = append(summary.Added, task.Name)
+		}
+		tasks = append(tasks, TaskInfo{Task: task, ReadOnly: true})
+		s.reschedule(task)
+	}
+
+	for _, info := range s.tasks {
+		switch {
+		case defined[info.Name]:
+			// Replaced above
+		case info.ReadOnly:
+			s.removeTask(info.Name)
+			summary.Removed = append(summary.Removed, info.Name)
+		default:
+			tasks = append(tasks, info)
+			if rescheduleAll {
+				s.reschedule(info.Task)
+			}
+		}
+	}
+
+	// Stored tasks shadowed by a removed config task become visible again
+	for _, def := range definitions {
+		if defined[def.Name] || findTaskIn(tasks, def.Name) >= 0 {
+			continue
+		}
+		task, err := storedTask(def)
+		if err != nil {
+			fmt.Printf("Ignoring stored task %s: %v\n", def.Name, err)
+			continue
+		}
+		tasks = append(tasks, TaskInfo{Task: task})
+		s.reschedule(task)
+		summary.Added = append(summary.Added, task.Name)
+	}
+	s.tasks = tasks
+
+	if !reflect.DeepEqual(old.Scheduler.Maintenance, cfg.Scheduler.Maintenance) || rescheduleAll {
+		s.maintenance = s.parseMaintenance(cfg.Scheduler.Maintenance)
+	}
+	if old.Scheduler.Workers != cfg.Scheduler.Workers {
+		fmt.Println("Changing the number of scheduler workers requires a restart")
+	}
+
+	fmt.Printf("Reloaded tasks: %s\n", summary)
+	return summary, nil
+}
+
+// reschedule replaces the cron entry of a task. The caller must hold s.mu.
+func (s *Scheduler) reschedule(task config.Task) {
+	s.removeTask(task.Name)
+	if task.Enabled && task.Schedule != "" {
+		s.addTask(task)
+	}
+}
+
+func findTaskIn(tasks []TaskInfo, name string) int {
+	for i, info := range tasks {
+		if info.Name == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,440 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/saintbyte/home-ctrl/internal/command"
+	"github.com/saintbyte/home-ctrl/internal/config"
+	"github.com/saintbyte/home-ctrl/internal/database"
+)
+
+// Overlap policies applied when a task is triggered while a previous run is in progress
+const (
+	OverlapAllow = "allow" // start another run
+	OverlapSkip  = "skip"  // drop the new run
+	OverlapQueue = "queue" // start the new run once the previous one finished
+)
+
+// Failure policies applied once all attempts of a run failed
+const (
+	OnFailureContinue = "continue" // keep the task scheduled
+	OnFailureDisable  = "disable"  // disable the task
+)
+
+// Retry delays used when a task with retries doesn't configure them
+const (
+	defaultRetryBackoff    = 10 * time.Second
+	defaultRetryBackoffMax = 10 * time.Minute
+)
+
+// Instance states
+const (
+	InstanceQueued   = "queued"
+	InstanceRunning  = "running"
+	InstanceRetrying = "retrying" // waiting for the next attempt
+)
+
+// Instance represents a queued or running run of a task
+type Instance struct {
+	RunID     int        `json:"run_id,omitempty"`
+	Trigger   string     `json:"trigger"`
+	State     string     `json:"state"`
+	Attempt   int        `json:"attempt"`
+	QueuedAt  time.Time  `json:"queued_at"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+
+	ctx    context.Context // cancelled when the run is cancelled or the scheduler stops
+	cancel context.CancelFunc
+	params map[string]any // arguments of parameterized tasks
+}
+
+// retryDelay returns the delay before the given retry (1-based): the
+// configured backoff doubled for every previous retry, capped at the maximum
+func retryDelay(task config.Task, retry int) time.Duration {
+	delay := task.RetryBackoff
+	if delay <= 0 {
+		delay = defaultRetryBackoff
+	}
+	limit := task.RetryBackoffMax
+	if limit <= 0 {
+		limit = defaultRetryBackoffMax
+	}
+
+	for i := 1; i < retry && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	return delay
+}
+
+// dispatch applies the overlap policy of the task and runs it in the background,
+// together with its dependencies and follow-up tasks if it is chained.
+// It returns ErrTaskRunning if the run was skipped.
+func (s *Scheduler) dispatch(task config.Task, trigger string, args map[string]any) (*database.TaskRun, error) {
+	run, job, err := s.prepare(task, trigger, args)
+	if err != nil {
+		return nil, err
+	}
+	go job()
+	return run, nil
+}
+
+// prepare substitutes the arguments into the task, applies its overlap policy
+// and records its first run. The returned job executes the run and returns
+// once it finished.
+func (s *Scheduler) prepare(task config.Task, trigger string, args map[string]any) (*database.TaskRun, func(), error) {
+	task, params, err := s.parameterize(task, args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	instance, queue, err := s.admit(s.ctx, task, trigger)
+	if err != nil {
+		if errors.Is(err, ErrTaskRunning) {
+			s.recordSkipped(task, trigger, 0, "previous run still in progress")
+		}
+		return nil, nil, err
+	}
+
+	chainID := 0
+	var chain *database.TaskChain
+	if task.Chained() {
+		chain = s.createChain(task, trigger)
+		if chain != nil {
+			chainID = chain.ID
+		}
+	}
+
+	instance.params = params
+	first := s.createRun(task, trigger, 1, chainID, params)
+	s.setInstanceRun(instance, first)
+
+	// Catch-up looks for windows missed since the last successful scheduled run
+	succeeded := func() {
+		if trigger == database.TriggerCron || trigger == database.TriggerCatchUp {
+			s.recordFired(task.Name, instance.QueuedAt)
+		}
+	}
+	job := func() {
+		if task.Chained() {
+			if s.runChain(task, trigger, chain, first, instance, queue) {
+				succeeded()
+			}
+			return
+		}
+		defer s.removeInstance(task.Name, instance)
+		if s.runInstance(task, trigger, chainID, first, instance, queue) == nil {
+			succeeded()
+		}
+	}
+	return first, job, nil
+}
+
+// admit registers a new instance of the task according to its overlap policy.
+// The instance context is derived from parent. It returns the queue the
+// instance has to wait on, if any, ErrTaskRunning or ErrSchedulerStopped.
+func (s *Scheduler) admit(parent context.Context, task config.Task, trigger string) (*Instance, chan struct{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return nil, nil, ErrSchedulerStopped
+	}
+	if task.Overlap == OverlapSkip && len(s.instances[task.Name]) > 0 {
+		return nil, nil, fmt.Errorf("%w: %s", ErrTaskRunning, task.Name)
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	instance := &Instance{
+		Trigger:  trigger,
+		State:    InstanceQueued,
+		Attempt:  1,
+		QueuedAt: time.Now(),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	s.instances[task.Name] = append(s.instances[task.Name], instance)
+
+	var queue chan struct{}
+	if task.Overlap == OverlapQueue {
+		queue = s.queues[task.Name]
+		if queue == nil {
+			queue = make(chan struct{}, 1)
+			s.queues[task.Name] = queue
+		}
+	}
+	return instance, queue, nil
+}
+
+// setInstanceRun links an instance to the run record of its current attempt
+func (s *Scheduler) setInstanceRun(instance *Instance, run *database.TaskRun) {
+	if run == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	instance.RunID = run.ID
+}
+
+// runInstance waits for the queue of the task and executes the run, retrying
+// failed attempts as configured. It returns the error of the last attempt,
+// or the context error if the run was cancelled.
+func (s *Scheduler) runInstance(task config.Task, trigger string, chainID int, first *database.TaskRun, instance *Instance, queue chan struct{}) error {
+	ctx := instance.ctx
+	if queue != nil {
+		select {
+		case queue <- struct{}{}:
+			defer func() { <-queue }()
+		case <-ctx.Done():
+			s.cancelRun(task, first)
+			return ctx.Err()
+		}
+	}
+
+	run := first
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			delay := retryDelay(task, attempt-1)
+			fmt.Printf("Retrying task %s in %s (attempt %d of %d)\n", task.Name, delay, attempt, task.Retries+1)
+
+			s.mu.Lock()
+			instance.State = InstanceRetrying
+			instance.Attempt = attempt
+			instance.StartedAt = nil
+			s.mu.Unlock()
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				// The failed attempt won't be retried after all
+				if run != nil {
+					if err := s.db.SetTaskRunStatus(run.ID, database.TaskRunStatusCancelled); err != nil {
+						fmt.Printf("Failed to record cancelled run of task %s: %v\n", task.Name, err)
+					}
+				}
+				return ctx.Err()
+			}
+
+			run = s.createRun(task, trigger, attempt, chainID, instance.params)
+			s.setInstanceRun(instance, run)
+		}
+
+		final := attempt > task.Retries
+		err := s.runAttempt(ctx, task, run, instance, final)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if final {
+			s.handleFailure(task)
+			return err
+		}
+	}
+}
+
+// runAttempt waits for a free worker and executes one attempt of a run
+func (s *Scheduler) runAttempt(ctx context.Context, task config.Task, run *database.TaskRun, instance *Instance, final bool) error {
+	select {
+	case s.workers <- struct{}{}:
+		defer func() { <-s.workers }()
+	case <-ctx.Done():
+		s.cancelRun(task, run)
+		return ctx.Err()
+	}
+
+	s.mu.Lock()
+	startedAt := time.Now()
+	instance.State = InstanceRunning
+	instance.StartedAt = &startedAt
+	s.mu.Unlock()
+
+	if run != nil {
+		if err := s.db.StartTaskRun(run.ID, startedAt); err != nil {
+			fmt.Printf("Failed to record start of task %s: %v\n", task.Name, err)
+		}
+	}
+	return s.execute(ctx, task, run, final)
+}
+
+// handleFailure applies the failure policy of a task whose attempts all failed
+func (s *Scheduler) handleFailure(task config.Task) {
+	fmt.Printf("Task %s failed after %d attempt(s)\n", task.Name, task.Retries+1)
+
+	if task.OnFailure == OnFailureDisable {
+		if err := s.DisableTask(task.Name); err != nil {
+			fmt.Printf("Failed to disable task %s: %v\n", task.Name, err)
+			return
+		}
+		fmt.Printf("Task %s disabled by its failure policy\n", task.Name)
+	}
+}
+
+// removeInstance forgets a finished run
+func (s *Scheduler) removeInstance(name string, instance *Instance) {
+	instance.cancel()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	instances := s.instances[name]
+	for i := range instances {
+		if instances[i] == instance {
+			s.instances[name] = append(instances[:i:i], instances[i+1:]...)
+			break
+		}
+	}
+	if len(s.instances[name]) == 0 {
+		delete(s.instances, name)
+	}
+}
+
+// instancesOf returns a copy of the queued and running runs of a task.
+// The caller must hold s.mu.
+func (s *Scheduler) instancesOf(name string) []Instance {
+	instances := make([]Instance, 0, len(s.instances[name]))
+	for _, instance := range s.instances[name] {
+		instances = append(instances, *instance)
+	}
+	return instances
+}
+
+// parameterize validates the arguments of a run of a parameterized task and
+// returns the task with its command templates filled in
+func (s *Scheduler) parameterize(task config.Task, args map[string]any) (config.Task, map[string]any, error) {
+	if len(task.Params) == 0 {
+		if len(args) > 0 {
+			return task, nil, fmt.Errorf("%w: task %s has no parameters", ErrInvalidArgs, task.Name)
+		}
+		return task, nil, nil
+	}
+
+	params, err := resolveParams(task, args)
+	if err != nil {
+		return task, nil, err
+	}
+	task, err = renderTask(task, params)
+	if err != nil {
+		return task, nil, err
+	}
+	return task, params, nil
+}
+
+// createRun records an attempt of a triggered run
+func (s *Scheduler) createRun(task config.Task, trigger string, attempt, chainID int, params map[string]any) *database.TaskRun {
+	if s.db == nil {
+		return nil
+	}
+
+	run, err := s.db.CreateTaskRun(task.Name, trigger, attempt, chainID, params, time.Now())
+	if err != nil {
+		fmt.Printf("Failed to record run of task %s: %v\n", task.Name, err)
+		return nil
+	}
+	return run
+}
+
+// recordSkipped records a run that was not started
+func (s *Scheduler) recordSkipped(task config.Task, trigger string, chainID int, reason string) {
+	s.skipRun(task, s.createRun(task, trigger, 1, chainID, nil), reason)
+}
+
+// cancelRun marks a recorded run that was cancelled before it started
+func (s *Scheduler) cancelRun(task config.Task, run *database.TaskRun) {
+	if run == nil {
+		return
+	}
+	if err := s.db.FinishTaskRun(run.ID, database.TaskRunStatusCancelled, 0, "cancelled before start", time.Now()); err != nil {
+		fmt.Printf("Failed to record cancelled run of task %s: %v\n", task.Name, err)
+	}
+}
+
+// skipRun marks a recorded run as skipped
+func (s *Scheduler) skipRun(task config.Task, run *database.TaskRun, reason string) {
+	if run == nil {
+		return
+	}
+	if err := s.db.FinishTaskRun(run.ID, database.TaskRunStatusSkipped, 0, reason, time.Now()); err != nil {
+		fmt.Printf("Failed to record skipped run of task %s: %v\n", task.Name, err)
+	}
+}
+
+// execute runs the task through the executor and records the outcome.
+// The run is limited by the task timeout. A failed attempt that is not final
+// is recorded as retrying, one whose context was cancelled as cancelled.
+func (s *Scheduler) execute(ctx context.Context, task config.Task, run *database.TaskRun, final bool) error {
+	runCtx := ctx
+	if task.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, task.Timeout)
+		defer cancel()
+	}
+
+	var stream *runStream
+	if run != nil {
+		stream = s.startStream(run.ID)
+		runCtx = command.WithOutputSink(runCtx, stream.write)
+	}
+
+	var result *command.Result
+	var err error
+	if s.executor != nil {
+		result, err = s.executor(runCtx, task)
+	}
+	if err == nil {
+		err = s.publishResult(task, result)
+	}
+
+	status := database.TaskRunStatusSuccess
+	exitCode := 0
+	output := ""
+	if result != nil {
+		exitCode = result.ExitCode
+		output = result.Output
+	}
+	if err != nil {
+		switch {
+		case ctx.Err() != nil:
+			status = database.TaskRunStatusCancelled
+			err = fmt.Errorf("task %s cancelled: %w", task.Name, ctx.Err())
+		case errors.Is(runCtx.Err(), context.DeadlineExceeded):
+			status = database.TaskRunStatusFailed
+			err = fmt.Errorf("task %s timed out after %s", task.Name, task.Timeout)
+		default:
+			status = database.TaskRunStatusFailed
+		}
+		if status == database.TaskRunStatusFailed && !final {
+			status = database.TaskRunStatusRetrying
+		}
+		fmt.Printf("Task %s failed: %v\n", task.Name, err)
+		if output != "" && output[len(output)-1] != '\n' {
+			output += "\n"
+		}
+		output += err.Error()
+	} else {
+		fmt.Printf("Task %s completed with exit code %d\n", task.Name, exitCode)
+	}
+
+	if run != nil {
+		if result != nil && result.StatusCode != 0 {
+			if dbErr := s.db.SetTaskRunHTTPStatus(run.ID, result.StatusCode); dbErr != nil {
+				fmt.Printf("Failed to record HTTP status of task %s: %v\n", task.Name, dbErr)
+			}
+		}
+		if dbErr := s.db.FinishTaskRun(run.ID, status, exitCode, output, time.Now()); dbErr != nil {
+			fmt.Printf("Failed to record result of task %s: %v\n", task.Name, dbErr)
+		}
+		s.endStream(run.ID, stream, status, exitCode, output)
+	}
+
+	return err
+}
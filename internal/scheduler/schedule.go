@@ -0,0 +1,154 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/saintbyte/home-ctrl/internal/config"
+)
+
+// scheduleParser accepts standard 5-field cron expressions, 6-field ones
+// starting with seconds and descriptors such as "@daily" or "@every 30s"
+var scheduleParser = cron.NewParser(
+	cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
+)
+
+// parseSchedule parses a cron or solar event schedule evaluated in the given
+// location. A CRON_TZ= or TZ= prefix in a cron expression takes precedence.
+func (s *Scheduler) parseSchedule(spec string, loc *time.Location) (cron.Schedule, error) {
+	return parseScheduleIn(s.config.Load(), spec, loc)
+}
+
+// parseScheduleIn parses a schedule like parseSchedule, taking the
+// coordinates of solar event schedules from cfg
+func parseScheduleIn(cfg *config.Config, spec string, loc *time.Location) (cron.Schedule, error) {
+	if isAstroSchedule(spec) {
+		event, offset, err := parseAstroSchedule(spec)
+		if err != nil {
+			return nil, err
+		}
+		if !cfg.HasCoordinates() {
+			return nil, fmt.Errorf("%s schedules require latitude and longitude in the configuration", event)
+		}
+		return &astroSchedule{
+			event:     event,
+			offset:    offset,
+			latitude:  cfg.Latitude,
+			longitude: cfg.Longitude,
+			location:  loc,
+		}, nil
+	}
+
+	schedule, err := scheduleParser.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if cronSchedule, ok := schedule.(*cron.SpecSchedule); ok && !hasTimezonePrefix(spec) {
+		cronSchedule.Location = loc
+	}
+	return schedule, nil
+}
+
+func hasTimezonePrefix(spec string) bool {
+	spec = strings.TrimSpace(spec)
+	return strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=")
+}
+
+// location returns the timezone the schedule of a task is evaluated in
+func (s *Scheduler) location(task config.Task) (*time.Location, error) {
+	return taskLocation(s.config.Load(), task)
+}
+
+// taskLocation returns the timezone of a task, the default timezone of cfg
+// if it has none
+func taskLocation(cfg *config.Config, task config.Task) (*time.Location, error) {
+	if task.Timezone == "" {
+		return cfg.Location(), nil
+	}
+	loc, err := time.LoadLocation(task.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", task.Timezone)
+	}
+	return loc, nil
+}
+
+// taskSchedule parses the schedule of a task in its effective timezone
+func (s *Scheduler) taskSchedule(task config.Task) (cron.Schedule, error) {
+	loc, err := s.location(task)
+	if err != nil {
+		return nil, err
+	}
+	schedule, err := s.parseSchedule(task.Schedule, loc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", task.Schedule, err)
+	}
+	return schedule, nil
+}
+
+// EffectiveTimezone returns the name of the timezone the schedule of a task is evaluated in
+func (s *Scheduler) EffectiveTimezone(task config.Task) string {
+	loc, err := s.location(task)
+	if err != nil {
+		return task.Timezone
+	}
+	return loc.String()
+}
+
+// MaxPreviewRuns limits the number of fire times returned by previews
+const MaxPreviewRuns = 100
+
+// NextRuns returns the next count fire times of a scheduled task. The list
+// is empty if the task is disabled or has no schedule.
+func (s *Scheduler) NextRuns(name string, count int) ([]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findTask(name) < 0 {
+		return nil, fmt.Errorf("%w: %s", ErrTaskNotFound, name)
+	}
+	id, scheduled := s.taskIDs[name]
+	if !scheduled {
+		return []time.Time{}, nil
+	}
+
+	entry := s.cron.Entry(id)
+	if entry.Schedule == nil {
+		return []time.Time{}, nil
+	}
+	// The cron entry only knows its next time while the scheduler is running
+	if entry.Next.IsZero() {
+		return nextTimes(entry.Schedule, time.Now(), count), nil
+	}
+	return append([]time.Time{entry.Next}, nextTimes(entry.Schedule, entry.Next, count-1)...), nil
+}
+
+// PreviewSchedule parses a schedule expression evaluated in timezone (the
+// default timezone if empty) and returns its next count fire times
+func (s *Scheduler) PreviewSchedule(schedule, timezone string, count int) ([]time.Time, error) {
+	loc, err := s.location(config.Task{Timezone: timezone})
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := s.parseSchedule(schedule, loc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", schedule, err)
+	}
+	return nextTimes(parsed, time.Now().In(loc), count), nil
+}
+
+// nextTimes returns up to count fire times of a schedule after from
+func nextTimes(schedule cron.Schedule, from time.Time, count int) []time.Time {
+	times := []time.Time{}
+	for len(times) < count {
+		next := schedule.Next(from)
+		if next.IsZero() {
+			break
+		}
+		times = append(times, next)
+		from = next
+	}
+	return times
+}
@@ -1,119 +1,245 @@
 package scheduler
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/robfig/cron/v3"
+	"github.com/saintbyte/home-ctrl/internal/command"
 	"github.com/saintbyte/home-ctrl/internal/config"
+	"github.com/saintbyte/home-ctrl/internal/database"
 )
 
-type TaskExecutor func(taskName string)
+// Errors returned by task operations
+var (
+	ErrTaskNotFound = errors.New("task not found")
+	ErrTaskExists   = errors.New("task already exists")
+	ErrTaskReadOnly = errors.New("task is defined in the configuration file and is read-only")
+	ErrInvalidTask  = errors.New("invalid task")
+	ErrTaskRunning  = errors.New("task is already running")
+	ErrTaskInUse    = errors.New("task is linked by other tasks")
+
+	ErrTaskNotRunning   = errors.New("task is not running")
+	ErrInvalidArgs      = errors.New("invalid task arguments")
+	ErrSchedulerStopped = errors.New("scheduler is stopped")
+)
+
+// defaultWorkers is the worker pool size used when none is configured
+const defaultWorkers = 4
+
+// defaultShutdownGrace is how long Stop waits for cancelled runs when none is configured
+const defaultShutdownGrace = 10 * time.Second
+
+// TaskExecutor runs the command of a task and reports its result
+type TaskExecutor func(ctx context.Context, task config.Task) (*command.Result, error)
+
+// TaskInfo describes a task known to the scheduler
+type TaskInfo struct {
+	config.Task
+	ReadOnly          bool       // defined in the configuration file
+	EffectiveTimezone string     // timezone the schedule is evaluated in
+	Instances         []Instance // queued and running runs
+}
 
 type Scheduler struct {
-	cron     *cron.Cron
-	config   *config.Config
-	executor TaskExecutor
-	taskIDs  map[string]cron.EntryID
-	mu       sync.Mutex
+	cron      *cron.Cron
+	config    atomic.Pointer[config.Config] // replaced by Reload
+	db        *database.Database
+	executor  TaskExecutor
+	tasks     []TaskInfo
+	taskIDs   map[string]cron.EntryID
+	workers   chan struct{}            // worker pool slots
+	instances map[string][]*Instance   // queued and running runs by task name
+	queues    map[string]chan struct{} // serializes runs of tasks with the queue policy
+	streams   map[int]*runStream       // output of running attempts by run ID
+	ctx       context.Context          // parent of all run contexts, cancelled by Stop
+	cancel    context.CancelFunc
+
+	instanceID  string        // identifies this process as holder of the scheduler lease
+	leaderUntil time.Time     // end of the scheduler lease held by this instance
+	leading     chan struct{} // closed when the lease renewal ends
+
+	maintenance []maintenanceWindow // recurring windows without scheduled runs
+	paused      bool                // scheduled runs are paused, as last read from the database
+	pausedAt    *time.Time
+	stopped     bool
+	mu          sync.Mutex
 }
 
-func NewScheduler(cfg *config.Config, executor TaskExecutor) *Scheduler {
-	return &Scheduler{
-		cron:     cron.New(),
-		config:   cfg,
-		executor: executor,
-		taskIDs:  make(map[string]cron.EntryID),
+// NewScheduler creates a new scheduler with the tasks from the configuration
+// and, when db is not nil, the tasks stored in the database. Runs are recorded in db.
+func NewScheduler(cfg *config.Config, db *database.Database, executor TaskExecutor) *Scheduler {
+	workers := cfg.Scheduler.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
 	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Scheduler{
+		cron:      cron.New(cron.WithParser(scheduleParser), cron.WithLocation(cfg.Location())),
+		db:        db,
+		executor:  executor,
+		taskIDs:   make(map[string]cron.EntryID),
+		workers:   make(chan struct{}, workers),
+		instances: make(map[string][]*Instance),
+		queues:    make(map[string]chan struct{}),
+		streams:   make(map[int]*runStream),
+		ctx:       ctx,
+		cancel:    cancel,
+
+		instanceID: newInstanceID(),
+	}
+	s.config.Store(cfg)
+	s.loadTasks()
+	s.loadMaintenance()
+	return s
 }
 
+// Start schedules the enabled tasks. Scheduled runs only happen on the
+// instance holding the scheduler lease, which catches up the runs missed
+// while no instance was running them.
 func (s *Scheduler) Start() {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	for _, task := range s.config.Tasks {
-		if task.Enabled && task.Schedule != "" {
-			s.addTask(task)
+	for _, info := range s.tasks {
+		if info.Enabled && info.Schedule != "" {
+			if _, ok := s.taskIDs[info.Name]; !ok {
+				s.addTask(info.Task)
+			}
 		}
 	}
 	s.cron.Start()
 	fmt.Printf("Scheduler started with %d tasks\n", len(s.taskIDs))
+	s.mu.Unlock()
+
+	if s.db == nil {
+		return
+	}
+	now := time.Now()
+	if s.campaign(now) {
+		s.catchUp(s.scheduledTasks(), now)
+	} else {
+		fmt.Printf("Instance %s is standing by, another instance holds the scheduler lease\n", s.instanceID)
+	}
+	s.leading = make(chan struct{})
+	go s.lead()
 }
 
+// Stop unschedules all tasks, cancels the queued and running runs and waits
+// for them to finish, at most for the configured grace period
 func (s *Scheduler) Stop() {
+	<-s.cron.Stop().Done()
+
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.stopped = true
+	running := len(s.instances)
+	s.mu.Unlock()
+	s.cancel()
+
+	grace := s.config.Load().Scheduler.ShutdownGrace
+	if grace <= 0 {
+		grace = defaultShutdownGrace
+	}
+	if running > 0 {
+		fmt.Printf("Cancelling runs of %d task(s)\n", running)
+	}
 
-	ctx := s.cron.Stop()
-	<-ctx.Done()
+	deadline := time.Now().Add(grace)
+	for {
+		s.mu.Lock()
+		running = len(s.instances)
+		s.mu.Unlock()
+		if running == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			fmt.Printf("Runs of %d task(s) still in progress after %s\n", running, grace)
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	s.resign()
 	fmt.Println("Scheduler stopped")
 }
 
 func (s *Scheduler) addTask(task config.Task) {
-	id, err := s.cron.AddFunc(task.Schedule, func() {
-		fmt.Printf("Running task: %s\n", task.Name)
-		if s.executor != nil {
-			s.executor(task.Name)
-		}
-	})
-
+	schedule, err := s.taskSchedule(task)
 	if err != nil {
 		fmt.Printf("Failed to add task %s: %v\n", task.Name, err)
 		return
 	}
 
+	id := s.cron.Schedule(schedule, cron.FuncJob(func() {
+		if !s.isLeader() {
+			// Another instance runs the scheduled tasks
+			return
+		}
+		now := time.Now()
+		if reason := s.suppressed(now); reason != "" {
+			fmt.Printf("Task %s %s\n", task.Name, reason)
+			// Windows skipped on purpose are not made up for later
+			s.recordFired(task.Name, now)
+			s.recordSkipped(task, database.TriggerCron, 0, reason)
+			return
+		}
+		fmt.Printf("Running task: %s\n", task.Name)
+		if _, err := s.dispatch(task, database.TriggerCron, nil); err != nil {
+			fmt.Printf("Skipping task %s: %v\n", task.Name, err)
+		}
+	}))
+
 	s.taskIDs[task.Name] = id
-	fmt.Printf("Added task: %s with schedule: %s\n", task.Name, task.Schedule)
+	fmt.Printf("Added task: %s with schedule: %s (%s)\n", task.Name, task.Schedule, s.EffectiveTimezone(task))
 }
 
-func (s *Scheduler) EnableTask(name string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	for _, task := range s.config.Tasks {
-		if task.Name == name {
-			task.Enabled = true
-			s.addTask(task)
-			return nil
-		}
+// removeTask removes the cron entry of a task, if any
+func (s *Scheduler) removeTask(name string) {
+	if id, ok := s.taskIDs[name]; ok {
+		s.cron.Remove(id)
+		delete(s.taskIDs, name)
 	}
-	return fmt.Errorf("task not found: %s", name)
 }
 
-func (s *Scheduler) DisableTask(name string) error {
+// findTask returns the index of a task in s.tasks, or -1
+func (s *Scheduler) findTask(name string) int {
+	return findTaskIn(s.tasks, name)
+}
+
+// CancelTask cancels the queued and running runs of a task and returns
+// their number. It returns ErrTaskNotRunning if there are none.
+func (s *Scheduler) CancelTask(name string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if id, ok := s.taskIDs[name]; ok {
-		s.cron.Remove(id)
-		delete(s.taskIDs, name)
-
-		for i := range s.config.Tasks {
-			if s.config.Tasks[i].Name == name {
-				s.config.Tasks[i].Enabled = false
-				break
-			}
-		}
-		return nil
+	if s.findTask(name) < 0 {
+		return 0, fmt.Errorf("%w: %s", ErrTaskNotFound, name)
+	}
+	instances := s.instances[name]
+	if len(instances) == 0 {
+		return 0, fmt.Errorf("%w: %s", ErrTaskNotRunning, name)
+	}
+	for _, instance := range instances {
+		instance.cancel()
 	}
-	return fmt.Errorf("task not found: %s", name)
+	fmt.Printf("Cancelled %d run(s) of task %s\n", len(instances), name)
+	return len(instances), nil
 }
 
-func (s *Scheduler) RunTask(name string) error {
-	for _, task := range s.config.Tasks {
-		if task.Name == name {
-			go func() {
-				fmt.Printf("Manually running task: %s\n", name)
-				if s.executor != nil {
-					s.executor(name)
-				}
-			}()
-			return nil
-		}
+// RunTask triggers a manual run of a task with the given arguments for its
+// parameters and returns its run record (nil when runs are not recorded)
+func (s *Scheduler) RunTask(name string, args map[string]any) (*database.TaskRun, error) {
+	s.mu.Lock()
+	i := s.findTask(name)
+	if i < 0 {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrTaskNotFound, name)
 	}
-	return fmt.Errorf("task not found: %s", name)
-}
+	task := s.tasks[i].Task
+	s.mu.Unlock()
 
-func (s *Scheduler) GetTasks() []config.Task {
-	return s.config.Tasks
+	fmt.Printf("Manually running task: %s\n", name)
+	return s.dispatch(task, database.TriggerManual, args)
 }
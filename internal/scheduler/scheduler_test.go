@@ -0,0 +1,1468 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/saintbyte/home-ctrl/internal/command"
+	"github.com/saintbyte/home-ctrl/internal/config"
+	"github.com/saintbyte/home-ctrl/internal/database"
+	"github.com/saintbyte/home-ctrl/internal/database/models"
+)
+
+func newTestDatabase(t *testing.T) *database.Database {
+	t.Helper()
+
+	db, err := database.NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.CreateTaskRunsTable(); err != nil {
+		t.Fatalf("Failed to create task runs table: %v", err)
+	}
+	if err := db.CreateTasksTable(); err != nil {
+		t.Fatalf("Failed to create tasks table: %v", err)
+	}
+	if err := db.CreateTaskChainsTable(); err != nil {
+		t.Fatalf("Failed to create task chains table: %v", err)
+	}
+	if err := db.CreateLeasesTable(); err != nil {
+		t.Fatalf("Failed to create leases table: %v", err)
+	}
+	if err := db.CreateKeyValueTable(); err != nil {
+		t.Fatalf("Failed to create key-value table: %v", err)
+	}
+	if err := db.CreateKeyValueSchemasTable(); err != nil {
+		t.Fatalf("Failed to create key-value schemas table: %v", err)
+	}
+	return db
+}
+
+// waitForRun polls the database until the run has finished
+func waitForRun(t *testing.T, db *database.Database, taskName string) database.TaskRun {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		run, err := db.GetLastTaskRun(taskName)
+		if err != nil {
+			t.Fatalf("GetLastTaskRun() failed: %v", err)
+		}
+		if run != nil && run.FinishedAt != nil {
+			return *run
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Task %s did not finish in time", taskName)
+	return database.TaskRun{}
+}
+
+func TestRunTaskRecordsHistory(t *testing.T) {
+	db := newTestDatabase(t)
+	cfg := config.DefaultConfig()
+	cfg.Tasks = []config.Task{
+		{Name: "ok", Command: "ok"},
+		{Name: "broken", Command: "broken"},
+	}
+
+	sched := NewScheduler(cfg, db, func(ctx context.Context, task config.Task) (*command.Result, error) {
+		if task.Name == "broken" {
+			return &command.Result{ExitCode: 2, Output: "partial"}, errors.New("boom")
+		}
+		return &command.Result{Output: "done"}, nil
+	})
+
+	run, err := sched.RunTask("ok", nil)
+	if err != nil {
+		t.Fatalf("RunTask() failed: %v", err)
+	}
+	if run == nil || run.Trigger != database.TriggerManual {
+		t.Fatalf("Expected manual run record, got %+v", run)
+	}
+
+	finished := waitForRun(t, db, "ok")
+	if finished.Status != database.TaskRunStatusSuccess || finished.Output != "done" {
+		t.Errorf("Unexpected successful run: %+v", finished)
+	}
+	if finished.FinishedAt == nil {
+		t.Error("Expected finished_at to be set")
+	}
+
+	if _, err := sched.RunTask("broken", nil); err != nil {
+		t.Fatalf("RunTask() failed: %v", err)
+	}
+	failed := waitForRun(t, db, "broken")
+	if failed.Status != database.TaskRunStatusFailed || failed.ExitCode != 2 {
+		t.Errorf("Unexpected failed run: %+v", failed)
+	}
+	if failed.Output != "partial\nboom" {
+		t.Errorf("Expected output with error appended, got '%s'", failed.Output)
+	}
+
+	runs, total, err := db.ListTaskRuns("ok", 10, 0)
+	if err != nil {
+		t.Fatalf("ListTaskRuns() failed: %v", err)
+	}
+	if total != 1 || len(runs) != 1 {
+		t.Errorf("Expected 1 run, got total=%d len=%d", total, len(runs))
+	}
+
+	if _, err := sched.RunTask("missing", nil); err == nil {
+		t.Error("Expected error for unknown task")
+	}
+}
+
+func TestTaskManagementPersists(t *testing.T) {
+	db := newTestDatabase(t)
+	cfg := config.DefaultConfig()
+	cfg.Tasks = []config.Task{
+		{Name: "from_config", Schedule: "0 * * * *", Enabled: true, Command: "cleanup_sessions"},
+	}
+
+	sched := NewScheduler(cfg, db, nil)
+
+	err := sched.CreateTask(config.Task{Name: "bad", Schedule: "not a cron", Command: "shell"})
+	if !errors.Is(err, ErrInvalidTask) {
+		t.Errorf("Expected ErrInvalidTask, got %v", err)
+	}
+
+	task := config.Task{
+		Name:     "from_api",
+		Schedule: "*/5 * * * *",
+		Enabled:  true,
+		Command:  "shell",
+		Args:     []string{"true"},
+		Timeout:  30 * time.Second,
+	}
+	if err := sched.CreateTask(task); err != nil {
+		t.Fatalf("CreateTask() failed: %v", err)
+	}
+	if err := sched.CreateTask(task); !errors.Is(err, ErrTaskExists) {
+		t.Errorf("Expected ErrTaskExists, got %v", err)
+	}
+	if _, ok := sched.taskIDs["from_api"]; !ok {
+		t.Error("Expected created task to be scheduled")
+	}
+
+	if err := sched.UpdateTask(config.Task{Name: "from_config", Command: "backup_data"}); !errors.Is(err, ErrTaskReadOnly) {
+		t.Errorf("Expected ErrTaskReadOnly, got %v", err)
+	}
+	if err := sched.DeleteTask("from_config"); !errors.Is(err, ErrTaskReadOnly) {
+		t.Errorf("Expected ErrTaskReadOnly, got %v", err)
+	}
+
+	task.Schedule = "0 3 * * *"
+	if err := sched.UpdateTask(task); err != nil {
+		t.Fatalf("UpdateTask() failed: %v", err)
+	}
+	if err := sched.DisableTask("from_config"); err != nil {
+		t.Fatalf("DisableTask() failed: %v", err)
+	}
+
+	// A new scheduler sees the stored task and the persisted toggle
+	reloaded := NewScheduler(cfg, db, nil)
+	tasks := reloaded.GetTasks()
+	if len(tasks) != 2 {
+		t.Fatalf("Expected 2 tasks, got %d", len(tasks))
+	}
+	if !tasks[0].ReadOnly || tasks[0].Enabled {
+		t.Errorf("Expected read-only disabled config task, got %+v", tasks[0])
+	}
+	if tasks[1].ReadOnly || tasks[1].Schedule != "0 3 * * *" || tasks[1].Timeout != 30*time.Second {
+		t.Errorf("Unexpected stored task: %+v", tasks[1])
+	}
+
+	if err := reloaded.DeleteTask("from_api"); err != nil {
+		t.Fatalf("DeleteTask() failed: %v", err)
+	}
+	if _, err := reloaded.GetTask("from_api"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("Expected ErrTaskNotFound, got %v", err)
+	}
+	if len(NewScheduler(cfg, db, nil).GetTasks()) != 1 {
+		t.Error("Expected deleted task to be removed from the database")
+	}
+}
+
+func TestOverlapPolicies(t *testing.T) {
+	db := newTestDatabase(t)
+	cfg := config.DefaultConfig()
+	cfg.Scheduler.Workers = 1
+	cfg.Tasks = []config.Task{
+		{Name: "skip", Command: "block", Overlap: OverlapSkip},
+		{Name: "queue", Command: "block", Overlap: OverlapQueue},
+	}
+
+	release := make(chan struct{})
+	started := make(chan string, 10)
+	sched := NewScheduler(cfg, db, func(ctx context.Context, task config.Task) (*command.Result, error) {
+		started <- task.Name
+		<-release
+		return &command.Result{}, nil
+	})
+
+	if _, err := sched.RunTask("skip", nil); err != nil {
+		t.Fatalf("RunTask() failed: %v", err)
+	}
+	if name := <-started; name != "skip" {
+		t.Fatalf("Expected skip to start, got %s", name)
+	}
+
+	// A second run of a skip task is rejected while the first one is running
+	if _, err := sched.RunTask("skip", nil); !errors.Is(err, ErrTaskRunning) {
+		t.Errorf("Expected ErrTaskRunning, got %v", err)
+	}
+
+	// Queue runs wait for the single worker and then for each other
+	for i := 0; i < 2; i++ {
+		if _, err := sched.RunTask("queue", nil); err != nil {
+			t.Fatalf("RunTask() failed: %v", err)
+		}
+	}
+	info, err := sched.GetTask("queue")
+	if err != nil {
+		t.Fatalf("GetTask() failed: %v", err)
+	}
+	if len(info.Instances) != 2 || info.Instances[0].State != InstanceQueued {
+		t.Errorf("Expected 2 queued instances, got %+v", info.Instances)
+	}
+	info, _ = sched.GetTask("skip")
+	if len(info.Instances) != 1 || info.Instances[0].State != InstanceRunning {
+		t.Errorf("Expected 1 running instance, got %+v", info.Instances)
+	}
+
+	for i := 0; i < 3; i++ {
+		release <- struct{}{}
+		if i < 2 {
+			if name := <-started; name != "queue" {
+				t.Fatalf("Expected queue to start, got %s", name)
+			}
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if info, _ := sched.GetTask("queue"); len(info.Instances) == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if info, _ := sched.GetTask("queue"); len(info.Instances) != 0 {
+		t.Errorf("Expected no instances left, got %+v", info.Instances)
+	}
+
+	runs, _, err := db.ListTaskRuns("skip", 10, 0)
+	if err != nil {
+		t.Fatalf("ListTaskRuns() failed: %v", err)
+	}
+	if len(runs) != 2 || runs[0].Status != database.TaskRunStatusSkipped {
+		t.Errorf("Expected a skipped run to be recorded, got %+v", runs)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	task := config.Task{RetryBackoff: time.Second, RetryBackoffMax: 5 * time.Second}
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, want := range expected {
+		if got := retryDelay(task, i+1); got != want {
+			t.Errorf("retryDelay(%d) = %s, want %s", i+1, got, want)
+		}
+	}
+
+	if got := retryDelay(config.Task{}, 1); got != defaultRetryBackoff {
+		t.Errorf("Expected default backoff %s, got %s", defaultRetryBackoff, got)
+	}
+}
+
+func TestRetriesAndFailurePolicy(t *testing.T) {
+	db := newTestDatabase(t)
+	cfg := config.DefaultConfig()
+	cfg.Tasks = []config.Task{
+		{
+			Name:         "flaky",
+			Schedule:     "0 * * * *",
+			Enabled:      true,
+			Command:      "flaky",
+			Retries:      2,
+			RetryBackoff: 10 * time.Millisecond,
+		},
+		{
+			Name:         "broken",
+			Schedule:     "0 * * * *",
+			Enabled:      true,
+			Command:      "broken",
+			Retries:      1,
+			RetryBackoff: 10 * time.Millisecond,
+			OnFailure:    OnFailureDisable,
+		},
+	}
+
+	attempts := make(map[string]int)
+	var mu sync.Mutex
+	sched := NewScheduler(cfg, db, func(ctx context.Context, task config.Task) (*command.Result, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[task.Name]++
+		if task.Name == "flaky" && attempts[task.Name] == 3 {
+			return &command.Result{}, nil
+		}
+		return &command.Result{ExitCode: 1}, errors.New("failed")
+	})
+
+	for _, name := range []string{"flaky", "broken"} {
+		if _, err := sched.RunTask(name, nil); err != nil {
+			t.Fatalf("RunTask(%s) failed: %v", name, err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		flaky, _ := sched.GetTask("flaky")
+		broken, _ := sched.GetTask("broken")
+		if len(flaky.Instances) == 0 && len(broken.Instances) == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	runs, _, err := db.ListTaskRuns("flaky", 10, 0)
+	if err != nil {
+		t.Fatalf("ListTaskRuns() failed: %v", err)
+	}
+	if len(runs) != 3 {
+		t.Fatalf("Expected 3 attempts, got %d", len(runs))
+	}
+	if runs[0].Status != database.TaskRunStatusSuccess || runs[0].Attempt != 3 {
+		t.Errorf("Expected last attempt to succeed, got %+v", runs[0])
+	}
+	if runs[2].Status != database.TaskRunStatusRetrying || runs[2].Attempt != 1 {
+		t.Errorf("Expected first attempt to be retried, got %+v", runs[2])
+	}
+
+	last, err := db.GetLastTaskRun("broken")
+	if err != nil {
+		t.Fatalf("GetLastTaskRun() failed: %v", err)
+	}
+	if last.Status != database.TaskRunStatusFailed || last.Attempt != 2 {
+		t.Errorf("Expected final failure on attempt 2, got %+v", last)
+	}
+
+	broken, _ := sched.GetTask("broken")
+	if broken.Enabled {
+		t.Error("Expected broken task to be disabled by its failure policy")
+	}
+	if _, scheduled := sched.taskIDs["broken"]; scheduled {
+		t.Error("Expected broken task to be unscheduled")
+	}
+}
+
+func TestTaskChains(t *testing.T) {
+	db := newTestDatabase(t)
+	cfg := config.DefaultConfig()
+	cfg.Tasks = []config.Task{
+		{Name: "backup", Command: "ok"},
+		{Name: "cleanup", Command: "ok", DependsOn: []string{"backup"}, OnSuccess: []string{"report"}},
+		{Name: "report", Command: "ok"},
+		{Name: "broken", Command: "broken"},
+		{Name: "after_broken", Command: "ok", DependsOn: []string{"broken"}},
+		{Name: "alert", Command: "ok"},
+		{Name: "nightly", Command: "broken", OnFailureRun: []string{"alert"}},
+	}
+
+	var order []string
+	var mu sync.Mutex
+	sched := NewScheduler(cfg, db, func(ctx context.Context, task config.Task) (*command.Result, error) {
+		mu.Lock()
+		order = append(order, task.Name)
+		mu.Unlock()
+		if task.Command == "broken" {
+			return &command.Result{ExitCode: 1}, errors.New("failed")
+		}
+		return &command.Result{}, nil
+	})
+
+	run, err := sched.RunTask("cleanup", nil)
+	if err != nil {
+		t.Fatalf("RunTask() failed: %v", err)
+	}
+	if run.ChainID == nil {
+		t.Fatal("Expected run to belong to a chain")
+	}
+	waitForRun(t, db, "report")
+
+	mu.Lock()
+	got := strings.Join(order, ",")
+	mu.Unlock()
+	if got != "backup,cleanup,report" {
+		t.Errorf("Expected backup,cleanup,report, got %s", got)
+	}
+
+	chains, total, err := db.ListTaskChains("cleanup", 10, 0)
+	if err != nil {
+		t.Fatalf("ListTaskChains() failed: %v", err)
+	}
+	if total != 1 || len(chains[0].Runs) != 3 {
+		t.Fatalf("Expected 1 chain with 3 runs, got %+v", chains)
+	}
+	waitForChain(t, db, "cleanup")
+
+	// A failed dependency skips the dependent task
+	if _, err := sched.RunTask("after_broken", nil); err != nil {
+		t.Fatalf("RunTask() failed: %v", err)
+	}
+	skipped := waitForRun(t, db, "after_broken")
+	if skipped.Status != database.TaskRunStatusSkipped || skipped.Output != "dependency broken failed" {
+		t.Errorf("Expected skipped run, got %+v", skipped)
+	}
+	if chain := waitForChain(t, db, "after_broken"); chain.Status != database.TaskRunStatusFailed {
+		t.Errorf("Expected failed chain, got %+v", chain)
+	}
+
+	// A failed task triggers its failure follow-ups
+	if _, err := sched.RunTask("nightly", nil); err != nil {
+		t.Fatalf("RunTask() failed: %v", err)
+	}
+	if alert := waitForRun(t, db, "alert"); alert.Status != database.TaskRunStatusSuccess {
+		t.Errorf("Expected alert to run, got %+v", alert)
+	}
+}
+
+// waitForChain polls the database until the last chain of a task has finished
+func waitForChain(t *testing.T, db *database.Database, taskName string) database.TaskChain {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		chains, _, err := db.ListTaskChains(taskName, 1, 0)
+		if err != nil {
+			t.Fatalf("ListTaskChains() failed: %v", err)
+		}
+		if len(chains) > 0 && chains[0].FinishedAt != nil {
+			return chains[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Chain of task %s did not finish in time", taskName)
+	return database.TaskChain{}
+}
+
+func TestTaskLinkValidation(t *testing.T) {
+	db := newTestDatabase(t)
+	cfg := config.DefaultConfig()
+	cfg.Tasks = []config.Task{
+		{Name: "a", Command: "ok", OnSuccess: []string{"b"}},
+		{Name: "b", Command: "ok"},
+	}
+	sched := NewScheduler(cfg, db, nil)
+
+	err := sched.CreateTask(config.Task{Name: "c", Command: "ok", DependsOn: []string{"missing"}})
+	if !errors.Is(err, ErrInvalidTask) {
+		t.Errorf("Expected ErrInvalidTask for unknown link, got %v", err)
+	}
+
+	if err := sched.CreateTask(config.Task{Name: "c", Command: "ok", DependsOn: []string{"a"}}); err != nil {
+		t.Fatalf("CreateTask() failed: %v", err)
+	}
+	err = sched.UpdateTask(config.Task{Name: "c", Command: "ok", DependsOn: []string{"a"}, OnSuccess: []string{"c"}})
+	if !errors.Is(err, ErrInvalidTask) {
+		t.Errorf("Expected ErrInvalidTask for cycle, got %v", err)
+	}
+
+	// Linked tasks can't be deleted until the links are removed
+	if err := sched.CreateTask(config.Task{Name: "d", Command: "ok", OnFailureRun: []string{"c"}}); err != nil {
+		t.Fatalf("CreateTask() failed: %v", err)
+	}
+	if err := sched.DeleteTask("c"); !errors.Is(err, ErrTaskInUse) {
+		t.Errorf("Expected ErrTaskInUse, got %v", err)
+	}
+	if err := sched.UpdateTask(config.Task{Name: "d", Command: "ok"}); err != nil {
+		t.Fatalf("UpdateTask() failed: %v", err)
+	}
+	if err := sched.DeleteTask("c"); err != nil {
+		t.Errorf("DeleteTask() failed: %v", err)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name  string
+		task  config.Task
+		valid bool
+	}{
+		{"valid", config.Task{Name: "a", Command: "ok", Schedule: "@daily", Overlap: OverlapSkip, CatchUp: CatchUpOnce}, true},
+		{"overlap", config.Task{Name: "a", Command: "ok", Overlap: "skp"}, false},
+		{"catch-up", config.Task{Name: "a", Command: "ok", CatchUp: "every"}, false},
+		{"failure policy", config.Task{Name: "a", Command: "ok", OnFailure: "stop"}, false},
+		{"retries", config.Task{Name: "a", Command: "ok", Retries: -1}, false},
+		{"schedule", config.Task{Name: "a", Command: "ok", Schedule: "daily"}, false},
+		{"sun schedule", config.Task{Name: "a", Command: "ok", Schedule: "@sunrise"}, false},
+		{"params", config.Task{Name: "a", Command: "ok", Params: []config.TaskParam{{Name: "a", Type: "date"}}}, false},
+		{"output path", config.Task{Name: "a", Command: "ok", OutputPath: "$.value"}, false},
+		{"http", config.Task{Name: "a", Command: command.HTTPCommandName}, false},
+		{"nice", config.Task{Name: "a", Command: command.ShellCommandName, Args: []string{"true"}, Nice: 40}, false},
+		{"agent", config.Task{Name: "a", Command: "ok", Agent: "garage"}, false},
+		{"command", config.Task{Name: "a"}, false},
+	}
+	for _, test := range tests {
+		cfg := config.DefaultConfig()
+		cfg.Tasks = []config.Task{test.task}
+		if err := ValidateConfig(cfg); (err == nil) != test.valid {
+			t.Errorf("%s: ValidateConfig() = %v; expected valid %v", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestScheduleTimezones(t *testing.T) {
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		t.Skipf("Timezone data not available: %v", err)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.Timezone = "UTC"
+	sched := NewScheduler(cfg, nil, nil)
+
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		task     config.Task
+		expected time.Time
+	}{
+		{
+			name:     "default timezone",
+			task:     config.Task{Schedule: "0 3 * * *"},
+			expected: time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "task timezone",
+			task:     config.Task{Schedule: "0 3 * * *", Timezone: "Europe/Moscow"},
+			expected: time.Date(2024, 1, 2, 3, 0, 0, 0, moscow),
+		},
+		{
+			name:     "seconds field",
+			task:     config.Task{Schedule: "30 0 3 * * *"},
+			expected: time.Date(2024, 1, 1, 3, 0, 30, 0, time.UTC),
+		},
+		{
+			name:     "interval",
+			task:     config.Task{Schedule: "@every 30s", Timezone: "Europe/Moscow"},
+			expected: from.Add(30 * time.Second),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := sched.taskSchedule(tt.task)
+			if err != nil {
+				t.Fatalf("taskSchedule() failed: %v", err)
+			}
+			if next := schedule.Next(from); !next.Equal(tt.expected) {
+				t.Errorf("Expected next run at %s, got %s", tt.expected, next)
+			}
+		})
+	}
+
+	if tz := sched.EffectiveTimezone(config.Task{}); tz != "UTC" {
+		t.Errorf("Expected effective timezone UTC, got %s", tz)
+	}
+	err = sched.CreateTask(config.Task{Name: "bad_tz", Command: "ok", Schedule: "@hourly", Timezone: "Mars/Olympus"})
+	if !errors.Is(err, ErrInvalidTask) {
+		t.Errorf("Expected ErrInvalidTask for unknown timezone, got %v", err)
+	}
+}
+
+func TestAstroSchedules(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Timezone = "UTC"
+	sched := NewScheduler(cfg, nil, nil)
+
+	if err := sched.ValidateSchedule("sunset-30m"); err == nil {
+		t.Error("Expected error without configured coordinates")
+	}
+
+	cfg.Latitude, cfg.Longitude = 0, 0.001
+	for _, spec := range []string{"sunset", "sunset-30m", "Sunrise + 1h15m", "dusk+5m"} {
+		if err := sched.ValidateSchedule(spec); err != nil {
+			t.Errorf("ValidateSchedule(%q) failed: %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"sunset-", "sunset*2", "sunrise+25h", "moonrise"} {
+		if err := sched.ValidateSchedule(spec); err == nil {
+			t.Errorf("Expected ValidateSchedule(%q) to fail", spec)
+		}
+	}
+
+	schedule, err := sched.taskSchedule(config.Task{Schedule: "sunset-30m"})
+	if err != nil {
+		t.Fatalf("taskSchedule() failed: %v", err)
+	}
+
+	// At the equator the sun sets around 18:10 UTC on the equinox
+	from := time.Date(2024, 3, 20, 12, 0, 0, 0, time.UTC)
+	first := schedule.Next(from)
+	if first.Day() != 20 || first.Hour() != 17 || first.Minute() < 30 || first.Minute() > 50 {
+		t.Errorf("Expected first run around 17:40 on March 20, got %s", first)
+	}
+	second := schedule.Next(first)
+	if second.Day() != 21 || second.Sub(first) < 23*time.Hour || second.Sub(first) > 25*time.Hour {
+		t.Errorf("Expected the next run one day later, got %s", second)
+	}
+}
+
+func TestNextRuns(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Tasks = []config.Task{
+		{Name: "hourly", Schedule: "0 * * * *", Enabled: true, Command: "ok"},
+		{Name: "manual", Command: "ok"},
+	}
+	sched := NewScheduler(cfg, nil, nil)
+	sched.Start()
+	defer sched.Stop()
+
+	next, err := sched.NextRuns("hourly", 3)
+	if err != nil {
+		t.Fatalf("NextRuns() failed: %v", err)
+	}
+	if len(next) != 3 {
+		t.Fatalf("Expected 3 fire times, got %v", next)
+	}
+	for i, at := range next {
+		if at.Minute() != 0 || !at.After(time.Now()) {
+			t.Errorf("Unexpected fire time %s", at)
+		}
+		if i > 0 && at.Sub(next[i-1]) != time.Hour {
+			t.Errorf("Expected hourly fire times, got %v", next)
+		}
+	}
+
+	if next, err := sched.NextRuns("manual", 3); err != nil || len(next) != 0 {
+		t.Errorf("Expected no fire times for an unscheduled task, got %v, %v", next, err)
+	}
+	if _, err := sched.NextRuns("missing", 3); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("Expected ErrTaskNotFound, got %v", err)
+	}
+
+	preview, err := sched.PreviewSchedule("@every 90s", "", 2)
+	if err != nil || len(preview) != 2 || preview[1].Sub(preview[0]) != 90*time.Second {
+		t.Errorf("Unexpected preview %v, %v", preview, err)
+	}
+	if _, err := sched.PreviewSchedule("0 25 * * *", "", 2); err == nil {
+		t.Error("Expected error for invalid schedule")
+	}
+}
+
+func TestMissedRuns(t *testing.T) {
+	schedule, err := scheduleParser.Parse("0 * * * *")
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	now := time.Date(2024, 1, 2, 12, 30, 0, 0, time.UTC)
+	if missed := missedRuns(schedule, now.Add(-3*time.Hour), now, 0); len(missed) != 3 {
+		t.Errorf("Expected 3 missed runs, got %v", missed)
+	}
+	if missed := missedRuns(schedule, now.Add(-3*time.Hour), now, 90*time.Minute); len(missed) != 1 {
+		t.Errorf("Expected lookback to limit missed runs to 1, got %v", missed)
+	}
+	if missed := missedRuns(schedule, now.Add(-10*time.Minute), now, 0); len(missed) != 0 {
+		t.Errorf("Expected no missed runs, got %v", missed)
+	}
+}
+
+func TestCatchUpOnStart(t *testing.T) {
+	db := newTestDatabase(t)
+	cfg := config.DefaultConfig()
+	cfg.Tasks = []config.Task{
+		{Name: "all", Schedule: "0 * * * *", Enabled: true, Command: "ok", CatchUp: CatchUpAll},
+		{Name: "once", Schedule: "0 * * * *", Enabled: true, Command: "ok", CatchUp: CatchUpOnce},
+		{Name: "none", Schedule: "0 * * * *", Enabled: true, Command: "ok"},
+		{Name: "new", Schedule: "0 * * * *", Enabled: true, Command: "ok", CatchUp: CatchUpAll},
+		{Name: "failing", Schedule: "0 * * * *", Enabled: true, Command: "fail", CatchUp: CatchUpOnce},
+	}
+
+	// Three hourly windows were missed, whatever the current minute
+	lastFired := time.Now().Truncate(time.Hour).Add(-3*time.Hour + time.Minute)
+	for _, name := range []string{"all", "once", "none", "failing"} {
+		if err := db.SetTaskLastFired(name, lastFired); err != nil {
+			t.Fatalf("SetTaskLastFired() failed: %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	runs := make(map[string]int)
+	sched := NewScheduler(cfg, db, func(ctx context.Context, task config.Task) (*command.Result, error) {
+		mu.Lock()
+		runs[task.Name]++
+		mu.Unlock()
+		if task.Command == "fail" {
+			return &command.Result{ExitCode: 1}, errors.New("exit status 1")
+		}
+		return &command.Result{}, nil
+	})
+	sched.Start()
+	defer sched.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		done := runs["all"] == 3 && runs["once"] == 1 && runs["failing"] == 1
+		mu.Unlock()
+		if done {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if runs["all"] != 3 || runs["once"] != 1 || runs["none"] != 0 || runs["new"] != 0 {
+		t.Errorf("Unexpected catch-up runs: %v", runs)
+	}
+
+	last := waitForRun(t, db, "once")
+	if last.Trigger != database.TriggerCatchUp {
+		t.Errorf("Expected catch-up trigger, got %s", last.Trigger)
+	}
+	waitForRun(t, db, "failing")
+	for _, name := range []string{"once", "new"} {
+		// The fire time is recorded once the run succeeded
+		fired, err := db.GetTaskLastFired(name)
+		for deadline := time.Now().Add(time.Second); err == nil && fired != nil && fired.Before(lastFired.Add(time.Hour)) && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+			fired, err = db.GetTaskLastFired(name)
+		}
+		if err != nil || fired == nil || fired.Before(lastFired.Add(time.Hour)) {
+			t.Errorf("Expected fire time of %s to be updated, got %v, %v", name, fired, err)
+		}
+	}
+	// Failed runs don't count, so their windows are caught up again
+	if fired, err := db.GetTaskLastFired("failing"); err != nil || fired == nil || fired.After(lastFired.Add(time.Second)) {
+		t.Errorf("Expected fire time of failing to be unchanged, got %v, %v", fired, err)
+	}
+}
+
+func TestCancelAndTimeout(t *testing.T) {
+	db := newTestDatabase(t)
+	cfg := config.DefaultConfig()
+	cfg.Scheduler.Workers = 1
+	cfg.Tasks = []config.Task{
+		{Name: "long", Command: "block", Retries: 3, RetryBackoff: time.Hour},
+		{Name: "waiting", Command: "block"},
+		{Name: "slow", Command: "block", Timeout: 50 * time.Millisecond},
+	}
+
+	started := make(chan string, 10)
+	sched := NewScheduler(cfg, db, func(ctx context.Context, task config.Task) (*command.Result, error) {
+		started <- task.Name
+		<-ctx.Done()
+		return &command.Result{ExitCode: -1}, ctx.Err()
+	})
+
+	if _, err := sched.CancelTask("long"); !errors.Is(err, ErrTaskNotRunning) {
+		t.Errorf("Expected ErrTaskNotRunning, got %v", err)
+	}
+
+	if _, err := sched.RunTask("long", nil); err != nil {
+		t.Fatalf("RunTask() failed: %v", err)
+	}
+	<-started
+	// Waits for the single worker
+	if _, err := sched.RunTask("waiting", nil); err != nil {
+		t.Fatalf("RunTask() failed: %v", err)
+	}
+
+	for _, name := range []string{"waiting", "long"} {
+		if n, err := sched.CancelTask(name); err != nil || n != 1 {
+			t.Fatalf("CancelTask(%s) = %d, %v", name, n, err)
+		}
+		run := waitForRun(t, db, name)
+		if run.Status != database.TaskRunStatusCancelled {
+			t.Errorf("Expected cancelled run of %s, got %+v", name, run)
+		}
+	}
+	if runs, _, _ := db.ListTaskRuns("long", 10, 0); len(runs) != 1 {
+		t.Errorf("Expected a cancelled run not to be retried, got %+v", runs)
+	}
+
+	if _, err := sched.RunTask("slow", nil); err != nil {
+		t.Fatalf("RunTask() failed: %v", err)
+	}
+	run := waitForRun(t, db, "slow")
+	if run.Status != database.TaskRunStatusFailed || !strings.Contains(run.Output, "timed out after 50ms") {
+		t.Errorf("Expected timed out run, got %+v", run)
+	}
+}
+
+func TestStopCancelsRuns(t *testing.T) {
+	db := newTestDatabase(t)
+	cfg := config.DefaultConfig()
+	cfg.Scheduler.ShutdownGrace = time.Second
+	cfg.Tasks = []config.Task{{Name: "long", Command: "block"}}
+
+	started := make(chan struct{})
+	sched := NewScheduler(cfg, db, func(ctx context.Context, task config.Task) (*command.Result, error) {
+		close(started)
+		<-ctx.Done()
+		return &command.Result{}, ctx.Err()
+	})
+	sched.Start()
+
+	if _, err := sched.RunTask("long", nil); err != nil {
+		t.Fatalf("RunTask() failed: %v", err)
+	}
+	<-started
+
+	begin := time.Now()
+	sched.Stop()
+	if elapsed := time.Since(begin); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected Stop to return once the run was cancelled, took %s", elapsed)
+	}
+	if run := waitForRun(t, db, "long"); run.Status != database.TaskRunStatusCancelled {
+		t.Errorf("Expected cancelled run, got %+v", run)
+	}
+	if _, err := sched.RunTask("long", nil); !errors.Is(err, ErrSchedulerStopped) {
+		t.Errorf("Expected ErrSchedulerStopped, got %v", err)
+	}
+}
+
+func TestSubscribeRun(t *testing.T) {
+	db := newTestDatabase(t)
+	cfg := config.DefaultConfig()
+	cfg.Tasks = []config.Task{
+		{Name: "streaming", Command: "stream"},
+		{Name: "plain", Command: "plain"},
+	}
+
+	release := make(chan struct{})
+	sched := NewScheduler(cfg, db, func(ctx context.Context, task config.Task) (*command.Result, error) {
+		if task.Name == "plain" {
+			<-release
+			return &command.Result{Output: "a\nb\n"}, nil
+		}
+		sink := command.OutputSinkFrom(ctx)
+		sink(command.Stdout, "first")
+		<-release
+		sink(command.Stderr, "second")
+		return &command.Result{ExitCode: 3}, errors.New("failed")
+	})
+
+	collect := func(name string) []RunEvent {
+		run, err := sched.RunTask(name, nil)
+		if err != nil {
+			t.Fatalf("RunTask() failed: %v", err)
+		}
+
+		var history []RunEvent
+		var events <-chan RunEvent
+		var unsubscribe func()
+		deadline := time.Now().Add(5 * time.Second)
+		for ok := false; !ok; {
+			if time.Now().After(deadline) {
+				t.Fatalf("Run of %s did not start", name)
+			}
+			history, events, unsubscribe, ok = sched.SubscribeRun(run.ID)
+			time.Sleep(5 * time.Millisecond)
+		}
+		defer unsubscribe()
+
+		// Wait for the first line of streaming commands before releasing them
+		for name == "streaming" && len(history) == 0 {
+			history = append(history, <-events)
+		}
+		release <- struct{}{}
+		for event := range events {
+			history = append(history, event)
+		}
+		return history
+	}
+
+	events := collect("streaming")
+	if len(events) != 3 || events[0].Line != "first" || events[1].Stream != command.Stderr {
+		t.Fatalf("Unexpected events %+v", events)
+	}
+	if last := events[2]; last.Type != RunEventStatus || last.Status != database.TaskRunStatusFailed || last.ExitCode != 3 {
+		t.Errorf("Unexpected status event %+v", last)
+	}
+
+	events = collect("plain")
+	if len(events) != 3 || events[0].Line != "a" || events[0].Stream != "" || events[1].Line != "b" || events[2].Status != database.TaskRunStatusSuccess {
+		t.Errorf("Expected stored output to be sent at the end, got %+v", events)
+	}
+}
+
+func TestParameterizedRuns(t *testing.T) {
+	db := newTestDatabase(t)
+	cfg := config.DefaultConfig()
+	cfg.Tasks = []config.Task{
+		{
+			Name:    "ping",
+			Command: "shell",
+			Args:    []string{"ping", "-c", "{{.count}}", "{{.host}}"},
+			Env:     map[string]string{"VERBOSE": "{{.verbose}}"},
+			Params: []config.TaskParam{
+				{Name: "host", Required: true},
+				{Name: "count", Type: ParamInt, Default: 3},
+				{Name: "verbose", Type: ParamBool},
+			},
+		},
+		{Name: "plain", Command: "shell"},
+	}
+
+	executed := make(chan config.Task, 1)
+	sched := NewScheduler(cfg, db, func(ctx context.Context, task config.Task) (*command.Result, error) {
+		executed <- task
+		return &command.Result{}, nil
+	})
+
+	for _, args := range []map[string]any{
+		nil,
+		{"host": "router", "count": "five"},
+		{"host": "router", "count": 1.5},
+		{"host": "router", "port": 22},
+	} {
+		if _, err := sched.RunTask("ping", args); !errors.Is(err, ErrInvalidArgs) {
+			t.Errorf("RunTask(%v): expected ErrInvalidArgs, got %v", args, err)
+		}
+	}
+	if _, err := sched.RunTask("plain", map[string]any{"host": "router"}); !errors.Is(err, ErrInvalidArgs) {
+		t.Errorf("Expected ErrInvalidArgs for a task without parameters, got %v", err)
+	}
+
+	if _, err := sched.RunTask("ping", map[string]any{"host": "router", "count": float64(5)}); err != nil {
+		t.Fatalf("RunTask() failed: %v", err)
+	}
+	task := <-executed
+	if got := strings.Join(task.Args, " "); got != "ping -c 5 router" {
+		t.Errorf("Expected rendered args, got %q", got)
+	}
+	if task.Env["VERBOSE"] != "false" {
+		t.Errorf("Expected rendered env, got %v", task.Env)
+	}
+	if info, _ := sched.GetTask("ping"); info.Args[2] != "{{.count}}" {
+		t.Errorf("Expected the task definition to be unchanged, got %v", info.Args)
+	}
+
+	run := waitForRun(t, db, "ping")
+	if run.Params["host"] != "router" || run.Params["count"] != float64(5) || run.Params["verbose"] != false {
+		t.Errorf("Expected recorded params, got %+v", run.Params)
+	}
+}
+
+func TestParamValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		task  config.Task
+		valid bool
+	}{
+		{"no params", config.Task{Args: []string{"{{.host}}"}}, true},
+		{"valid", config.Task{Args: []string{"{{.host}}"}, Params: []config.TaskParam{{Name: "host"}}}, true},
+		{"bad name", config.Task{Params: []config.TaskParam{{Name: "my-host"}}}, false},
+		{"duplicate", config.Task{Params: []config.TaskParam{{Name: "a"}, {Name: "a"}}}, false},
+		{"unknown type", config.Task{Params: []config.TaskParam{{Name: "a", Type: "date"}}}, false},
+		{"bad default", config.Task{Params: []config.TaskParam{{Name: "a", Type: ParamBool, Default: "yes"}}}, false},
+		{"bad template", config.Task{Args: []string{"{{.a"}, Params: []config.TaskParam{{Name: "a"}}}, false},
+		{"scheduled required", config.Task{Schedule: "@daily", Params: []config.TaskParam{{Name: "a", Required: true}}}, false},
+		{"scheduled default", config.Task{Schedule: "@daily", Params: []config.TaskParam{{Name: "a", Required: true, Default: "x"}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateParams(tt.task)
+			if (err == nil) != tt.valid {
+				t.Errorf("validateParams() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestHTTPTaskRuns(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	db := newTestDatabase(t)
+	cfg := config.DefaultConfig()
+	cfg.Tasks = []config.Task{
+		{
+			Name:    "hook",
+			Command: command.HTTPCommandName,
+			HTTP:    &config.TaskHTTP{URL: server.URL + "/{{.device}}", Body: `{"device":"{{.device}}"}`},
+			Params:  []config.TaskParam{{Name: "device", Default: "lamp"}},
+		},
+	}
+	sched := NewScheduler(cfg, db, command.NewRegistry().Execute)
+
+	if _, err := sched.RunTask("hook", nil); err != nil {
+		t.Fatalf("RunTask() failed: %v", err)
+	}
+	run := waitForRun(t, db, "hook")
+	if run.Status != database.TaskRunStatusSuccess || run.HTTPStatus == nil || *run.HTTPStatus != http.StatusAccepted {
+		t.Errorf("Expected successful run with HTTP status, got %+v", run)
+	}
+	if !strings.Contains(run.Output, "POST "+server.URL+"/lamp: 202 Accepted\n{\"device\":\"lamp\"}") {
+		t.Errorf("Expected rendered request in output, got %q", run.Output)
+	}
+
+	if err := sched.CreateTask(config.Task{Name: "broken", Command: command.HTTPCommandName}); !errors.Is(err, ErrInvalidTask) {
+		t.Errorf("Expected ErrInvalidTask for a request without url, got %v", err)
+	}
+}
+
+func TestSchedulerLeaderLease(t *testing.T) {
+	db := newTestDatabase(t)
+	cfg := config.DefaultConfig()
+	cfg.Scheduler.LeaseTTL = 150 * time.Millisecond
+	cfg.Tasks = []config.Task{
+		{Name: "tick", Schedule: "@every 1s", Enabled: true, Command: "ok"},
+	}
+
+	var mu sync.Mutex
+	fired := make(map[*Scheduler]int)
+	newScheduler := func() *Scheduler {
+		var sched *Scheduler
+		sched = NewScheduler(cfg, db, func(ctx context.Context, task config.Task) (*command.Result, error) {
+			mu.Lock()
+			fired[sched]++
+			mu.Unlock()
+			return &command.Result{}, nil
+		})
+		return sched
+	}
+
+	first, second := newScheduler(), newScheduler()
+	first.Start()
+	second.Start()
+	defer second.Stop()
+
+	if !first.isLeader() || second.isLeader() {
+		t.Fatal("Expected the first scheduler to become the leader")
+	}
+	status := second.LeaderStatus()
+	if status.Leader || status.LeaderInstance != first.instanceID || status.LeaseExpiresAt == nil {
+		t.Errorf("Unexpected leader status %+v", status)
+	}
+
+	// The lease is renewed, the standby doesn't take over
+	time.Sleep(1100 * time.Millisecond)
+	if !first.isLeader() || second.isLeader() {
+		t.Error("Expected the first scheduler to keep the lease")
+	}
+	mu.Lock()
+	if fired[first] == 0 || fired[second] != 0 {
+		t.Errorf("Expected only the leader to run scheduled tasks, got %d and %d runs", fired[first], fired[second])
+	}
+	mu.Unlock()
+
+	first.Stop()
+	deadline := time.Now().Add(2 * time.Second)
+	for !second.isLeader() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !second.isLeader() {
+		t.Fatal("Expected the second scheduler to take over")
+	}
+	if status := second.LeaderStatus(); !status.Leader || status.LeaderInstance != second.instanceID {
+		t.Errorf("Unexpected leader status %+v", status)
+	}
+}
+
+func TestLeaseRenewalAfterReload(t *testing.T) {
+	db := newTestDatabase(t)
+	cfg := config.DefaultConfig()
+	cfg.Scheduler.LeaseTTL = 3 * time.Second
+	sched := NewScheduler(cfg, db, nil)
+	sched.Start()
+	defer sched.Stop()
+	// Let the renewal start with the interval of the first TTL
+	time.Sleep(50 * time.Millisecond)
+
+	next := *cfg
+	next.Scheduler.LeaseTTL = 150 * time.Millisecond
+	if _, err := sched.Reload(&next); err != nil {
+		t.Fatalf("Reload() failed: %v", err)
+	}
+
+	// After the next renewal at the old interval the lease is renewed at the
+	// new one, a third of the TTL
+	time.Sleep(1200 * time.Millisecond)
+	before, err := db.GetLease(schedulerLease)
+	if err != nil || before == nil {
+		t.Fatalf("GetLease() = %+v, %v", before, err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	after, _ := db.GetLease(schedulerLease)
+	if after == nil || !after.ExpiresAt.After(before.ExpiresAt) {
+		t.Errorf("Expected the lease to be renewed at the new interval, got %+v then %+v", before, after)
+	}
+	if left := time.Until(after.ExpiresAt); left > time.Second {
+		t.Errorf("Expected renewals with the new TTL, got a lease expiring in %v", left)
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	db := newTestDatabase(t)
+	now := time.Now()
+
+	if ok, err := db.AcquireLease("test", "a", time.Minute, now); err != nil || !ok {
+		t.Fatalf("AcquireLease(a) = %v, %v", ok, err)
+	}
+	if ok, _ := db.AcquireLease("test", "b", time.Minute, now.Add(30*time.Second)); ok {
+		t.Error("Expected a held lease not to be taken over")
+	}
+	if ok, _ := db.AcquireLease("test", "a", time.Minute, now.Add(30*time.Second)); !ok {
+		t.Error("Expected the holder to renew its lease")
+	}
+	if ok, _ := db.AcquireLease("test", "b", time.Minute, now.Add(2*time.Minute)); !ok {
+		t.Error("Expected an expired lease to be taken over")
+	}
+
+	lease, err := db.GetLease("test")
+	if err != nil || lease == nil || lease.Holder != "b" {
+		t.Fatalf("GetLease() = %+v, %v", lease, err)
+	}
+	if !lease.AcquiredAt.Equal(now.Add(2 * time.Minute).Truncate(time.Millisecond)) {
+		t.Errorf("Expected acquisition time of the takeover, got %v", lease.AcquiredAt)
+	}
+	if err := db.ReleaseLease("test", "a"); err != nil {
+		t.Fatalf("ReleaseLease() failed: %v", err)
+	}
+	if lease, _ := db.GetLease("test"); lease == nil {
+		t.Error("Expected release by a non-holder to keep the lease")
+	}
+}
+
+func TestExtractJSON(t *testing.T) {
+	data := `{"devices": [{"name": "lamp", "on": true}, {"name": "fan", "power": 12.5}], "count": 2}`
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{path: "$", want: `{"count":2,"devices":[{"name":"lamp","on":true},{"name":"fan","power":12.5}]}`},
+		{path: "count", want: "2"},
+		{path: "$.devices[0].name", want: "lamp"},
+		{path: "devices.1.power", want: "12.5"},
+		{path: "devices[0]", want: `{"name":"lamp","on":true}`},
+		{path: "devices[2]", wantErr: true},
+		{path: "count.value", wantErr: true},
+		{path: "missing", wantErr: true},
+		{path: "devices[x]", wantErr: true},
+		{path: "devices..name", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := extractJSON(data, tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("extractJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("extractJSON() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := extractJSON("not json", "count"); err == nil {
+		t.Error("Expected error for invalid JSON")
+	}
+}
+
+func TestPublishResult(t *testing.T) {
+	db := newTestDatabase(t)
+	cfg := config.DefaultConfig()
+	cfg.Tasks = []config.Task{
+		{Name: "devices", Command: "shell", OutputKey: "devices/online"},
+		{Name: "lamp", Command: "shell", OutputKey: "devices/lamp", OutputPath: "$.state"},
+	}
+
+	var mu sync.Mutex
+	output := map[string]string{"devices": "3\n", "lamp": `{"state": "on"}`}
+	sched := NewScheduler(cfg, db, func(ctx context.Context, task config.Task) (*command.Result, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return &command.Result{Output: "log line\n" + output[task.Name], Data: output[task.Name], Truncated: task.Name == "big"}, nil
+	})
+
+	run := func(name string) database.TaskRun {
+		t.Helper()
+		if _, err := sched.RunTask(name, nil); err != nil {
+			t.Fatalf("RunTask() failed: %v", err)
+		}
+		return waitForRun(t, db, name)
+	}
+
+	run("devices")
+	run("lamp")
+	for key, want := range map[string]string{"devices/online": "3", "devices/lamp": "on"} {
+		kv, err := db.GetKeyValue(key)
+		if err != nil || kv == nil || kv.Value != want || kv.Status != models.StatusUnread {
+			t.Errorf("Expected unread %s = %q, got %+v, %v", key, want, kv, err)
+		}
+	}
+
+	// A changed value is marked unread again, an unchanged one is left alone
+	db.UpdateKeyValueStatus("devices/online", models.StatusRead, database.WriteOptions{})
+	db.UpdateKeyValueStatus("devices/lamp", models.StatusRead, database.WriteOptions{})
+	mu.Lock()
+	output["lamp"] = `{"state": "off"}`
+	mu.Unlock()
+	run("devices")
+	run("lamp")
+	if kv, _ := db.GetKeyValue("devices/online"); kv.Status != models.StatusRead {
+		t.Errorf("Expected unchanged value to stay read, got %+v", kv)
+	}
+	if kv, _ := db.GetKeyValue("devices/lamp"); kv.Value != "off" || kv.Status != models.StatusUnread {
+		t.Errorf("Expected changed value to be unread, got %+v", kv)
+	}
+	// The new value and the unread status are one version in the history
+	if versions, _ := db.ListKeyValueHistory("devices/lamp"); len(versions) != 3 || versions[0].Value != "off" || versions[0].Status != models.StatusUnread {
+		t.Errorf("Expected the publish to be recorded as one version, got %+v", versions)
+	}
+
+	mu.Lock()
+	output["lamp"] = "not json"
+	mu.Unlock()
+	if r := run("lamp"); r.Status != database.TaskRunStatusFailed || !strings.Contains(r.Output, "failed to extract output") {
+		t.Errorf("Expected failed run for invalid JSON, got %+v", r)
+	}
+
+	// Result data cut off at the limit is not published
+	mu.Lock()
+	output["big"] = "dimmed"
+	mu.Unlock()
+	if err := sched.CreateTask(config.Task{Name: "big", Command: "shell", OutputKey: "devices/lamp"}); err != nil {
+		t.Fatalf("CreateTask() failed: %v", err)
+	}
+	if r := run("big"); r.Status != database.TaskRunStatusFailed || !strings.Contains(r.Output, "exceeds") {
+		t.Errorf("Expected failed run for truncated data, got %+v", r)
+	}
+	if kv, _ := db.GetKeyValue("devices/lamp"); kv.Value != "off" {
+		t.Errorf("Expected truncated data not to be published, got %+v", kv)
+	}
+
+	// Typed values are checked against the output type and the key schema
+	if err := sched.CreateTask(config.Task{Name: "power", Command: "shell", OutputKey: "sensors/power", OutputType: models.ValueTypeNumber}); err != nil {
+		t.Fatalf("CreateTask() failed: %v", err)
+	}
+	if _, err := db.SetKeyValueSchema("sensors/", json.RawMessage(`{"type": "number", "maximum": 100}`)); err != nil {
+		t.Fatalf("SetKeyValueSchema() failed: %v", err)
+	}
+	mu.Lock()
+	output["power"] = "12.5\n"
+	mu.Unlock()
+	run("power")
+	if kv, _ := db.GetKeyValue("sensors/power"); kv == nil || kv.Value != "12.5" || kv.ValueType != models.ValueTypeNumber {
+		t.Errorf("Expected number sensors/power = 12.5, got %+v", kv)
+	}
+	for _, out := range []string{"off", "250"} {
+		mu.Lock()
+		output["power"] = out
+		mu.Unlock()
+		if r := run("power"); r.Status != database.TaskRunStatusFailed || !strings.Contains(r.Output, "failed to publish output") {
+			t.Errorf("Expected failed run for %q, got %+v", out, r)
+		}
+	}
+	if kv, _ := db.GetKeyValue("sensors/power"); kv.Value != "12.5" {
+		t.Errorf("Expected rejected values not to be published, got %+v", kv)
+	}
+
+	if err := sched.CreateTask(config.Task{Name: "bad", Command: "shell", OutputPath: "$.x"}); !errors.Is(err, ErrInvalidTask) {
+		t.Errorf("Expected ErrInvalidTask for output_path without output_key, got %v", err)
+	}
+	if err := sched.CreateTask(config.Task{Name: "bad", Command: "shell", OutputKey: "devices/lamp/history"}); !errors.Is(err, ErrInvalidTask) {
+		t.Errorf("Expected ErrInvalidTask for an output_key the API can't address, got %v", err)
+	}
+	if err := sched.CreateTask(config.Task{Name: "bad", Command: "shell", OutputKey: "devices/lamp", OutputType: "text"}); !errors.Is(err, ErrInvalidTask) {
+		t.Errorf("Expected ErrInvalidTask for an unknown output_type, got %v", err)
+	}
+}
+
+func TestMaintenanceWindows(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Timezone = "UTC"
+	cfg.Scheduler.Maintenance = []config.MaintenanceWindow{
+		{Name: "nightly", Schedule: "0 2 * * *", Duration: time.Hour},
+		{Name: "interval", Schedule: "@every 1h", Duration: time.Minute},
+	}
+	sched := NewScheduler(cfg, nil, nil)
+	if len(sched.maintenance) != 1 {
+		t.Fatalf("Expected the interval window to be ignored, got %d windows", len(sched.maintenance))
+	}
+
+	day := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		at     time.Duration
+		active bool
+	}{
+		{time.Hour + 59*time.Minute, false},
+		{2 * time.Hour, true},
+		{2*time.Hour + 30*time.Minute, true},
+		{3 * time.Hour, false},
+	}
+	for _, tt := range tests {
+		if _, active := sched.inMaintenance(day.Add(tt.at)); active != tt.active {
+			t.Errorf("inMaintenance(%s) = %v, want %v", day.Add(tt.at).Format("15:04"), active, tt.active)
+		}
+	}
+
+	missed := sched.outsideMaintenance([]time.Time{day.Add(time.Hour), day.Add(2 * time.Hour), day.Add(4 * time.Hour)})
+	if len(missed) != 2 {
+		t.Errorf("Expected the missed run during maintenance to be dropped, got %v", missed)
+	}
+}
+
+func TestPauseAndMaintenanceSkipRuns(t *testing.T) {
+	db := newTestDatabase(t)
+	cfg := config.DefaultConfig()
+	cfg.Tasks = []config.Task{
+		{Name: "tick", Schedule: "* * * * * *", Enabled: true, Command: "ok"},
+	}
+	sched := NewScheduler(cfg, db, func(ctx context.Context, task config.Task) (*command.Result, error) {
+		return &command.Result{}, nil
+	})
+
+	if err := sched.Pause(); err != nil {
+		t.Fatalf("Pause() failed: %v", err)
+	}
+	if status := NewScheduler(cfg, db, nil).Status(); !status.Paused || status.PausedAt == nil {
+		t.Errorf("Expected the paused state to be persisted, got %+v", status)
+	}
+
+	sched.Start()
+	defer sched.Stop()
+	run := waitForRun(t, db, "tick")
+	if run.Status != database.TaskRunStatusSkipped || run.Output != "skipped (paused)" {
+		t.Errorf("Expected run skipped while paused, got %+v", run)
+	}
+	if _, err := sched.RunTask("tick", nil); err != nil {
+		t.Errorf("Expected manual runs while paused, got %v", err)
+	}
+
+	if err := sched.Resume(); err != nil {
+		t.Fatalf("Resume() failed: %v", err)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		runs, _, _ := db.ListTaskRuns("tick", 1, 0)
+		if len(runs) == 1 && runs[0].Trigger == database.TriggerCron && runs[0].Status == database.TaskRunStatusSuccess {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected scheduled runs after resuming, got %+v", runs)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// A window that is always active
+	cfg.Scheduler.Maintenance = []config.MaintenanceWindow{{Name: "works", Schedule: "* * * * *", Duration: time.Hour}}
+	other := NewScheduler(cfg, newTestDatabase(t), nil)
+	other.Start()
+	defer other.Stop()
+	run = waitForRun(t, other.db, "tick")
+	if run.Status != database.TaskRunStatusSkipped || run.Output != "skipped (maintenance)" {
+		t.Errorf("Expected run skipped during maintenance, got %+v", run)
+	}
+	if status := other.Status(); len(status.Maintenance) != 1 || !status.Maintenance[0].Active {
+		t.Errorf("Expected an active maintenance window, got %+v", status.Maintenance)
+	}
+}
+
+func TestReloadReschedulesChangedTasks(t *testing.T) {
+	db := newTestDatabase(t)
+	cfg := config.DefaultConfig()
+	cfg.Tasks = []config.Task{
+		{Name: "same", Schedule: "@hourly", Enabled: true, Command: "ok"},
+		{Name: "moved", Schedule: "@hourly", Enabled: true, Command: "block"},
+		{Name: "gone", Schedule: "@hourly", Enabled: true, Command: "ok"},
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	sched := NewScheduler(cfg, db, func(ctx context.Context, task config.Task) (*command.Result, error) {
+		if task.Command == "block" {
+			close(started)
+			<-release
+		}
+		return &command.Result{}, nil
+	})
+	if err := sched.CreateTask(config.Task{Name: "stored", Schedule: "@daily", Enabled: true, Command: "ok"}); err != nil {
+		t.Fatalf("CreateTask() failed: %v", err)
+	}
+	sched.Start()
+	defer sched.Stop()
+
+	if _, err := sched.RunTask("moved", nil); err != nil {
+		t.Fatalf("RunTask() failed: %v", err)
+	}
+	<-started
+
+	sched.mu.Lock()
+	sameID, storedID := sched.taskIDs["same"], sched.taskIDs["stored"]
+	movedID := sched.taskIDs["moved"]
+	sched.mu.Unlock()
+
+	next := config.DefaultConfig()
+	next.Tasks = []config.Task{
+		{Name: "same", Schedule: "@hourly", Enabled: true, Command: "ok"},
+		{Name: "moved", Schedule: "@daily", Enabled: true, Command: "block"},
+		{Name: "new", Schedule: "@weekly", Enabled: true, Command: "ok"},
+	}
+	invalid := config.DefaultConfig()
+	invalid.Tasks = []config.Task{{Name: "same", Schedule: "@hourly", Enabled: true, Command: "ok", Overlap: "skp"}}
+	if _, err := sched.Reload(invalid); !errors.Is(err, ErrInvalidTask) {
+		t.Errorf("Expected ErrInvalidTask for a misspelled overlap policy, got %v", err)
+	}
+	if _, err := sched.GetTask("gone"); err != nil {
+		t.Errorf("Expected the rejected configuration to leave the tasks alone, got %v", err)
+	}
+
+	summary, err := sched.Reload(next)
+	if err != nil {
+		t.Fatalf("Reload() failed: %v", err)
+	}
+	if len(summary.Added) != 1 || summary.Added[0] != "new" ||
+		len(summary.Removed) != 1 || summary.Removed[0] != "gone" ||
+		len(summary.Changed) != 1 || summary.Changed[0] != "moved" || summary.Unchanged != 1 {
+		t.Errorf("Unexpected reload summary: %+v", summary)
+	}
+
+	sched.mu.Lock()
+	if sched.taskIDs["same"] != sameID || sched.taskIDs["stored"] != storedID {
+		t.Errorf("Expected unchanged tasks to keep their cron entries")
+	}
+	if id, ok := sched.taskIDs["moved"]; !ok || id == movedID {
+		t.Errorf("Expected the changed task to be rescheduled")
+	}
+	if _, ok := sched.taskIDs["gone"]; ok {
+		t.Errorf("Expected the removed task to be unscheduled")
+	}
+	if _, ok := sched.taskIDs["new"]; !ok {
+		t.Errorf("Expected the added task to be scheduled")
+	}
+	sched.mu.Unlock()
+
+	info, err := sched.GetTask("moved")
+	if err != nil || info.Schedule != "@daily" || len(info.Instances) != 1 {
+		t.Errorf("Expected the new definition with the run still in progress, got %+v, %v", info, err)
+	}
+	if _, err := sched.GetTask("gone"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("Expected the removed task to be gone, got %v", err)
+	}
+	close(release)
+	if run := waitForRun(t, db, "moved"); run.Status != database.TaskRunStatusSuccess {
+		t.Errorf("Expected the running job to finish, got %+v", run)
+	}
+}
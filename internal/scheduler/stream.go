@@ -0,0 +1,163 @@
+package scheduler
+
+import (
+	"strings"
+	"sync"
+)
+
+// Run event types
+const (
+	RunEventOutput = "output" // a line of output
+	RunEventStatus = "status" // the final status, always the last event
+)
+
+// maxStreamHistory is the number of output lines kept for late subscribers
+const maxStreamHistory = 1000
+
+// streamBuffer is the number of events buffered per subscriber. Lines are
+// dropped for subscribers that don't keep up.
+const streamBuffer = 256
+
+// RunEvent is a line of output or the final status of a running task run
+type RunEvent struct {
+	Type     string `json:"-"`
+	Stream   string `json:"stream,omitempty"` // "stdout" or "stderr", empty for lines of the stored combined output
+	Line     string `json:"line,omitempty"`
+	Status   string `json:"status,omitempty"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// runStream broadcasts the output of one running attempt to its subscribers
+type runStream struct {
+	history     []RunEvent
+	subscribers map[chan RunEvent]struct{}
+	streamed    bool // the command reported output while running
+	mu          sync.Mutex
+}
+
+func newRunStream() *runStream {
+	return &runStream{subscribers: make(map[chan RunEvent]struct{})}
+}
+
+// write is the output sink of the command
+func (r *runStream) write(stream, line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.streamed = true
+	r.publish(RunEvent{Type: RunEventOutput, Stream: stream, Line: line})
+}
+
+// publish records an event and sends it to the subscribers. The caller must hold r.mu.
+func (r *runStream) publish(event RunEvent) {
+	if event.Type == RunEventOutput {
+		r.history = append(r.history, event)
+		if len(r.history) > maxStreamHistory {
+			r.history = r.history[len(r.history)-maxStreamHistory:]
+		}
+	}
+	for ch := range r.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// finish sends the output of commands that didn't stream it and the final
+// status, then closes all subscriptions. Such output is the combined output
+// of the command, so its lines have no stream.
+func (r *runStream) finish(status string, exitCode int, output string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.streamed {
+		for _, line := range OutputLines(output) {
+			r.publish(RunEvent{Type: RunEventOutput, Line: line})
+		}
+	}
+
+	final := RunEvent{Type: RunEventStatus, Status: status, ExitCode: exitCode}
+	for ch := range r.subscribers {
+		// The status must not be dropped, make room for it
+		select {
+		case ch <- final:
+		default:
+			<-ch
+			ch <- final
+		}
+		close(ch)
+	}
+	r.subscribers = nil
+}
+
+// subscribe returns the output so far and a channel receiving the next events
+func (r *runStream) subscribe() ([]RunEvent, chan RunEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ch := make(chan RunEvent, streamBuffer)
+	history := append([]RunEvent(nil), r.history...)
+	if r.subscribers == nil {
+		// Already finished
+		close(ch)
+		return history, ch
+	}
+	r.subscribers[ch] = struct{}{}
+	return history, ch
+}
+
+// unsubscribe stops sending events to a channel
+func (r *runStream) unsubscribe(ch chan RunEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.subscribers[ch]; ok {
+		delete(r.subscribers, ch)
+		close(ch)
+	}
+}
+
+// startStream registers the output stream of a run attempt
+func (s *Scheduler) startStream(runID int) *runStream {
+	stream := newRunStream()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.streams[runID] = stream
+	return stream
+}
+
+// endStream finishes and forgets the output stream of a run attempt
+func (s *Scheduler) endStream(runID int, stream *runStream, status string, exitCode int, output string) {
+	s.mu.Lock()
+	delete(s.streams, runID)
+	s.mu.Unlock()
+
+	stream.finish(status, exitCode, output)
+}
+
+// SubscribeRun returns the output of a running task run so far and a channel
+// receiving its next events, ending with a status event. The channel is closed
+// when the run finished or unsubscribe is called. It returns false if the run
+// is not running.
+func (s *Scheduler) SubscribeRun(runID int) ([]RunEvent, <-chan RunEvent, func(), bool) {
+	s.mu.Lock()
+	stream, ok := s.streams[runID]
+	s.mu.Unlock()
+	if !ok {
+		return nil, nil, nil, false
+	}
+
+	history, ch := stream.subscribe()
+	return history, ch, func() { stream.unsubscribe(ch) }, true
+}
+
+// OutputLines splits stored output into lines
+func OutputLines(output string) []string {
+	output = strings.TrimSuffix(output, "\n")
+	if output == "" {
+		return nil
+	}
+	return strings.Split(output, "\n")
+}
@@ -0,0 +1,383 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/saintbyte/home-ctrl/internal/command"
+	"github.com/saintbyte/home-ctrl/internal/config"
+	"github.com/saintbyte/home-ctrl/internal/database"
+	"github.com/saintbyte/home-ctrl/internal/database/models"
+	"gopkg.in/yaml.v3"
+)
+
+// loadTasks merges the config-defined tasks with the tasks stored in the database.
+// Config tasks take precedence and only their enabled flag can be overridden.
+func (s *Scheduler) loadTasks() {
+	var overrides map[string]bool
+	if s.db != nil {
+		var err error
+		overrides, err = s.db.GetTaskOverrides()
+		if err != nil {
+			fmt.Printf("Failed to load task overrides: %v\n", err)
+		}
+	}
+
+	for _, task := range s.config.Load().Tasks {
+		if enabled, ok := overrides[task.Name]; ok {
+			task.Enabled = enabled
+		}
+		s.tasks = append(s.tasks, TaskInfo{Task: task, ReadOnly: true})
+	}
+
+	if s.db == nil {
+		return
+	}
+
+	definitions, err := s.db.ListTaskDefinitions()
+	if err != nil {
+		fmt.Printf("Failed to load stored tasks: %v\n", err)
+		return
+	}
+	for _, def := range definitions {
+		if s.findTask(def.Name) >= 0 {
+			fmt.Printf("Ignoring stored task %s: already defined in configuration\n", def.Name)
+			continue
+		}
+
+		task, err := storedTask(def)
+		if err != nil {
+			fmt.Printf("Ignoring stored task %s: %v\n", def.Name, err)
+			continue
+		}
+		s.tasks = append(s.tasks, TaskInfo{Task: task})
+	}
+
+	for _, info := range s.tasks {
+		for _, link := range info.Links() {
+			if s.findTask(link) < 0 {
+				fmt.Printf("Task %s links to unknown task %s\n", info.Name, link)
+			}
+		}
+	}
+}
+
+// storedTask decodes the definition of an API-managed task
+func storedTask(def database.TaskDefinition) (config.Task, error) {
+	var task config.Task
+	if err := yaml.Unmarshal([]byte(def.Definition), &task); err != nil {
+		return config.Task{}, err
+	}
+	task.Name = def.Name
+	return task, nil
+}
+
+// saveTask persists an API-managed task
+func (s *Scheduler) saveTask(task config.Task) error {
+	if s.db == nil {
+		return nil
+	}
+
+	definition, err := yaml.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("failed to encode task: %w", err)
+	}
+	return s.db.SaveTaskDefinition(task.Name, string(definition))
+}
+
+// ValidateSchedule checks that a schedule expression can be parsed
+func (s *Scheduler) ValidateSchedule(schedule string) error {
+	if _, err := s.parseSchedule(schedule, s.config.Load().Location()); err != nil {
+		return fmt.Errorf("invalid schedule %q: %w", schedule, err)
+	}
+	return nil
+}
+
+// validateTask checks a task definition before it is saved.
+// The caller must hold s.mu.
+func (s *Scheduler) validateTask(task config.Task) error {
+	if err := validateTaskIn(s.config.Load(), task); err != nil {
+		return err
+	}
+	return s.validateLinks(task)
+}
+
+// ValidateConfig checks the tasks defined in a configuration like the ones
+// managed through the API, so that a bad configuration is rejected instead
+// of falling back to defaults when the tasks run
+func ValidateConfig(cfg *config.Config) error {
+	for _, task := range cfg.Tasks {
+		if err := validateTaskIn(cfg, task); err != nil {
+			return fmt.Errorf("task %s: %w", task.Name, err)
+		}
+	}
+	return nil
+}
+
+// validateTaskIn checks a task definition, except its links, against the
+// timezone, coordinates and agents of cfg
+func validateTaskIn(cfg *config.Config, task config.Task) error {
+	if task.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidTask)
+	}
+	if task.Command == "" {
+		return fmt.Errorf("%w: command is required", ErrInvalidTask)
+	}
+	loc, err := taskLocation(cfg, task)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTask, err)
+	}
+	if task.Schedule != "" {
+		if _, err := parseScheduleIn(cfg, task.Schedule, loc); err != nil {
+			return fmt.Errorf("%w: invalid schedule %q: %v", ErrInvalidTask, task.Schedule, err)
+		}
+	}
+	switch task.Overlap {
+	case "", OverlapAllow, OverlapSkip, OverlapQueue:
+	default:
+		return fmt.Errorf("%w: unknown overlap policy %q", ErrInvalidTask, task.Overlap)
+	}
+	switch task.CatchUp {
+	case "", CatchUpNone, CatchUpOnce, CatchUpAll:
+	default:
+		return fmt.Errorf("%w: unknown catch-up policy %q", ErrInvalidTask, task.CatchUp)
+	}
+	if task.CatchUpLookback < 0 {
+		return fmt.Errorf("%w: catch_up_lookback must not be negative", ErrInvalidTask)
+	}
+	if task.Retries < 0 {
+		return fmt.Errorf("%w: retries must not be negative", ErrInvalidTask)
+	}
+	switch task.OnFailure {
+	case "", OnFailureContinue, OnFailureDisable:
+	default:
+		return fmt.Errorf("%w: unknown failure policy %q", ErrInvalidTask, task.OnFailure)
+	}
+	if task.Agent != "" {
+		if _, ok := cfg.Agents[task.Agent]; !ok {
+			return fmt.Errorf("%w: unknown agent %q", ErrInvalidTask, task.Agent)
+		}
+	}
+	switch task.Command {
+	case command.ShellCommandName:
+		// Users and platform support are checked by the agent running the task
+		if task.Agent != "" {
+			break
+		}
+		if err := command.ValidateShell(task); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidTask, err)
+		}
+	case command.HTTPCommandName:
+		if err := command.ValidateHTTP(task.HTTP); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidTask, err)
+		}
+	}
+	if task.OutputKey != "" {
+		if err := database.ValidateKey(task.OutputKey); err != nil {
+			return fmt.Errorf("%w: output_key: %v", ErrInvalidTask, err)
+		}
+	}
+	switch task.OutputType {
+	case "", models.ValueTypeString, models.ValueTypeNumber, models.ValueTypeBool, models.ValueTypeJSON:
+	default:
+		return fmt.Errorf("%w: unknown output type %q", ErrInvalidTask, task.OutputType)
+	}
+	if task.OutputType != "" && task.OutputKey == "" {
+		return fmt.Errorf("%w: output_type requires output_key", ErrInvalidTask)
+	}
+	if task.OutputPath != "" {
+		if task.OutputKey == "" {
+			return fmt.Errorf("%w: output_path requires output_key", ErrInvalidTask)
+		}
+		if _, err := parseJSONPath(task.OutputPath); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidTask, err)
+		}
+	}
+	if err := validateParams(task); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTask, err)
+	}
+	return nil
+}
+
+// validateLinks checks that the tasks linked by a task exist and don't form a cycle.
+// The caller must hold s.mu.
+func (s *Scheduler) validateLinks(task config.Task) error {
+	tasks := make([]config.Task, 0, len(s.tasks)+1)
+	for _, info := range s.tasks {
+		if info.Name != task.Name {
+			tasks = append(tasks, info.Task)
+		}
+	}
+	tasks = append(tasks, task)
+
+	for _, link := range task.Links() {
+		if link != task.Name && s.findTask(link) < 0 {
+			return fmt.Errorf("%w: unknown linked task %q", ErrInvalidTask, link)
+		}
+	}
+	if cycle := config.FindTaskCycle(tasks); cycle != nil {
+		return fmt.Errorf("%w: task chain cycle: %s", ErrInvalidTask, strings.Join(cycle, " -> "))
+	}
+	return nil
+}
+
+// linkedBy returns the names of the other tasks that depend on a task or run
+// it as a follow-up. The caller must hold s.mu.
+func (s *Scheduler) linkedBy(name string) []string {
+	var names []string
+	for _, info := range s.tasks {
+		if info.Name == name {
+			continue
+		}
+		for _, link := range info.Links() {
+			if link == name {
+				names = append(names, info.Name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// GetTasks returns all tasks, config-defined ones first
+func (s *Scheduler) GetTasks() []TaskInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tasks := make([]TaskInfo, len(s.tasks))
+	for i, info := range s.tasks {
+		info.EffectiveTimezone = s.EffectiveTimezone(info.Task)
+		info.Instances = s.instancesOf(info.Name)
+		tasks[i] = info
+	}
+	return tasks
+}
+
+// GetTask returns a task by name
+func (s *Scheduler) GetTask(name string) (TaskInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.findTask(name)
+	if i < 0 {
+		return TaskInfo{}, fmt.Errorf("%w: %s", ErrTaskNotFound, name)
+	}
+	info := s.tasks[i]
+	info.EffectiveTimezone = s.EffectiveTimezone(info.Task)
+	info.Instances = s.instancesOf(name)
+	return info, nil
+}
+
+// CreateTask validates, stores and schedules a new task
+func (s *Scheduler) CreateTask(task config.Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.validateTask(task); err != nil {
+		return err
+	}
+	if s.findTask(task.Name) >= 0 {
+		return fmt.Errorf("%w: %s", ErrTaskExists, task.Name)
+	}
+	if err := s.saveTask(task); err != nil {
+		return err
+	}
+
+	s.tasks = append(s.tasks, TaskInfo{Task: task})
+	if task.Enabled && task.Schedule != "" {
+		s.addTask(task)
+	}
+	return nil
+}
+
+// UpdateTask replaces the definition of an API-managed task and reschedules it
+func (s *Scheduler) UpdateTask(task config.Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.findTask(task.Name)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrTaskNotFound, task.Name)
+	}
+	if s.tasks[i].ReadOnly {
+		return fmt.Errorf("%w: %s", ErrTaskReadOnly, task.Name)
+	}
+	if err := s.validateTask(task); err != nil {
+		return err
+	}
+	if err := s.saveTask(task); err != nil {
+		return err
+	}
+
+	s.tasks[i].Task = task
+	s.removeTask(task.Name)
+	if task.Enabled && task.Schedule != "" {
+		s.addTask(task)
+	}
+	return nil
+}
+
+// DeleteTask unschedules and removes an API-managed task. It fails with
+// ErrTaskInUse while other tasks link to it.
+func (s *Scheduler) DeleteTask(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.findTask(name)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrTaskNotFound, name)
+	}
+	if s.tasks[i].ReadOnly {
+		return fmt.Errorf("%w: %s", ErrTaskReadOnly, name)
+	}
+	if linkedBy := s.linkedBy(name); len(linkedBy) > 0 {
+		return fmt.Errorf("%w: %s is linked by %s", ErrTaskInUse, name, strings.Join(linkedBy, ", "))
+	}
+	if s.db != nil {
+		if err := s.db.DeleteTaskDefinition(name); err != nil {
+			return err
+		}
+	}
+
+	s.removeTask(name)
+	s.tasks = append(s.tasks[:i], s.tasks[i+1:]...)
+	return nil
+}
+
+func (s *Scheduler) EnableTask(name string) error {
+	return s.setTaskEnabled(name, true)
+}
+
+func (s *Scheduler) DisableTask(name string) error {
+	return s.setTaskEnabled(name, false)
+}
+
+// setTaskEnabled persists the enabled flag of a task and (un)schedules it
+func (s *Scheduler) setTaskEnabled(name string, enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.findTask(name)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrTaskNotFound, name)
+	}
+
+	task := s.tasks[i].Task
+	task.Enabled = enabled
+	if s.tasks[i].ReadOnly {
+		if s.db != nil {
+			if err := s.db.SetTaskOverride(name, enabled); err != nil {
+				return err
+			}
+		}
+	} else if err := s.saveTask(task); err != nil {
+		return err
+	}
+
+	s.tasks[i].Task = task
+	s.removeTask(name)
+	if enabled && task.Schedule != "" {
+		s.addTask(task)
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/saintbyte/home-ctrl/internal/agent"
 	"github.com/saintbyte/home-ctrl/internal/auth"
 	"github.com/saintbyte/home-ctrl/internal/config"
 	"github.com/saintbyte/home-ctrl/internal/database"
@@ -16,19 +17,21 @@ import (
 
 // Server represents the HTTP server
 type Server struct {
-	config   *config.Config
+	config   *config.Current
 	auth     *auth.Auth
 	v1Router *v1.Router
 	router   *gin.Engine
+	sched    *scheduler.Scheduler
 }
 
 // NewServer creates a new server instance
-func NewServer(cfg *config.Config, authService *auth.Auth, db *database.Database, sched *scheduler.Scheduler) *Server {
+func NewServer(cfg *config.Current, authService *auth.Auth, db *database.Database, sched *scheduler.Scheduler, agents *agent.Hub) *Server {
 	return &Server{
 		config:   cfg,
 		auth:     authService,
-		v1Router: v1.NewRouter(cfg, authService, db, sched),
+		v1Router: v1.NewRouter(cfg, authService, db, sched, agents),
 		router:   gin.Default(),
+		sched:    sched,
 	}
 }
 
@@ -49,10 +52,14 @@ func (s *Server) SetupRoutes() {
 
 	// Health check endpoint (not versioned)
 	s.router.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{
+		response := gin.H{
 			"status":  "ok",
 			"message": "Service is running",
-		})
+		}
+		if s.sched != nil {
+			response["scheduler"] = s.sched.LeaderStatus()
+		}
+		c.JSON(200, response)
 	})
 
 	// Serve static files from public directory in root
@@ -98,7 +105,7 @@ func (s *Server) SetupRoutes() {
 
 // Run starts the HTTP server
 func (s *Server) Run() error {
-	address := s.config.GetServerAddress()
+	address := s.config.Load().GetServerAddress()
 	fmt.Printf("Starting server on %s\n", address)
 
 	return s.router.Run(address)
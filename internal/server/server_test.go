@@ -28,7 +28,7 @@ func TestServerRoutes(t *testing.T) {
 		_ = db.GetDB().Exec("DROP TABLE IF EXISTS sessions")
 	}()
 
-	authService := auth.NewAuth(config.DefaultConfig(), db)
+	authService := auth.NewAuth(config.NewCurrent(config.DefaultConfig()), db)
 	authService.AddUser("test", "test123")
 
 	// Create server with default config, auth, and database
@@ -9,11 +9,11 @@ import (
 
 // ExampleHandler handles example endpoints
 type ExampleHandler struct {
-	config *config.Config
+	config *config.Current
 }
 
 // NewExampleHandler creates a new example handler
-func NewExampleHandler(cfg *config.Config) *ExampleHandler {
+func NewExampleHandler(cfg *config.Current) *ExampleHandler {
 	return &ExampleHandler{
 		config: cfg,
 	}
@@ -32,8 +32,8 @@ func (h *ExampleHandler) exampleEndpoint(c *gin.Context) {
 		"message": "Hello from protected endpoint!",
 		"user":    username,
 		"config": gin.H{
-			"host": h.config.Server.Host,
-			"port": h.config.Server.Port,
+			"host": h.config.Load().Server.Host,
+			"port": h.config.Load().Server.Port,
 		},
 	})
 }
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saintbyte/home-ctrl/internal/agent"
+)
+
+// AgentHandler lists the remote agents tasks can run on
+type AgentHandler struct {
+	agents *agent.Hub
+}
+
+// NewAgentHandler creates a new agent handler
+func NewAgentHandler(agents *agent.Hub) *AgentHandler {
+	return &AgentHandler{agents: agents}
+}
+
+func (h *AgentHandler) SetupRoutes(router *gin.RouterGroup) {
+	router.GET("/agents", h.listAgents)
+}
+
+// listAgents handles GET /agents
+func (h *AgentHandler) listAgents(c *gin.Context) {
+	agents := []agent.Status{}
+	if h.agents != nil {
+		agents = h.agents.Agents()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"agents": agents,
+		"total":  len(agents),
+	})
+}
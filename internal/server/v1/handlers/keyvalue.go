@@ -1,10 +1,19 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/saintbyte/home-ctrl/internal/database"
+	"github.com/saintbyte/home-ctrl/internal/database/models"
 )
 
 // KeyValueHandler handles key-value storage operations
@@ -17,27 +26,174 @@ func NewKeyValueHandler(db *database.Database) *KeyValueHandler {
 	return &KeyValueHandler{db: db}
 }
 
-// SetupRoutes sets up key-value related routes
+// SetupRoutes sets up key-value related routes. Keys may contain slashes to
+// form namespaces; a path ending with a slash addresses the namespace.
 func (h *KeyValueHandler) SetupRoutes(router *gin.RouterGroup) {
 	keyValueGroup := router.Group("/keyvalue")
 	{
 		keyValueGroup.POST("", h.createKeyValue)
-		keyValueGroup.GET("/:key", h.getKeyValue)
-		keyValueGroup.PUT("/:key", h.updateKeyValue)
-		keyValueGroup.PATCH("/:key/status", h.updateKeyValueStatus)
-		keyValueGroup.PATCH("/:key/hidden", h.updateKeyValueHidden)
-		keyValueGroup.DELETE("/:key", h.deleteKeyValue)
 		keyValueGroup.GET("", h.listKeyValues)
-		keyValueGroup.GET("/:key/status", h.checkKeyValueStatus)
-		keyValueGroup.GET("/:key/exists", h.checkKeyValueExists)
+		keyValueGroup.GET("/*path", h.keyPath(h.getKeyValue, h.listNamespace, map[string]gin.HandlerFunc{
+			"status":  h.checkKeyValueStatus,
+			"exists":  h.checkKeyValueExists,
+			"history": h.keyValueHistory,
+		}))
+		keyValueGroup.POST("/*path", h.keyPath(nil, nil, map[string]gin.HandlerFunc{
+			"rollback": h.rollbackKeyValue,
+			"cas":      h.compareAndSwapKeyValue,
+		}))
+		keyValueGroup.PUT("/*path", h.keyPath(h.updateKeyValue, nil, map[string]gin.HandlerFunc{
+			"retention": h.setKeyValueRetention,
+		}))
+		keyValueGroup.PATCH("/*path", h.keyPath(h.patchKeyValue, h.updateNamespace, map[string]gin.HandlerFunc{
+			"status": h.updateKeyValueStatus,
+			"hidden": h.updateKeyValueHidden,
+		}))
+		keyValueGroup.DELETE("/*path", h.keyPath(h.deleteKeyValue, h.deleteNamespace, nil))
 	}
+	h.setupSchemaRoutes(router)
 }
 
-// createKeyValue handles POST /keyvalue
+// keyPath dispatches a request for /keyvalue/*path to the handler of a key,
+// of an action on a key or of a namespace. The handlers find the key or the
+// namespace prefix in the "key" or "prefix" parameter. A path whose last
+// segment is one of database.KeyActions always addresses the action, as keys
+// can't end with one; an action without a handler for the method responds
+// with 405.
+func (h *KeyValueHandler) keyPath(key, namespace gin.HandlerFunc, actions map[string]gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := strings.TrimPrefix(c.Param("path"), "/")
+
+		var handler gin.HandlerFunc
+		switch name, action := splitKeyPath(path); {
+		case path == "" || strings.HasSuffix(path, database.KeySeparator):
+			handler = namespace
+			c.AddParam("prefix", path)
+		case action != "":
+			handler = actions[action]
+			c.AddParam("key", name)
+		default:
+			handler = key
+			c.AddParam("key", path)
+		}
+
+		if handler == nil {
+			c.JSON(http.StatusMethodNotAllowed, gin.H{
+				"error":   "Method Not Allowed",
+				"message": "No such key-value operation",
+			})
+			return
+		}
+		handler(c)
+	}
+}
+
+// splitKeyPath splits a path ending with an action, e.g. "lamp/status", into
+// the key and the action. The action is empty for the path of a key.
+func splitKeyPath(path string) (key, action string) {
+	i := strings.LastIndex(path, database.KeySeparator)
+	if i <= 0 || !slices.Contains(database.KeyActions, path[i+1:]) {
+		return path, ""
+	}
+	return path[:i], path[i+1:]
+}
+
+// valueError responds to an error validating a value, returning false if
+// there was none
+func valueError(c *gin.Context, err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, database.ErrInvalidValue) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Internal Server Error",
+			"message": "Failed to validate value",
+		})
+	}
+	return true
+}
+
+// changedBy names the authenticated user or API key of a request in the
+// key-value history
+func changedBy(c *gin.Context) string {
+	if username := c.GetString("username"); username != "" {
+		return "user:" + username
+	}
+	if name := c.GetString("api_key"); name != "" {
+		return "api_key:" + name
+	}
+	return ""
+}
+
+// errPreconditionFailed is returned for changes of pairs that don't match
+// the If-Match header of the request
+var errPreconditionFailed = errors.New("key-value pair does not match If-Match")
+
+// writeOptions describes the change made by a request: who makes it and, if
+// the request has an If-Match header, the entity tags the pair must match
+func writeOptions(c *gin.Context) database.WriteOptions {
+	opts := database.WriteOptions{By: changedBy(c)}
+	if ifMatch := c.GetHeader("If-Match"); ifMatch != "" {
+		opts.Check = func(kv *models.KeyValue) error {
+			if !kv.MatchesETag(ifMatch) {
+				return fmt.Errorf("%w, its ETag is %s", errPreconditionFailed, kv.ETag())
+			}
+			return nil
+		}
+	}
+	return opts
+}
+
+// respondKeyValue responds with a key-value pair and its ETag
+func respondKeyValue(c *gin.Context, status int, kv *models.KeyValue) {
+	c.Header("ETag", kv.ETag())
+	c.JSON(status, kv)
+}
+
+// changeError responds to an error changing a key-value pair. A missing key
+// fails the If-Match header of a request, as it has no current ETag.
+func changeError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, database.ErrKeyNotFound) && c.GetHeader("If-Match") != "":
+		c.JSON(http.StatusPreconditionFailed, gin.H{
+			"error":   "Precondition Failed",
+			"message": fmt.Sprintf("%v, the key doesn't exist", errPreconditionFailed),
+		})
+	case errors.Is(err, database.ErrKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "Not Found",
+			"message": "Key not found",
+		})
+	case errors.Is(err, errPreconditionFailed):
+		c.JSON(http.StatusPreconditionFailed, gin.H{
+			"error":   "Precondition Failed",
+			"message": err.Error(),
+		})
+	case errors.Is(err, database.ErrKeyExists), errors.Is(err, database.ErrVersionConflict):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Conflict",
+			"message": err.Error(),
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Internal Server Error",
+			"message": message,
+		})
+	}
+}
+
+// createKeyValue handles POST /keyvalue. The value is any JSON value; its
+// type is inferred unless value_type is given, see models.ParseValue.
 func (h *KeyValueHandler) createKeyValue(c *gin.Context) {
 	type request struct {
-		Key   string `json:"key" binding:"required"`
-		Value string `json:"value" binding:"required"`
+		Key       string          `json:"key" binding:"required"`
+		Value     json.RawMessage `json:"value" binding:"required"`
+		ValueType string          `json:"value_type" binding:"omitempty,oneof=string number bool json"`
 	}
 
 	var req request
@@ -48,20 +204,35 @@ func (h *KeyValueHandler) createKeyValue(c *gin.Context) {
 		})
 		return
 	}
-
-	kv, err := h.db.CreateKeyValue(req.Key, req.Value)
+	if err := database.ValidateKey(req.Key); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+		return
+	}
+	value, valueType, err := models.ParseValue(req.Value, req.ValueType)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Internal Server Error",
-			"message": "Failed to create key-value pair",
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": err.Error(),
 		})
 		return
 	}
+	if valueError(c, h.db.ValidateKeyValue(req.Key, value, valueType)) {
+		return
+	}
 
-	c.JSON(http.StatusCreated, kv)
+	kv, err := h.db.CreateKeyValue(req.Key, value, valueType, writeOptions(c))
+	if err != nil {
+		changeError(c, err, "Failed to create key-value pair")
+		return
+	}
+
+	respondKeyValue(c, http.StatusCreated, kv)
 }
 
-// getKeyValue handles GET /keyvalue/:key
+// getKeyValue handles GET /keyvalue/*key
 func (h *KeyValueHandler) getKeyValue(c *gin.Context) {
 	key := c.Param("key")
 
@@ -82,15 +253,16 @@ func (h *KeyValueHandler) getKeyValue(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, kv)
+	respondKeyValue(c, http.StatusOK, kv)
 }
 
-// updateKeyValue handles PUT /keyvalue/:key
+// updateKeyValue handles PUT /keyvalue/*key
 func (h *KeyValueHandler) updateKeyValue(c *gin.Context) {
 	key := c.Param("key")
 
 	type request struct {
-		Value string `json:"value" binding:"required"`
+		Value     json.RawMessage `json:"value" binding:"required"`
+		ValueType string          `json:"value_type" binding:"omitempty,oneof=string number bool json"`
 	}
 
 	var req request
@@ -101,20 +273,98 @@ func (h *KeyValueHandler) updateKeyValue(c *gin.Context) {
 		})
 		return
 	}
+	value, valueType, err := models.ParseValue(req.Value, req.ValueType)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+		return
+	}
+	if valueError(c, h.db.ValidateKeyValue(key, value, valueType)) {
+		return
+	}
+
+	kv, err := h.db.UpdateKeyValue(key, value, valueType, writeOptions(c))
+	if err != nil {
+		changeError(c, err, "Failed to update key-value pair")
+		return
+	}
+
+	respondKeyValue(c, http.StatusOK, kv)
+}
+
+// patchKeyValue handles PATCH /keyvalue/*key with an RFC 7386 merge patch
+// of a json value
+func (h *KeyValueHandler) patchKeyValue(c *gin.Context) {
+	key := c.Param("key")
+
+	if c.ContentType() != "application/merge-patch+json" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"error":   "Unsupported Media Type",
+			"message": "Content-Type must be application/merge-patch+json",
+		})
+		return
+	}
+	patch, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+		return
+	}
 
-	kv, err := h.db.UpdateKeyValue(key, req.Value)
+	kv, err := h.db.GetKeyValue(key)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "Internal Server Error",
-			"message": "Failed to update key-value pair",
+			"message": "Failed to get key-value pair",
 		})
 		return
 	}
+	if kv == nil {
+		changeError(c, fmt.Errorf("%w: %s", database.ErrKeyNotFound, key), "Failed to update key-value pair")
+		return
+	}
+	opts := writeOptions(c)
+	if opts.Check != nil {
+		if err := opts.Check(kv); err != nil {
+			changeError(c, err, "Failed to update key-value pair")
+			return
+		}
+	}
+	if err := kv.MergePatch(patch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+		return
+	}
+	if valueError(c, h.db.ValidateKeyValue(key, kv.Value, kv.ValueType)) {
+		return
+	}
+
+	// The patch applies to the version read above; the If-Match header was
+	// checked against it
+	read := kv.Version
+	opts.Check = func(current *models.KeyValue) error {
+		if current.Version != read {
+			return database.ErrVersionConflict
+		}
+		return nil
+	}
 
-	c.JSON(http.StatusOK, kv)
+	kv, err = h.db.UpdateKeyValue(key, kv.Value, kv.ValueType, opts)
+	if err != nil {
+		changeError(c, err, "Failed to update key-value pair")
+		return
+	}
+
+	respondKeyValue(c, http.StatusOK, kv)
 }
 
-// updateKeyValueStatus handles PATCH /keyvalue/:key/status
+// updateKeyValueStatus handles PATCH /keyvalue/*key/status
 func (h *KeyValueHandler) updateKeyValueStatus(c *gin.Context) {
 	key := c.Param("key")
 
@@ -131,19 +381,16 @@ func (h *KeyValueHandler) updateKeyValueStatus(c *gin.Context) {
 		return
 	}
 
-	kv, err := h.db.UpdateKeyValueStatus(key, req.Status)
+	kv, err := h.db.UpdateKeyValueStatus(key, req.Status, writeOptions(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Internal Server Error",
-			"message": "Failed to update key-value status",
-		})
+		changeError(c, err, "Failed to update key-value status")
 		return
 	}
 
-	c.JSON(http.StatusOK, kv)
+	respondKeyValue(c, http.StatusOK, kv)
 }
 
-// updateKeyValueHidden handles PATCH /keyvalue/:key/hidden
+// updateKeyValueHidden handles PATCH /keyvalue/*key/hidden
 func (h *KeyValueHandler) updateKeyValueHidden(c *gin.Context) {
 	key := c.Param("key")
 
@@ -160,40 +407,94 @@ func (h *KeyValueHandler) updateKeyValueHidden(c *gin.Context) {
 		return
 	}
 
-	kv, err := h.db.UpdateKeyValueHidden(key, req.Hidden)
+	kv, err := h.db.UpdateKeyValueHidden(key, req.Hidden, writeOptions(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Internal Server Error",
-			"message": "Failed to update key-value hidden flag",
-		})
+		changeError(c, err, "Failed to update key-value hidden flag")
 		return
 	}
 
-	c.JSON(http.StatusOK, kv)
+	respondKeyValue(c, http.StatusOK, kv)
 }
 
-// deleteKeyValue handles DELETE /keyvalue/:key
+// deleteKeyValue handles DELETE /keyvalue/*key
 func (h *KeyValueHandler) deleteKeyValue(c *gin.Context) {
 	key := c.Param("key")
 
-	if err := h.db.DeleteKeyValue(key); err != nil {
+	if err := h.db.DeleteKeyValue(key, writeOptions(c)); err != nil {
+		changeError(c, err, "Failed to delete key-value pair")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Key-value pair deleted successfully",
+	})
+}
+
+// listKeyValues handles GET /keyvalue. Query parameters: include_hidden,
+// prefix, status, updated_since (RFC 3339), sort (key, created_at or
+// updated_at), order (asc or desc), limit and cursor (next_cursor of the
+// previous page).
+func (h *KeyValueHandler) listKeyValues(c *gin.Context) {
+	filter, ok := keyValueFilter(c, database.KeyValueSortCreatedAt)
+	if !ok {
+		return
+	}
+
+	keyValues, total, nextCursor, err := h.db.ListKeyValues(filter)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "Internal Server Error",
-			"message": "Failed to delete key-value pair",
+			"message": "Failed to list key-value pairs",
 		})
 		return
 	}
 
+	var next any
+	if nextCursor != "" {
+		next = nextCursor
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Key-value pair deleted successfully",
+		"key_values":  keyValues,
+		"total":       total,
+		"limit":       filter.Limit,
+		"next_cursor": next,
 	})
 }
 
-// listKeyValues handles GET /keyvalue
-func (h *KeyValueHandler) listKeyValues(c *gin.Context) {
-	includeHidden := c.DefaultQuery("include_hidden", "false") == "true"
+// listNamespace handles GET /keyvalue/*prefix/, listing the namespaces and
+// keys directly below prefix like a directory. The keys are paged like GET
+// /keyvalue and sorted by key by default.
+func (h *KeyValueHandler) listNamespace(c *gin.Context) {
+	filter, ok := keyValueFilter(c, database.KeyValueSortKey)
+	if !ok {
+		return
+	}
+	filter.Prefix = c.Param("prefix")
+	filter.Direct = true
 
-	keyValues, err := h.db.ListKeyValues(includeHidden)
+	namespaces, err := h.db.ListKeyValueNamespaces(filter.Prefix, filter.IncludeHidden)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Internal Server Error",
+			"message": "Failed to list key-value namespaces",
+		})
+		return
+	}
+	keyValues, total, nextCursor, err := h.db.ListKeyValues(filter)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "Internal Server Error",
@@ -202,10 +503,152 @@ func (h *KeyValueHandler) listKeyValues(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, keyValues)
+	var next any
+	if nextCursor != "" {
+		next = nextCursor
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"prefix":      filter.Prefix,
+		"namespaces":  namespaces,
+		"key_values":  keyValues,
+		"total":       total,
+		"limit":       filter.Limit,
+		"next_cursor": next,
+	})
+}
+
+// updateNamespace handles PATCH /keyvalue/*prefix/, setting the status
+// and/or hidden flag of every key in the namespace and the ones below it
+func (h *KeyValueHandler) updateNamespace(c *gin.Context) {
+	prefix := c.Param("prefix")
+
+	type request struct {
+		Status *string `json:"status" binding:"omitempty,oneof=unread read archived"`
+		Hidden *bool   `json:"hidden"`
+	}
+
+	var req request
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+		return
+	}
+	if req.Status == nil && req.Hidden == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": "status or hidden is required",
+		})
+		return
+	}
+	if prefix == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": "A namespace is required",
+		})
+		return
+	}
+
+	updated, err := h.db.UpdateKeyValuesWithPrefix(prefix, req.Status, req.Hidden, changedBy(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Internal Server Error",
+			"message": "Failed to update key-value namespace",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"prefix":  prefix,
+		"updated": updated,
+	})
+}
+
+// deleteNamespace handles DELETE /keyvalue/*prefix/, deleting every key in
+// the namespace and the ones below it
+func (h *KeyValueHandler) deleteNamespace(c *gin.Context) {
+	prefix := c.Param("prefix")
+	if prefix == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": "A namespace is required",
+		})
+		return
+	}
+
+	deleted, err := h.db.DeleteKeyValuesWithPrefix(prefix, changedBy(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Internal Server Error",
+			"message": "Failed to delete key-value namespace",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Key-value namespace deleted successfully",
+		"prefix":  prefix,
+		"deleted": deleted,
+	})
+}
+
+// keyValueFilter reads the listing query parameters, responding with 400 if
+// one is invalid. Sort defaults to defaultSort.
+func keyValueFilter(c *gin.Context, defaultSort string) (database.KeyValueFilter, bool) {
+	filter := database.KeyValueFilter{
+		IncludeHidden: c.DefaultQuery("include_hidden", "false") == "true",
+		Prefix:        c.Query("prefix"),
+		Status:        c.Query("status"),
+		Sort:          c.DefaultQuery("sort", defaultSort),
+		Cursor:        c.Query("cursor"),
+	}
+	badRequest := func(message string) (database.KeyValueFilter, bool) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": message,
+		})
+		return filter, false
+	}
+
+	switch filter.Status {
+	case "", models.StatusUnread, models.StatusRead, models.StatusArchived:
+	default:
+		return badRequest("status must be unread, read or archived")
+	}
+	if since := c.Query("updated_since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return badRequest("updated_since must be an RFC 3339 time")
+		}
+		filter.UpdatedSince = &t
+	}
+	switch filter.Sort {
+	case database.KeyValueSortKey, database.KeyValueSortCreatedAt, database.KeyValueSortUpdatedAt:
+	default:
+		return badRequest("sort must be key, created_at or updated_at")
+	}
+	// Newest first by default, keys alphabetically
+	order := "desc"
+	if filter.Sort == database.KeyValueSortKey {
+		order = "asc"
+	}
+	switch c.DefaultQuery("order", order) {
+	case "asc":
+	case "desc":
+		filter.Descending = true
+	default:
+		return badRequest("order must be asc or desc")
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		return badRequest("limit must be between 1 and 100")
+	}
+	filter.Limit = limit
+	return filter, true
 }
 
-// checkKeyValueStatus handles GET /keyvalue/:key/status
+// checkKeyValueStatus handles GET /keyvalue/*key/status
 func (h *KeyValueHandler) checkKeyValueStatus(c *gin.Context) {
 	key := c.Param("key")
 
@@ -233,7 +676,7 @@ func (h *KeyValueHandler) checkKeyValueStatus(c *gin.Context) {
 	})
 }
 
-// checkKeyValueExists handles GET /keyvalue/:key/exists
+// checkKeyValueExists handles GET /keyvalue/*key/exists
 func (h *KeyValueHandler) checkKeyValueExists(c *gin.Context) {
 	key := c.Param("key")
 
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saintbyte/home-ctrl/internal/database"
+	"github.com/saintbyte/home-ctrl/internal/database/models"
+)
+
+// compareAndSwapKeyValue handles POST /keyvalue/*key/cas, setting the value
+// of a key only if its current version and/or value are the expected ones.
+// An expected version of 0 creates the key if it doesn't exist. The expected
+// value is parsed like the value and compared with the stored text and type.
+// It responds with 201 if the key was created, and with 409 and the current
+// pair on a mismatch.
+func (h *KeyValueHandler) compareAndSwapKeyValue(c *gin.Context) {
+	key := c.Param("key")
+
+	type request struct {
+		ExpectedVersion *int            `json:"expected_version" binding:"omitempty,min=0"`
+		ExpectedValue   json.RawMessage `json:"expected_value"`
+		Value           json.RawMessage `json:"value" binding:"required"`
+		ValueType       string          `json:"value_type" binding:"omitempty,oneof=string number bool json"`
+	}
+
+	var req request
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+		return
+	}
+	if req.ExpectedVersion == nil && req.ExpectedValue == nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": "expected_version or expected_value is required",
+		})
+		return
+	}
+	creating := req.ExpectedVersion != nil && *req.ExpectedVersion == 0
+	if creating && req.ExpectedValue != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": "expected_value can't be given with expected_version 0",
+		})
+		return
+	}
+
+	var expectedValue, expectedType string
+	if req.ExpectedValue != nil {
+		var err error
+		if expectedValue, expectedType, err = models.ParseValue(req.ExpectedValue, ""); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Bad Request",
+				"message": fmt.Sprintf("expected_value: %v", err),
+			})
+			return
+		}
+	}
+	value, valueType, err := models.ParseValue(req.Value, req.ValueType)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+		return
+	}
+	if creating {
+		if err := database.ValidateKey(key); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Bad Request",
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+	if valueError(c, h.db.ValidateKeyValue(key, value, valueType)) {
+		return
+	}
+
+	opts := database.WriteOptions{By: changedBy(c)}
+	var kv *models.KeyValue
+	if creating {
+		kv, err = h.db.CreateKeyValue(key, value, valueType, opts)
+	} else {
+		opts.Check = func(current *models.KeyValue) error {
+			if req.ExpectedVersion != nil && current.Version != *req.ExpectedVersion {
+				return database.ErrVersionConflict
+			}
+			if req.ExpectedValue != nil && (current.Value != expectedValue || current.ValueType != expectedType) {
+				return database.ErrVersionConflict
+			}
+			return nil
+		}
+		kv, err = h.db.UpdateKeyValue(key, value, valueType, opts)
+	}
+	if errors.Is(err, database.ErrKeyExists) || errors.Is(err, database.ErrVersionConflict) {
+		current, err := h.db.GetKeyValue(key)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error":   "Internal Server Error",
+				"message": "Failed to get key-value pair",
+			})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Conflict",
+			"message": "Key-value pair doesn't have the expected version or value",
+			"current": current,
+		})
+		return
+	}
+	if err != nil {
+		changeError(c, err, "Failed to swap key-value pair")
+		return
+	}
+
+	status := http.StatusOK
+	if creating {
+		status = http.StatusCreated
+	}
+	respondKeyValue(c, status, kv)
+}
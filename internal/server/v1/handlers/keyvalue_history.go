@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saintbyte/home-ctrl/internal/database"
+)
+
+// keyValueHistory handles GET /keyvalue/*key/history, listing the recorded
+// versions of a key newest first. The history of a deleted key ends with its
+// "delete" version.
+func (h *KeyValueHandler) keyValueHistory(c *gin.Context) {
+	key := c.Param("key")
+
+	kv, err := h.db.GetKeyValue(key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Internal Server Error",
+			"message": "Failed to get key-value pair",
+		})
+		return
+	}
+
+	versions, err := h.db.ListKeyValueHistory(key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Internal Server Error",
+			"message": "Failed to list key-value history",
+		})
+		return
+	}
+	if kv == nil && len(versions) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "Not Found",
+			"message": "Key not found",
+		})
+		return
+	}
+
+	retention := database.DefaultKeyValueHistoryRetention
+	if kv != nil && kv.HistoryRetention != nil {
+		retention = *kv.HistoryRetention
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"key":       key,
+		"versions":  versions,
+		"total":     len(versions),
+		"retention": retention,
+	})
+}
+
+// rollbackKeyValue handles POST /keyvalue/*key/rollback?version=N, restoring
+// a version from the history of a key
+func (h *KeyValueHandler) rollbackKeyValue(c *gin.Context) {
+	key := c.Param("key")
+
+	version, err := strconv.Atoi(c.Query("version"))
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": "version must be a positive number",
+		})
+		return
+	}
+
+	recorded, err := h.db.GetKeyValueVersion(key, version)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Internal Server Error",
+			"message": "Failed to get key-value version",
+		})
+		return
+	}
+	if recorded == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "Not Found",
+			"message": "Version not found",
+		})
+		return
+	}
+	if valueError(c, h.db.ValidateKeyValue(key, recorded.Value, recorded.ValueType)) {
+		return
+	}
+
+	kv, err := h.db.RollbackKeyValue(key, version, writeOptions(c))
+	if errors.Is(err, database.ErrKeyValueVersionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "Not Found",
+			"message": "Version not found",
+		})
+		return
+	}
+	if err != nil {
+		changeError(c, err, "Failed to roll back key-value pair")
+		return
+	}
+
+	respondKeyValue(c, http.StatusOK, kv)
+}
+
+// setKeyValueRetention handles PUT /keyvalue/*key/retention, setting how many
+// versions of a key its history keeps. A null number of versions restores
+// the default.
+func (h *KeyValueHandler) setKeyValueRetention(c *gin.Context) {
+	key := c.Param("key")
+
+	type request struct {
+		Versions *int `json:"versions" binding:"omitempty,min=1,max=1000"`
+	}
+
+	var req request
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	kv, err := h.db.SetKeyValueHistoryRetention(key, req.Versions, writeOptions(c))
+	if err != nil {
+		changeError(c, err, "Failed to set key-value history retention")
+		return
+	}
+
+	respondKeyValue(c, http.StatusOK, kv)
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saintbyte/home-ctrl/internal/database"
+)
+
+// setupSchemaRoutes sets up the routes registering JSON Schemas for the
+// values of the keys below a prefix
+func (h *KeyValueHandler) setupSchemaRoutes(router *gin.RouterGroup) {
+	schemaGroup := router.Group("/keyvalue-schemas")
+	{
+		schemaGroup.GET("", h.listKeyValueSchemas)
+		schemaGroup.GET("/*prefix", h.getKeyValueSchema)
+		schemaGroup.PUT("/*prefix", h.setKeyValueSchema)
+		schemaGroup.DELETE("/*prefix", h.deleteKeyValueSchema)
+	}
+}
+
+// schemaPrefix returns the prefix of a schema route, responding with 400 if
+// it is empty
+func schemaPrefix(c *gin.Context) (string, bool) {
+	prefix := strings.TrimPrefix(c.Param("prefix"), "/")
+	if prefix == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": "A key prefix is required",
+		})
+		return "", false
+	}
+	return prefix, true
+}
+
+// listKeyValueSchemas handles GET /keyvalue-schemas
+func (h *KeyValueHandler) listKeyValueSchemas(c *gin.Context) {
+	schemas, err := h.db.ListKeyValueSchemas()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Internal Server Error",
+			"message": "Failed to list key-value schemas",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"schemas": schemas,
+		"total":   len(schemas),
+	})
+}
+
+// getKeyValueSchema handles GET /keyvalue-schemas/*prefix
+func (h *KeyValueHandler) getKeyValueSchema(c *gin.Context) {
+	prefix, ok := schemaPrefix(c)
+	if !ok {
+		return
+	}
+
+	schema, err := h.db.GetKeyValueSchema(prefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Internal Server Error",
+			"message": "Failed to get key-value schema",
+		})
+		return
+	}
+	if schema == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "Not Found",
+			"message": "Schema not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, schema)
+}
+
+// setKeyValueSchema handles PUT /keyvalue-schemas/*prefix. The body is the
+// JSON Schema; values already stored are not checked against it.
+func (h *KeyValueHandler) setKeyValueSchema(c *gin.Context) {
+	prefix, ok := schemaPrefix(c)
+	if !ok {
+		return
+	}
+
+	document, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+		return
+	}
+	if _, err := database.CompileKeyValueSchema(prefix, document); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": fmt.Sprintf("invalid schema: %v", err),
+		})
+		return
+	}
+	var compact bytes.Buffer
+	json.Compact(&compact, document)
+
+	schema, err := h.db.SetKeyValueSchema(prefix, compact.Bytes())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Internal Server Error",
+			"message": "Failed to set key-value schema",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, schema)
+}
+
+// deleteKeyValueSchema handles DELETE /keyvalue-schemas/*prefix
+func (h *KeyValueHandler) deleteKeyValueSchema(c *gin.Context) {
+	prefix, ok := schemaPrefix(c)
+	if !ok {
+		return
+	}
+
+	deleted, err := h.db.DeleteKeyValueSchema(prefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Internal Server Error",
+			"message": "Failed to delete key-value schema",
+		})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "Not Found",
+			"message": "Schema not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Key-value schema deleted successfully",
+	})
+}
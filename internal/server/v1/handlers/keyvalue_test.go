@@ -0,0 +1,233 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saintbyte/home-ctrl/internal/auth"
+	"github.com/saintbyte/home-ctrl/internal/config"
+	"github.com/saintbyte/home-ctrl/internal/database"
+	v1 "github.com/saintbyte/home-ctrl/internal/server/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+// keyValueServer is the v1 router on a temporary database with a logged in user
+type keyValueServer struct {
+	router *gin.Engine
+	db     *database.Database
+	token  string
+}
+
+func newKeyValueServer(t *testing.T) *keyValueServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, err := database.NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.InitDatabase(); err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+
+	cfg := config.NewCurrent(config.DefaultConfig())
+	authService := auth.NewAuth(cfg, db)
+	authService.AddUser("testuser", "testpass")
+	router := v1.NewRouter(cfg, authService, db, nil, nil)
+	router.SetupRoutes()
+	s := &keyValueServer{router: router.GetRouter(), db: db}
+
+	w := s.request("POST", "/api/v1/auth/login", map[string]string{"username": "testuser", "password": "testpass"}, nil)
+	var login map[string]any
+	json.Unmarshal(w.Body.Bytes(), &login)
+	s.token, _ = login["token"].(string)
+	if s.token == "" {
+		t.Fatalf("Failed to log in: %s", w.Body.String())
+	}
+	return s
+}
+
+// request sends a JSON request with the token of the user and extra headers
+func (s *keyValueServer) request(method, path string, body any, headers map[string]string) *httptest.ResponseRecorder {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req, _ := http.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+func decodeResponse(w *httptest.ResponseRecorder) map[string]any {
+	var response map[string]any
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return response
+}
+
+func TestKeyValueETag(t *testing.T) {
+	s := newKeyValueServer(t)
+
+	w := s.request("POST", "/api/v1/keyvalue", map[string]any{"key": "lamp/state", "value": "on"}, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	created := w.Header().Get("ETag")
+	assert.NotEmpty(t, created)
+
+	w = s.request("GET", "/api/v1/keyvalue/lamp/state", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, created, w.Header().Get("ETag"))
+
+	// A matching If-Match header lets the change through and returns the new ETag
+	w = s.request("PUT", "/api/v1/keyvalue/lamp/state", map[string]any{"value": "off"}, map[string]string{"If-Match": created})
+	assert.Equal(t, http.StatusOK, w.Code)
+	updated := w.Header().Get("ETag")
+	assert.NotEqual(t, created, updated)
+	assert.Equal(t, "off", decodeResponse(w)["value"])
+
+	// A stale one fails with 412 and leaves the pair alone
+	for _, change := range []struct {
+		method, path string
+		body         any
+	}{
+		{"PUT", "/api/v1/keyvalue/lamp/state", map[string]any{"value": "on"}},
+		{"PATCH", "/api/v1/keyvalue/lamp/state/status", map[string]any{"status": "read"}},
+		{"PATCH", "/api/v1/keyvalue/lamp/state/hidden", map[string]any{"hidden": true}},
+		{"DELETE", "/api/v1/keyvalue/lamp/state", nil},
+	} {
+		w = s.request(change.method, change.path, change.body, map[string]string{"If-Match": created})
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code, "%s %s", change.method, change.path)
+	}
+	kv, _ := s.db.GetKeyValue("lamp/state")
+	assert.Equal(t, "off", kv.Value)
+	assert.Equal(t, updated, kv.ETag())
+
+	w = s.request("PUT", "/api/v1/keyvalue/lamp/state", map[string]any{"value": "on"}, map[string]string{"If-Match": "*"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = s.request("DELETE", "/api/v1/keyvalue/lamp/state", nil, map[string]string{"If-Match": w.Header().Get("ETag")})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestKeyValueIfMatchMissingKey(t *testing.T) {
+	s := newKeyValueServer(t)
+
+	// A missing key has no current ETag, so any If-Match fails
+	for _, change := range []struct {
+		method, path string
+		body         any
+	}{
+		{"PUT", "/api/v1/keyvalue/missing", map[string]any{"value": "on"}},
+		{"PATCH", "/api/v1/keyvalue/missing/status", map[string]any{"status": "read"}},
+		{"DELETE", "/api/v1/keyvalue/missing", nil},
+		{"POST", "/api/v1/keyvalue", map[string]any{"key": "missing", "value": "on"}},
+	} {
+		w := s.request(change.method, change.path, change.body, map[string]string{"If-Match": "*"})
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code, "%s %s", change.method, change.path)
+	}
+	kv, _ := s.db.GetKeyValue("missing")
+	assert.Nil(t, kv)
+
+	// Without If-Match a missing key is still not found
+	w := s.request("PUT", "/api/v1/keyvalue/missing", map[string]any{"value": "on"}, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestKeyValueCompareAndSwap(t *testing.T) {
+	s := newKeyValueServer(t)
+
+	// Expected version 0 creates the key
+	w := s.request("POST", "/api/v1/keyvalue/heating/target/cas", map[string]any{"expected_version": 0, "value": 20}, nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NotEmpty(t, w.Header().Get("ETag"))
+	response := decodeResponse(w)
+	assert.Equal(t, float64(1), response["version"])
+	assert.Equal(t, "number", response["value_type"])
+
+	w = s.request("POST", "/api/v1/keyvalue/heating/target/cas", map[string]any{"expected_version": 0, "value": 21}, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// A swap of the expected version or value succeeds once
+	w = s.request("POST", "/api/v1/keyvalue/heating/target/cas", map[string]any{"expected_version": 1, "value": 21}, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(2), decodeResponse(w)["version"])
+
+	w = s.request("POST", "/api/v1/keyvalue/heating/target/cas", map[string]any{"expected_version": 1, "value": 22}, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	current, _ := decodeResponse(w)["current"].(map[string]any)
+	assert.Equal(t, float64(2), current["version"])
+
+	w = s.request("POST", "/api/v1/keyvalue/heating/target/cas", map[string]any{"expected_value": 21, "value": 22}, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = s.request("POST", "/api/v1/keyvalue/heating/target/cas", map[string]any{"expected_value": 21, "value": 23}, nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = s.request("POST", "/api/v1/keyvalue/missing/cas", map[string]any{"expected_version": 1, "value": 1}, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = s.request("POST", "/api/v1/keyvalue/heating/target/cas", map[string]any{"value": 1}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	kv, _ := s.db.GetKeyValue("heating/target")
+	assert.Equal(t, "22", kv.Value)
+	assert.Equal(t, 3, kv.Version)
+}
+
+func TestKeyValueRoutes(t *testing.T) {
+	s := newKeyValueServer(t)
+	for _, key := range []string{"lamp/state", "lamp/power", "status", "history"} {
+		w := s.request("POST", "/api/v1/keyvalue", map[string]any{"key": key, "value": "on"}, nil)
+		assert.Equal(t, http.StatusCreated, w.Code, key)
+	}
+
+	tests := []struct {
+		method, path string
+		body         any
+		status       int
+		field, want  string // field of the response identifying the handler
+	}{
+		{"GET", "/api/v1/keyvalue/lamp/state", nil, http.StatusOK, "key", "lamp/state"},
+		{"GET", "/api/v1/keyvalue/lamp/state/status", nil, http.StatusOK, "status", "unread"},
+		{"GET", "/api/v1/keyvalue/lamp/state/exists", nil, http.StatusOK, "key", "lamp/state"},
+		{"GET", "/api/v1/keyvalue/lamp/state/history", nil, http.StatusOK, "key", "lamp/state"},
+		{"GET", "/api/v1/keyvalue/lamp/", nil, http.StatusOK, "prefix", "lamp/"},
+		{"GET", "/api/v1/keyvalue/", nil, http.StatusOK, "prefix", ""},
+		// A single segment is a key even if it is named like an action
+		{"GET", "/api/v1/keyvalue/status", nil, http.StatusOK, "key", "status"},
+		{"GET", "/api/v1/keyvalue/history/history", nil, http.StatusOK, "key", "history"},
+		{"PUT", "/api/v1/keyvalue/lamp/state/retention", map[string]any{"versions": 5}, http.StatusOK, "key", "lamp/state"},
+		{"PATCH", "/api/v1/keyvalue/lamp/state/hidden", map[string]any{"hidden": false}, http.StatusOK, "key", "lamp/state"},
+		{"POST", "/api/v1/keyvalue/lamp/state/rollback?version=1", nil, http.StatusOK, "key", "lamp/state"},
+		// Actions and paths without a handler for the method
+		{"DELETE", "/api/v1/keyvalue/lamp/state/history", nil, http.StatusMethodNotAllowed, "", ""},
+		{"PUT", "/api/v1/keyvalue/lamp/state/status", map[string]any{"value": "on"}, http.StatusMethodNotAllowed, "", ""},
+		{"POST", "/api/v1/keyvalue/lamp/state", map[string]any{"value": "on"}, http.StatusMethodNotAllowed, "", ""},
+		{"PUT", "/api/v1/keyvalue/lamp/", map[string]any{"value": "on"}, http.StatusMethodNotAllowed, "", ""},
+	}
+	for _, tt := range tests {
+		w := s.request(tt.method, tt.path, tt.body, nil)
+		assert.Equal(t, tt.status, w.Code, "%s %s: %s", tt.method, tt.path, w.Body.String())
+		if tt.field != "" {
+			assert.Equal(t, tt.want, decodeResponse(w)[tt.field], "%s %s", tt.method, tt.path)
+		}
+	}
+
+	// Keys can't end with an action, which would make them unreachable
+	w := s.request("POST", "/api/v1/keyvalue", map[string]any{"key": "lamp/history", "value": "on"}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = s.request("POST", "/api/v1/keyvalue/lamp/cas/cas", map[string]any{"expected_version": 0, "value": "on"}, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = s.request("DELETE", "/api/v1/keyvalue/lamp/", nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(2), decodeResponse(w)["deleted"])
+}
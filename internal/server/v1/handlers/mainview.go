@@ -8,10 +8,10 @@ import (
 )
 
 type MainViewHandler struct {
-	config *config.Config
+	config *config.Current
 }
 
-func NewMainViewHandler(cfg *config.Config) *MainViewHandler {
+func NewMainViewHandler(cfg *config.Current) *MainViewHandler {
 	return &MainViewHandler{config: cfg}
 }
 
@@ -21,6 +21,6 @@ func (h *MainViewHandler) SetupRoutes(router *gin.RouterGroup) {
 
 func (h *MainViewHandler) getMainView(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
-		"widgets": h.config.MainView.Widgets,
+		"widgets": h.config.Load().MainView.Widgets,
 	})
 }
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saintbyte/home-ctrl/internal/scheduler"
+)
+
+// SchedulerHandler pauses and resumes scheduled task runs
+type SchedulerHandler struct {
+	sched *scheduler.Scheduler
+}
+
+// NewSchedulerHandler creates a new scheduler handler
+func NewSchedulerHandler(sched *scheduler.Scheduler) *SchedulerHandler {
+	return &SchedulerHandler{sched: sched}
+}
+
+func (h *SchedulerHandler) SetupRoutes(router *gin.RouterGroup) {
+	schedulerGroup := router.Group("/scheduler")
+	{
+		schedulerGroup.GET("", h.getStatus)
+		schedulerGroup.POST("/pause", h.pause)
+		schedulerGroup.POST("/resume", h.resume)
+	}
+}
+
+// available responds with 503 if there is no scheduler
+func (h *SchedulerHandler) available(c *gin.Context) bool {
+	if h.sched == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error":   "Service Unavailable",
+			"message": "scheduler is not running",
+		})
+		return false
+	}
+	return true
+}
+
+// getStatus handles GET /scheduler
+func (h *SchedulerHandler) getStatus(c *gin.Context) {
+	if !h.available(c) {
+		return
+	}
+	c.JSON(http.StatusOK, h.sched.Status())
+}
+
+// pause handles POST /scheduler/pause
+func (h *SchedulerHandler) pause(c *gin.Context) {
+	if !h.available(c) {
+		return
+	}
+	if err := h.sched.Pause(); err != nil {
+		respondTaskError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Scheduled task runs paused",
+		"status":  h.sched.Status(),
+	})
+}
+
+// resume handles POST /scheduler/resume
+func (h *SchedulerHandler) resume(c *gin.Context) {
+	if !h.available(c) {
+		return
+	}
+	if err := h.sched.Resume(); err != nil {
+		respondTaskError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Scheduled task runs resumed",
+		"status":  h.sched.Status(),
+	})
+}
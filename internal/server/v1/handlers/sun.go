@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/saintbyte/home-ctrl/internal/config"
+	"github.com/saintbyte/home-ctrl/internal/solar"
+)
+
+// SunHandler reports sunrise and sunset times at the configured home coordinates
+type SunHandler struct {
+	config *config.Current
+}
+
+// NewSunHandler creates a new sun times handler
+func NewSunHandler(cfg *config.Current) *SunHandler {
+	return &SunHandler{config: cfg}
+}
+
+func (h *SunHandler) SetupRoutes(router *gin.RouterGroup) {
+	router.GET("/sun", h.getSunTimes)
+}
+
+// getSunTimes handles GET /sun?date=YYYY-MM-DD, defaulting to today
+func (h *SunHandler) getSunTimes(c *gin.Context) {
+	cfg := h.config.Load()
+	if !cfg.HasCoordinates() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error":   "Service Unavailable",
+			"message": "latitude and longitude are not configured",
+		})
+		return
+	}
+
+	loc := cfg.Location()
+	date := time.Now().In(loc)
+	if value := c.Query("date"); value != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", value, loc)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Bad Request",
+				"message": "date must be formatted as YYYY-MM-DD",
+			})
+			return
+		}
+		date = parsed
+	}
+
+	times := solar.Calculate(date, cfg.Latitude, cfg.Longitude)
+	response := gin.H{
+		"date":      times.Date,
+		"timezone":  loc.String(),
+		"latitude":  cfg.Latitude,
+		"longitude": cfg.Longitude,
+	}
+	for _, event := range solar.Events {
+		if at, ok := times.Get(event); ok {
+			response[string(event)] = at
+		} else {
+			response[string(event)] = nil
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
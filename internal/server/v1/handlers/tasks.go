@@ -1,21 +1,31 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/saintbyte/home-ctrl/internal/config"
+	"github.com/saintbyte/home-ctrl/internal/database"
+	"github.com/saintbyte/home-ctrl/internal/database/models"
 	"github.com/saintbyte/home-ctrl/internal/scheduler"
 )
 
 type TaskHandler struct {
-	config *config.Config
+	config *config.Current
+	db     *database.Database
 	sched  *scheduler.Scheduler
 }
 
-func NewTaskHandler(cfg *config.Config, sched *scheduler.Scheduler) *TaskHandler {
+func NewTaskHandler(cfg *config.Current, db *database.Database, sched *scheduler.Scheduler) *TaskHandler {
 	return &TaskHandler{
 		config: cfg,
+		db:     db,
 		sched:  sched,
 	}
 }
@@ -24,22 +34,201 @@ func (h *TaskHandler) SetupRoutes(router *gin.RouterGroup) {
 	taskGroup := router.Group("/tasks")
 	{
 		taskGroup.GET("", h.listTasks)
+		taskGroup.POST("", h.createTask)
+		taskGroup.POST("/validate-schedule", h.validateSchedule)
 		taskGroup.GET("/:name", h.getTask)
+		taskGroup.PUT("/:name", h.updateTask)
+		taskGroup.DELETE("/:name", h.deleteTask)
+		taskGroup.GET("/:name/runs", h.listTaskRuns)
+		taskGroup.GET("/:name/runs/:id/stream", h.streamTaskRun)
+		taskGroup.GET("/:name/chains", h.listTaskChains)
+		taskGroup.GET("/:name/next", h.nextRuns)
 		taskGroup.POST("/:name/run", h.runTask)
+		taskGroup.POST("/:name/cancel", h.cancelTask)
 		taskGroup.PATCH("/:name/enable", h.enableTask)
 		taskGroup.PATCH("/:name/disable", h.disableTask)
 	}
 }
 
-func (h *TaskHandler) listTasks(c *gin.Context) {
-	tasks := make([]gin.H, 0, len(h.config.Tasks))
-	for _, task := range h.config.Tasks {
-		tasks = append(tasks, gin.H{
-			"name":     task.Name,
-			"schedule": task.Schedule,
-			"enabled":  task.Enabled,
-			"command":  task.Command,
+// taskRequest is the body of POST /tasks and PUT /tasks/:name
+type taskRequest struct {
+	Name     string            `json:"name"`
+	Schedule string            `json:"schedule"`
+	Timezone string            `json:"timezone"`
+	Enabled  bool              `json:"enabled"`
+	Command  string            `json:"command" binding:"required"`
+	Agent    string            `json:"agent"`
+	Args     []string          `json:"args"`
+	Env      map[string]string `json:"env"`
+	WorkDir  string            `json:"workdir"`
+	Timeout  string            `json:"timeout"`
+	Overlap  string            `json:"overlap"`
+
+	RunAs    string            `json:"run_as"`
+	Limits   config.TaskLimits `json:"limits"`
+	Nice     int               `json:"nice"`
+	EnvAllow []string          `json:"env_allow"`
+
+	HTTP *config.TaskHTTP `json:"http"`
+
+	OutputKey  string `json:"output_key"`
+	OutputPath string `json:"output_path"`
+	OutputType string `json:"output_type"`
+
+	Params []config.TaskParam `json:"params"`
+
+	CatchUp         string `json:"catch_up"`
+	CatchUpLookback string `json:"catch_up_lookback"`
+
+	Retries         int    `json:"retries"`
+	RetryBackoff    string `json:"retry_backoff"`
+	RetryBackoffMax string `json:"retry_backoff_max"`
+	OnFailure       string `json:"on_failure"`
+
+	DependsOn    []string `json:"depends_on"`
+	OnSuccess    []string `json:"on_success"`
+	OnFailureRun []string `json:"on_failure_run"`
+}
+
+// toTask converts the request into a task definition
+func (r taskRequest) toTask() (config.Task, error) {
+	task := config.Task{
+		Name:         r.Name,
+		Schedule:     r.Schedule,
+		Timezone:     r.Timezone,
+		Enabled:      r.Enabled,
+		Command:      r.Command,
+		Agent:        r.Agent,
+		Args:         r.Args,
+		Env:          r.Env,
+		WorkDir:      r.WorkDir,
+		RunAs:        r.RunAs,
+		Limits:       r.Limits,
+		Nice:         r.Nice,
+		EnvAllow:     r.EnvAllow,
+		HTTP:         r.HTTP,
+		OutputKey:    r.OutputKey,
+		OutputPath:   r.OutputPath,
+		OutputType:   r.OutputType,
+		Overlap:      r.Overlap,
+		Params:       r.Params,
+		CatchUp:      r.CatchUp,
+		Retries:      r.Retries,
+		OnFailure:    r.OnFailure,
+		DependsOn:    r.DependsOn,
+		OnSuccess:    r.OnSuccess,
+		OnFailureRun: r.OnFailureRun,
+	}
+
+	durations := []struct {
+		field string
+		value string
+		dest  *time.Duration
+	}{
+		{"timeout", r.Timeout, &task.Timeout},
+		{"catch_up_lookback", r.CatchUpLookback, &task.CatchUpLookback},
+		{"retry_backoff", r.RetryBackoff, &task.RetryBackoff},
+		{"retry_backoff_max", r.RetryBackoffMax, &task.RetryBackoffMax},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		value, err := time.ParseDuration(d.value)
+		if err != nil {
+			return task, fmt.Errorf("invalid %s: %w", d.field, err)
+		}
+		*d.dest = value
+	}
+	return task, nil
+}
+
+// durationString formats an optional duration for API responses
+func durationString(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return d.String()
+}
+
+// tasks returns the tasks known to the scheduler, or the config tasks without one
+func (h *TaskHandler) tasks() []scheduler.TaskInfo {
+	if h.sched != nil {
+		return h.sched.GetTasks()
+	}
+
+	cfg := h.config.Load()
+	tasks := make([]scheduler.TaskInfo, 0, len(cfg.Tasks))
+	for _, task := range cfg.Tasks {
+		tasks = append(tasks, scheduler.TaskInfo{Task: task, ReadOnly: true})
+	}
+	return tasks
+}
+
+// findTask looks up a task by name
+func (h *TaskHandler) findTask(name string) (scheduler.TaskInfo, bool) {
+	for _, task := range h.tasks() {
+		if task.Name == name {
+			return task, true
+		}
+	}
+	return scheduler.TaskInfo{}, false
+}
+
+// respondTaskError maps scheduler errors to HTTP responses
+func respondTaskError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, scheduler.ErrTaskNotFound):
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "Not Found",
+			"message": err.Error(),
+		})
+	case errors.Is(err, scheduler.ErrTaskExists), errors.Is(err, scheduler.ErrTaskRunning),
+		errors.Is(err, scheduler.ErrTaskNotRunning), errors.Is(err, scheduler.ErrTaskInUse):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Conflict",
+			"message": err.Error(),
+		})
+	case errors.Is(err, scheduler.ErrTaskReadOnly):
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":   "Forbidden",
+			"message": err.Error(),
+		})
+	case errors.Is(err, scheduler.ErrSchedulerStopped):
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error":   "Service Unavailable",
+			"message": err.Error(),
+		})
+	case errors.Is(err, scheduler.ErrInvalidTask), errors.Is(err, scheduler.ErrInvalidArgs):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Internal Server Error",
+			"message": err.Error(),
+		})
+	}
+}
+
+// requireScheduler responds with 503 when task management is unavailable
+func (h *TaskHandler) requireScheduler(c *gin.Context) bool {
+	if h.sched == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error":   "Service Unavailable",
+			"message": "Scheduler is not running",
 		})
+		return false
+	}
+	return true
+}
+
+func (h *TaskHandler) listTasks(c *gin.Context) {
+	infos := h.tasks()
+	tasks := make([]gin.H, 0, len(infos))
+	for _, task := range infos {
+		tasks = append(tasks, h.taskResponse(task))
 	}
 	c.JSON(http.StatusOK, gin.H{
 		"tasks": tasks,
@@ -50,16 +239,9 @@ func (h *TaskHandler) listTasks(c *gin.Context) {
 func (h *TaskHandler) getTask(c *gin.Context) {
 	name := c.Param("name")
 
-	for _, task := range h.config.Tasks {
-		if task.Name == name {
-			c.JSON(http.StatusOK, gin.H{
-				"name":     task.Name,
-				"schedule": task.Schedule,
-				"enabled":  task.Enabled,
-				"command":  task.Command,
-			})
-			return
-		}
+	if task, ok := h.findTask(name); ok {
+		c.JSON(http.StatusOK, h.taskResponse(task))
+		return
 	}
 
 	c.JSON(http.StatusNotFound, gin.H{
@@ -68,27 +250,413 @@ func (h *TaskHandler) getTask(c *gin.Context) {
 	})
 }
 
+// createTask handles POST /tasks
+func (h *TaskHandler) createTask(c *gin.Context) {
+	if !h.requireScheduler(c) {
+		return
+	}
+
+	var req taskRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	task, err := req.toTask()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if err := h.sched.CreateTask(task); err != nil {
+		respondTaskError(c, err)
+		return
+	}
+
+	info, _ := h.findTask(task.Name)
+	c.JSON(http.StatusCreated, h.taskResponse(info))
+}
+
+// updateTask handles PUT /tasks/:name
+func (h *TaskHandler) updateTask(c *gin.Context) {
+	name := c.Param("name")
+	if !h.requireScheduler(c) {
+		return
+	}
+
+	var req taskRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+		return
+	}
+	if req.Name != "" && req.Name != name {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": "Task name cannot be changed",
+		})
+		return
+	}
+	req.Name = name
+
+	task, err := req.toTask()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if err := h.sched.UpdateTask(task); err != nil {
+		respondTaskError(c, err)
+		return
+	}
+
+	info, _ := h.findTask(name)
+	c.JSON(http.StatusOK, h.taskResponse(info))
+}
+
+// deleteTask handles DELETE /tasks/:name
+func (h *TaskHandler) deleteTask(c *gin.Context) {
+	name := c.Param("name")
+	if !h.requireScheduler(c) {
+		return
+	}
+
+	if err := h.sched.DeleteTask(name); err != nil {
+		respondTaskError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Task deleted successfully",
+		"name":    name,
+	})
+}
+
+// taskResponse builds the API representation of a task including its last run
+func (h *TaskHandler) taskResponse(task scheduler.TaskInfo) gin.H {
+	overlap := task.Overlap
+	if overlap == "" {
+		overlap = scheduler.OverlapAllow
+	}
+	catchUp := task.CatchUp
+	if catchUp == "" {
+		catchUp = scheduler.CatchUpNone
+	}
+	outputType := task.OutputType
+	if outputType == "" {
+		outputType = models.ValueTypeString
+	}
+	onFailure := task.OnFailure
+	if onFailure == "" {
+		onFailure = scheduler.OnFailureContinue
+	}
+	instances := task.Instances
+	if instances == nil {
+		instances = []scheduler.Instance{}
+	}
+	params := task.Params
+	if params == nil {
+		params = []config.TaskParam{}
+	}
+
+	response := gin.H{
+		"name":               task.Name,
+		"schedule":           task.Schedule,
+		"timezone":           task.Timezone,
+		"effective_timezone": task.EffectiveTimezone,
+		"enabled":            task.Enabled,
+		"command":            task.Command,
+		"agent":              task.Agent,
+		"args":               task.Args,
+		"env":                task.Env,
+		"workdir":            task.WorkDir,
+		"timeout":            durationString(task.Timeout),
+		"run_as":             task.RunAs,
+		"limits":             task.Limits,
+		"nice":               task.Nice,
+		"env_allow":          task.EnvAllow,
+		"http":               task.HTTP,
+		"output_key":         task.OutputKey,
+		"output_path":        task.OutputPath,
+		"output_type":        outputType,
+		"overlap":            overlap,
+		"catch_up":           catchUp,
+		"catch_up_lookback":  durationString(task.CatchUpLookback),
+		"params":             params,
+		"retries":            task.Retries,
+		"retry_backoff":      durationString(task.RetryBackoff),
+		"retry_backoff_max":  durationString(task.RetryBackoffMax),
+		"on_failure":         onFailure,
+		"depends_on":         task.DependsOn,
+		"on_success":         task.OnSuccess,
+		"on_failure_run":     task.OnFailureRun,
+		"read_only":          task.ReadOnly,
+		"instances":          instances,
+		"last_run":           nil,
+		"last_status":        nil,
+		"last_attempt":       nil,
+	}
+
+	if h.db != nil {
+		if run, err := h.db.GetLastTaskRun(task.Name); err == nil && run != nil {
+			response["last_run"] = run.StartedAt
+			response["last_status"] = run.Status
+			response["last_attempt"] = run.Attempt
+		}
+	}
+
+	return response
+}
+
+// listTaskRuns handles GET /tasks/:name/runs
+func (h *TaskHandler) listTaskRuns(c *gin.Context) {
+	name := c.Param("name")
+
+	if _, ok := h.findTask(name); !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "Not Found",
+			"message": "Task not found",
+		})
+		return
+	}
+
+	limit, offset, ok := pagingParams(c)
+	if !ok {
+		return
+	}
+
+	runs, total, err := h.db.ListTaskRuns(name, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Internal Server Error",
+			"message": "Failed to list task runs",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"runs":   runs,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// streamPollInterval is how often a stream checks whether a queued run has started
+const streamPollInterval = 500 * time.Millisecond
+
+// streamTaskRun handles GET /tasks/:name/runs/:id/stream. It streams the output
+// of a running run as Server-Sent Events, followed by its final status.
+// Finished runs replay their stored output, which combines stdout and stderr,
+// so its lines are sent without a stream.
+func (h *TaskHandler) streamTaskRun(c *gin.Context) {
+	name := c.Param("name")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": "Invalid run ID",
+		})
+		return
+	}
+
+	run, err := h.db.GetTaskRun(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Internal Server Error",
+			"message": "Failed to get task run",
+		})
+		return
+	}
+	if run == nil || run.TaskName != name {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "Not Found",
+			"message": "Task run not found",
+		})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	ctx := c.Request.Context()
+	sentOutput := false
+	for {
+		if h.sched != nil {
+			if history, events, unsubscribe, ok := h.sched.SubscribeRun(id); ok {
+				for _, event := range history {
+					sendRunEvent(c, event)
+					sentOutput = true
+				}
+				finished := false
+				for !finished {
+					select {
+					case event, open := <-events:
+						if !open {
+							finished = true
+							continue
+						}
+						sendRunEvent(c, event)
+						if event.Type == scheduler.RunEventStatus {
+							unsubscribe()
+							return
+						}
+						sentOutput = true
+					case <-ctx.Done():
+						unsubscribe()
+						return
+					}
+				}
+				unsubscribe()
+			}
+		}
+
+		run, err = h.db.GetTaskRun(id)
+		if err != nil || run == nil {
+			return
+		}
+		if run.FinishedAt != nil {
+			if !sentOutput {
+				for _, line := range scheduler.OutputLines(run.Output) {
+					sendRunEvent(c, scheduler.RunEvent{Type: scheduler.RunEventOutput, Line: line})
+				}
+			}
+			sendRunEvent(c, scheduler.RunEvent{Type: scheduler.RunEventStatus, Status: run.Status, ExitCode: run.ExitCode})
+			return
+		}
+
+		// Queued or waiting for a retry
+		select {
+		case <-time.After(streamPollInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sendRunEvent writes a run event as a Server-Sent Event and flushes it
+func sendRunEvent(c *gin.Context, event scheduler.RunEvent) {
+	data := gin.H{"line": event.Line}
+	if event.Stream != "" {
+		data["stream"] = event.Stream
+	}
+	if event.Type == scheduler.RunEventStatus {
+		data = gin.H{"status": event.Status, "exit_code": event.ExitCode}
+	}
+	c.SSEvent(event.Type, data)
+	c.Writer.Flush()
+}
+
+// listTaskChains handles GET /tasks/:name/chains
+func (h *TaskHandler) listTaskChains(c *gin.Context) {
+	name := c.Param("name")
+
+	if _, ok := h.findTask(name); !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":   "Not Found",
+			"message": "Task not found",
+		})
+		return
+	}
+
+	limit, offset, ok := pagingParams(c)
+	if !ok {
+		return
+	}
+
+	chains, total, err := h.db.ListTaskChains(name, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Internal Server Error",
+			"message": "Failed to list task chains",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"chains": chains,
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+	})
+}
+
+// pagingParams parses the limit and offset query parameters,
+// responding with 400 if they are invalid
+func pagingParams(c *gin.Context) (int, int, bool) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": "limit must be between 1 and 100",
+		})
+		return 0, 0, false
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": "offset must be a non-negative integer",
+		})
+		return 0, 0, false
+	}
+	return limit, offset, true
+}
+
+// runTask handles POST /:name/run with an optional JSON object of arguments
+// for the parameters of the task
 func (h *TaskHandler) runTask(c *gin.Context) {
 	name := c.Param("name")
 
+	var args map[string]any
+	if c.Request.ContentLength != 0 {
+		if err := json.NewDecoder(c.Request.Body).Decode(&args); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Bad Request",
+				"message": "arguments must be a JSON object",
+			})
+			return
+		}
+	}
+
 	if h.sched != nil {
-		err := h.sched.RunTask(name)
+		run, err := h.sched.RunTask(name, args)
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{
-				"error":   "Not Found",
-				"message": err.Error(),
-			})
+			respondTaskError(c, err)
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{
+		response := gin.H{
 			"message": "Task triggered",
 			"name":    name,
 			"status":  "running",
-		})
+		}
+		if run != nil {
+			response["run_id"] = run.ID
+			if run.Params != nil {
+				response["params"] = run.Params
+			}
+			if run.ChainID != nil {
+				response["chain_id"] = *run.ChainID
+			}
+		}
+		c.JSON(http.StatusOK, response)
 		return
 	}
 
-	for _, task := range h.config.Tasks {
+	for _, task := range h.config.Load().Tasks {
 		if task.Name == name {
 			c.JSON(http.StatusOK, gin.H{
 				"message": "Task triggered",
@@ -105,16 +673,33 @@ func (h *TaskHandler) runTask(c *gin.Context) {
 	})
 }
 
+// cancelTask handles POST /tasks/:name/cancel
+func (h *TaskHandler) cancelTask(c *gin.Context) {
+	if !h.requireScheduler(c) {
+		return
+	}
+
+	name := c.Param("name")
+	cancelled, err := h.sched.CancelTask(name)
+	if err != nil {
+		respondTaskError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Task runs cancelled",
+		"name":      name,
+		"cancelled": cancelled,
+	})
+}
+
 func (h *TaskHandler) enableTask(c *gin.Context) {
 	name := c.Param("name")
 
 	if h.sched != nil {
 		err := h.sched.EnableTask(name)
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{
-				"error":   "Not Found",
-				"message": err.Error(),
-			})
+			respondTaskError(c, err)
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{
@@ -124,9 +709,10 @@ func (h *TaskHandler) enableTask(c *gin.Context) {
 		return
 	}
 
-	for i, task := range h.config.Tasks {
+	cfg := h.config.Load()
+	for i, task := range cfg.Tasks {
 		if task.Name == name {
-			h.config.Tasks[i].Enabled = true
+			cfg.Tasks[i].Enabled = true
 			c.JSON(http.StatusOK, gin.H{
 				"name":    name,
 				"enabled": true,
@@ -147,10 +733,7 @@ func (h *TaskHandler) disableTask(c *gin.Context) {
 	if h.sched != nil {
 		err := h.sched.DisableTask(name)
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{
-				"error":   "Not Found",
-				"message": err.Error(),
-			})
+			respondTaskError(c, err)
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{
@@ -160,9 +743,10 @@ func (h *TaskHandler) disableTask(c *gin.Context) {
 		return
 	}
 
-	for i, task := range h.config.Tasks {
+	cfg := h.config.Load()
+	for i, task := range cfg.Tasks {
 		if task.Name == name {
-			h.config.Tasks[i].Enabled = false
+			cfg.Tasks[i].Enabled = false
 			c.JSON(http.StatusOK, gin.H{
 				"name":    name,
 				"enabled": false,
@@ -176,3 +760,99 @@ func (h *TaskHandler) disableTask(c *gin.Context) {
 		"message": "Task not found",
 	})
 }
+
+// previewCount parses the number of fire times to preview,
+// responding with 400 if it is invalid
+func previewCount(c *gin.Context, value string) (int, bool) {
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 1 || count > scheduler.MaxPreviewRuns {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": fmt.Sprintf("count must be between 1 and %d", scheduler.MaxPreviewRuns),
+		})
+		return 0, false
+	}
+	return count, true
+}
+
+// nextRuns handles GET /tasks/:name/next?count=N
+func (h *TaskHandler) nextRuns(c *gin.Context) {
+	if !h.requireScheduler(c) {
+		return
+	}
+
+	name := c.Param("name")
+	count, ok := previewCount(c, c.DefaultQuery("count", "5"))
+	if !ok {
+		return
+	}
+
+	task, err := h.sched.GetTask(name)
+	if err != nil {
+		respondTaskError(c, err)
+		return
+	}
+	next, err := h.sched.NextRuns(name, count)
+	if err != nil {
+		respondTaskError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"name":        name,
+		"schedule":    task.Schedule,
+		"timezone":    task.EffectiveTimezone,
+		"description": scheduler.DescribeSchedule(task.Schedule),
+		"scheduled":   len(next) > 0,
+		"next":        next,
+	})
+}
+
+// validateScheduleRequest is the body of POST /tasks/validate-schedule
+type validateScheduleRequest struct {
+	Schedule string `json:"schedule" binding:"required"`
+	Timezone string `json:"timezone"`
+	Count    int    `json:"count"`
+}
+
+// validateSchedule handles POST /tasks/validate-schedule
+func (h *TaskHandler) validateSchedule(c *gin.Context) {
+	if !h.requireScheduler(c) {
+		return
+	}
+
+	var req validateScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": err.Error(),
+		})
+		return
+	}
+	if req.Count == 0 {
+		req.Count = 5
+	}
+	count, ok := previewCount(c, strconv.Itoa(req.Count))
+	if !ok {
+		return
+	}
+
+	next, err := h.sched.PreviewSchedule(req.Schedule, req.Timezone, count)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Bad Request",
+			"message": err.Error(),
+			"valid":   false,
+		})
+		return
+	}
+
+	timezone := h.sched.EffectiveTimezone(config.Task{Timezone: req.Timezone})
+	c.JSON(http.StatusOK, gin.H{
+		"valid":       true,
+		"schedule":    req.Schedule,
+		"timezone":    timezone,
+		"description": scheduler.DescribeSchedule(req.Schedule),
+		"next":        next,
+	})
+}
@@ -275,9 +275,13 @@ func TestKeyValueHandlerListKeyValues(t *testing.T) {
 
 	// Assert successful listing
 	assert.Equal(t, http.StatusOK, w.Code)
-	var response []map[string]interface{}
+	var response struct {
+		KeyValues []map[string]interface{} `json:"key_values"`
+		Total     int                      `json:"total"`
+	}
 	json.Unmarshal(w.Body.Bytes(), &response)
-	assert.Len(t, response, 2) // Should have 2 non-hidden items
+	assert.Len(t, response.KeyValues, 2) // Should have 2 non-hidden items
+	assert.Equal(t, 2, response.Total)
 
 	// Test listing key-value pairs (with hidden)
 	w = httptest.NewRecorder()
@@ -288,5 +292,6 @@ func TestKeyValueHandlerListKeyValues(t *testing.T) {
 	// Assert successful listing with hidden
 	assert.Equal(t, http.StatusOK, w.Code)
 	json.Unmarshal(w.Body.Bytes(), &response)
-	assert.Len(t, response, 3) // Should have 3 items including hidden
+	assert.Len(t, response.KeyValues, 3) // Should have 3 items including hidden
+	assert.Equal(t, 3, response.Total)
 }
@@ -4,14 +4,17 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/saintbyte/home-ctrl/internal/scheduler"
 )
 
 // HealthHandler handles the health check endpoint
-type HealthHandler struct{}
+type HealthHandler struct {
+	sched *scheduler.Scheduler
+}
 
-// NewHealthHandler creates a new health handler
-func NewHealthHandler() *HealthHandler {
-	return &HealthHandler{}
+// NewHealthHandler creates a new health handler. sched may be nil.
+func NewHealthHandler(sched *scheduler.Scheduler) *HealthHandler {
+	return &HealthHandler{sched: sched}
 }
 
 // SetupRoutes sets up health-related routes
@@ -21,8 +24,12 @@ func (h *HealthHandler) SetupRoutes(router *gin.RouterGroup) {
 
 // healthCheck handles GET /health
 func (h *HealthHandler) healthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"status":  "ok",
 		"message": "Service is running",
-	})
-}
\ No newline at end of file
+	}
+	if h.sched != nil {
+		response["scheduler"] = h.sched.LeaderStatus()
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -2,6 +2,7 @@ package v1
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/saintbyte/home-ctrl/internal/agent"
 	"github.com/saintbyte/home-ctrl/internal/auth"
 	"github.com/saintbyte/home-ctrl/internal/config"
 	"github.com/saintbyte/home-ctrl/internal/database"
@@ -11,21 +12,23 @@ import (
 
 // Router represents the v1 API router
 type Router struct {
-	config   *config.Config
+	config   *config.Current
 	auth     *auth.Auth
 	database *database.Database
 	router   *gin.Engine
 	sched    *scheduler.Scheduler
+	agents   *agent.Hub
 }
 
 // NewRouter creates a new v1 router
-func NewRouter(cfg *config.Config, authService *auth.Auth, db *database.Database, sched *scheduler.Scheduler) *Router {
+func NewRouter(cfg *config.Current, authService *auth.Auth, db *database.Database, sched *scheduler.Scheduler, agents *agent.Hub) *Router {
 	return &Router{
 		config:   cfg,
 		auth:     authService,
 		database: db,
 		router:   gin.Default(),
 		sched:    sched,
+		agents:   agents,
 	}
 }
 
@@ -53,11 +56,16 @@ func (r *Router) SetupRoutes() {
 func (r *Router) setupPublicRoutes() {
 	publicGroup := r.router.Group("/api/v1")
 
-	healthHandler := NewHealthHandler()
+	healthHandler := NewHealthHandler(r.sched)
 	healthHandler.SetupRoutes(publicGroup)
 
 	versionHandler := NewVersionHandler()
 	versionHandler.SetupRoutes(publicGroup)
+
+	// Agents authenticate with their own token in the WebSocket handshake
+	if r.agents != nil {
+		r.router.GET(agent.ConnectPath, gin.WrapH(r.agents.Handler()))
+	}
 }
 
 // setupAuthRoutes sets up authentication-related routes
@@ -86,8 +94,17 @@ func (r *Router) setupProtectedRoutes() {
 	mainViewHandler := handlers.NewMainViewHandler(r.config)
 	mainViewHandler.SetupRoutes(protectedGroup)
 
-	taskHandler := handlers.NewTaskHandler(r.config, r.sched)
+	taskHandler := handlers.NewTaskHandler(r.config, r.database, r.sched)
 	taskHandler.SetupRoutes(protectedGroup)
+
+	sunHandler := handlers.NewSunHandler(r.config)
+	sunHandler.SetupRoutes(protectedGroup)
+
+	schedulerHandler := handlers.NewSchedulerHandler(r.sched)
+	schedulerHandler.SetupRoutes(protectedGroup)
+
+	agentHandler := handlers.NewAgentHandler(r.agents)
+	agentHandler.SetupRoutes(protectedGroup)
 }
 
 // SetupRoutesOn sets up routes on a specific router
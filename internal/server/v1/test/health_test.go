@@ -18,7 +18,7 @@ func TestHealthHandler(t *testing.T) {
 	c, _ := gin.CreateTestContext(w)
 
 	// Create handler and router group
-	handler := v1.NewHealthHandler()
+	handler := v1.NewHealthHandler(nil)
 	group := gin.New()
 
 	// Setup routes
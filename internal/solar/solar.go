@@ -0,0 +1,147 @@
+// Package solar calculates sunrise, sunset and twilight times offline using
+// the NOAA sunrise equation. Results are accurate to about a minute, which
+// is plenty for switching lights and blinds.
+package solar
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Event is a daily solar event
+type Event string
+
+const (
+	Dawn    Event = "dawn"    // civil dawn, sun 6° below the horizon
+	Sunrise Event = "sunrise" // upper limb of the sun on the horizon
+	Noon    Event = "noon"    // sun at its highest point
+	Sunset  Event = "sunset"
+	Dusk    Event = "dusk" // civil dusk, sun 6° below the horizon
+)
+
+// Events lists all supported events in the order they occur during a day
+var Events = []Event{Dawn, Sunrise, Noon, Sunset, Dusk}
+
+// ParseEvent returns the event with the given name
+func ParseEvent(name string) (Event, error) {
+	for _, event := range Events {
+		if string(event) == name {
+			return event, nil
+		}
+	}
+	return "", fmt.Errorf("unknown solar event %q", name)
+}
+
+// Sun elevations in degrees at which the events occur
+const (
+	sunriseElevation  = -0.833 // accounts for refraction and the solar disc radius
+	twilightElevation = -6.0
+)
+
+const (
+	julianUnixEpoch = 2440587.5 // Julian date of 1970-01-01T00:00:00Z
+	julian2000      = 2451545.0 // Julian date of 2000-01-01T12:00:00Z
+)
+
+// Times holds the solar events of one day. An event that doesn't occur on
+// that day, e.g. sunrise during the polar night, is the zero time.
+type Times struct {
+	Date    string    `json:"date"`
+	Dawn    time.Time `json:"dawn"`
+	Sunrise time.Time `json:"sunrise"`
+	Noon    time.Time `json:"noon"`
+	Sunset  time.Time `json:"sunset"`
+	Dusk    time.Time `json:"dusk"`
+}
+
+// Get returns the time of an event and whether it occurs on that day
+func (t Times) Get(event Event) (time.Time, bool) {
+	var at time.Time
+	switch event {
+	case Dawn:
+		at = t.Dawn
+	case Sunrise:
+		at = t.Sunrise
+	case Noon:
+		at = t.Noon
+	case Sunset:
+		at = t.Sunset
+	case Dusk:
+		at = t.Dusk
+	}
+	return at, !at.IsZero()
+}
+
+// Calculate returns the solar events of the calendar day of date, in the
+// location of date, at the given coordinates in degrees (north and east positive)
+func Calculate(date time.Time, latitude, longitude float64) Times {
+	loc := date.Location()
+	year, month, day := date.Date()
+	localNoon := time.Date(year, month, day, 12, 0, 0, 0, loc)
+
+	// Julian cycle whose solar transit is closest to local noon
+	n := math.Round(toJulian(localNoon) - julian2000 + longitude/360)
+	meanSolarTime := n - longitude/360
+
+	anomaly := normalizeDegrees(357.5291 + 0.98560028*meanSolarTime)
+	m := radians(anomaly)
+	center := 1.9148*math.Sin(m) + 0.0200*math.Sin(2*m) + 0.0003*math.Sin(3*m)
+	eclipticLongitude := radians(normalizeDegrees(anomaly + center + 180 + 102.9372))
+	transit := julian2000 + meanSolarTime + 0.0053*math.Sin(m) - 0.0069*math.Sin(2*eclipticLongitude)
+
+	declination := math.Asin(math.Sin(eclipticLongitude) * math.Sin(radians(23.4397)))
+
+	times := Times{
+		Date: localNoon.Format("2006-01-02"),
+		Noon: fromJulian(transit).In(loc),
+	}
+	if rise, set, ok := crossing(transit, latitude, declination, sunriseElevation); ok {
+		times.Sunrise = rise.In(loc)
+		times.Sunset = set.In(loc)
+	}
+	if dawn, dusk, ok := crossing(transit, latitude, declination, twilightElevation); ok {
+		times.Dawn = dawn.In(loc)
+		times.Dusk = dusk.In(loc)
+	}
+	return times
+}
+
+// crossing returns the times the sun passes the given elevation before and
+// after its transit, or false if it stays above or below it all day
+func crossing(transit, latitude, declination, elevation float64) (time.Time, time.Time, bool) {
+	phi := radians(latitude)
+	cosHourAngle := (math.Sin(radians(elevation)) - math.Sin(phi)*math.Sin(declination)) /
+		(math.Cos(phi) * math.Cos(declination))
+	if cosHourAngle < -1 || cosHourAngle > 1 {
+		return time.Time{}, time.Time{}, false
+	}
+
+	hourAngle := degrees(math.Acos(cosHourAngle)) / 360
+	return fromJulian(transit - hourAngle), fromJulian(transit + hourAngle), true
+}
+
+func toJulian(t time.Time) float64 {
+	return float64(t.Unix())/86400 + julianUnixEpoch
+}
+
+func fromJulian(j float64) time.Time {
+	seconds := (j - julianUnixEpoch) * 86400
+	return time.Unix(0, int64(seconds*float64(time.Second))).UTC().Truncate(time.Second)
+}
+
+func normalizeDegrees(d float64) float64 {
+	d = math.Mod(d, 360)
+	if d < 0 {
+		d += 360
+	}
+	return d
+}
+
+func radians(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+func degrees(r float64) float64 {
+	return r * 180 / math.Pi
+}
@@ -0,0 +1,79 @@
+package solar
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalculate(t *testing.T) {
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		t.Skipf("Timezone data not available: %v", err)
+	}
+
+	// Reference times from the NOAA solar calculator
+	tests := []struct {
+		name      string
+		date      time.Time
+		latitude  float64
+		longitude float64
+		sunrise   string
+		sunset    string
+	}{
+		{"moscow summer", time.Date(2024, 6, 21, 0, 0, 0, 0, moscow), 55.7558, 37.6173, "03:44", "21:18"},
+		{"moscow winter", time.Date(2024, 12, 21, 0, 0, 0, 0, moscow), 55.7558, 37.6173, "08:59", "15:58"},
+		{"equator", time.Date(2024, 3, 20, 0, 0, 0, 0, time.UTC), 0, 0, "06:04", "18:11"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			times := Calculate(tt.date, tt.latitude, tt.longitude)
+			assertClose(t, "sunrise", times.Sunrise, tt.date, tt.sunrise)
+			assertClose(t, "sunset", times.Sunset, tt.date, tt.sunset)
+			if !times.Dawn.Before(times.Sunrise) || !times.Dusk.After(times.Sunset) {
+				t.Errorf("Expected twilight around daylight, got %+v", times)
+			}
+			if times.Date != tt.date.Format("2006-01-02") {
+				t.Errorf("Expected date %s, got %s", tt.date.Format("2006-01-02"), times.Date)
+			}
+		})
+	}
+}
+
+func TestCalculatePolar(t *testing.T) {
+	// Svalbard has no sunset in June and no sunrise in December
+	summer := Calculate(time.Date(2024, 6, 21, 0, 0, 0, 0, time.UTC), 78.22, 15.65)
+	if _, ok := summer.Get(Sunset); ok {
+		t.Errorf("Expected no sunset during the polar day, got %s", summer.Sunset)
+	}
+	winter := Calculate(time.Date(2024, 12, 21, 0, 0, 0, 0, time.UTC), 78.22, 15.65)
+	if _, ok := winter.Get(Sunrise); ok {
+		t.Errorf("Expected no sunrise during the polar night, got %s", winter.Sunrise)
+	}
+	if _, ok := winter.Get(Noon); !ok {
+		t.Error("Expected solar noon every day")
+	}
+}
+
+func TestParseEvent(t *testing.T) {
+	if event, err := ParseEvent("sunset"); err != nil || event != Sunset {
+		t.Errorf("ParseEvent(sunset) = %v, %v", event, err)
+	}
+	if _, err := ParseEvent("moonrise"); err == nil {
+		t.Error("Expected error for unknown event")
+	}
+}
+
+// assertClose checks that a time is within two minutes of the expected HH:MM on day
+func assertClose(t *testing.T, name string, got, day time.Time, expected string) {
+	t.Helper()
+
+	clock, err := time.Parse("15:04", expected)
+	if err != nil {
+		t.Fatalf("Invalid expected time %s", expected)
+	}
+	want := time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, day.Location())
+	if diff := got.Sub(want); diff < -2*time.Minute || diff > 2*time.Minute {
+		t.Errorf("Expected %s at %s, got %s", name, want.Format(time.RFC3339), got.Format(time.RFC3339))
+	}
+}